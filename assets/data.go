@@ -1,6 +1,26 @@
 package assets
 
-import _ "embed"
+import (
+	_ "embed"
+
+	"golang.org/x/image/font/gofont/gomono"
+)
 
 //go:embed DejaVuSans.ttf
 var DejaVuSansTTF []byte
+
+// GoMonoTTF is a monospace font which clearly distinguishes similar characters like 0 and O.
+// Used for text which should be typed or read by OCR.
+var GoMonoTTF = gomono.TTF
+
+// EFFLargeWordlist is the EFF large wordlist for diceware passphrases, one "<dice rolls>\t<word>" per line.
+// Source: https://www.eff.org/files/2016/07/18/eff_large_wordlist.txt (CC BY 3.0 US)
+//
+//go:embed eff_large_wordlist.txt
+var EFFLargeWordlist string
+
+// ReferenceDecoder is the source of a minimal decoder which only depends on the Go standard library.
+// Printed by encode --include-decoder, so sheets can be decoded even if this project is no longer available.
+//
+//go:embed decoder/main.go
+var ReferenceDecoder string
@@ -0,0 +1,1276 @@
+// Command decoder is a minimal reference decoder for encrypted-paper sheets.
+// It only uses the Go standard library, so it can be typed from paper and built
+// with any Go version since 1.21, even if encrypted-paper is no longer available.
+//
+// Usage:
+//
+//	go run decoder.go -o output_file [-keyfile file] input_file ...
+//	go run decoder.go -checksums decoder.go
+//
+// Each input file contains either the raw payload of a single QR code, as returned
+// by a QR code reader, or the text printed by encode --text. The password is read
+// from environment variable PASSWORD or from stdin.
+//
+// Only sheets encrypted with a password (and optional keyfile) are supported:
+// Argon2id, XChaCha20-Poly1305 and xz. All data QR codes are required, as parity
+// QR codes are not used. Use -checksums to compare a typed copy of this source
+// with the printed checksums.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"hash/crc64"
+	"math/big"
+	"math/bits"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxFormatVersion is the latest format version supported by this decoder
+const maxFormatVersion = 1
+
+func main() {
+	output := flag.String("o", "", "Output file")
+	keyfile := flag.String("keyfile", "", "Keyfile, if one was used during encode")
+	checksums := flag.String("checksums", "", "Print the line checksums of this file")
+	flag.Parse()
+	var err error
+	switch {
+	case *checksums != "":
+		err = printChecksums(*checksums)
+	case *output != "" && flag.NArg() > 0:
+		err = run(*output, *keyfile, flag.Args())
+	default:
+		fmt.Fprintln(os.Stderr, "usage: decoder -o output_file [-keyfile file] input_file ...")
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// run combines the QR codes, decrypts and decompresses the data and writes it to the output
+func run(outputPath, keyfilePath string, inputPaths []string) error {
+	// Read payloads
+	var payloads [][]byte
+	for _, path := range inputPaths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		textPayloads, err := parseText(content)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if textPayloads == nil {
+			textPayloads = [][]byte{content} // Raw payload
+		}
+		payloads = append(payloads, textPayloads...)
+	}
+
+	// Combine QR codes
+	header, data, err := combine(payloads)
+	if err != nil {
+		return err
+	}
+	version := uintField(header, "version", 0)
+	if version > maxFormatVersion {
+		return fmt.Errorf("format version %d is not supported", version)
+	}
+	compression := uintField(header, "compression", 1)
+	kdfAlgo := uintField(header, "kdf_algo", 1)
+	aead := uintField(header, "aead", 1)
+	if compression != 1 || kdfAlgo != 1 || (aead != 1 && aead != 3) {
+		return errors.New("only sheets encrypted with a password are supported")
+	}
+
+	// Derive key
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	secret := []byte(password)
+	if keyfilePath != "" {
+		keyfile, err := os.ReadFile(keyfilePath)
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(keyfile)
+		secret = append(hash[:], secret...)
+	}
+	salt, _ := header["salt"].([]byte)
+	kdf, _ := header["kdf"].(map[string]any)
+	key := argon2id(secret, salt,
+		uint32(uintField(kdf, "time", 1)),
+		uint32(uintField(kdf, "memory", 64*1024)),
+		uint32(uintField(kdf, "threads", 4)),
+		uint32(uintField(kdf, "key_length", 32)))
+
+	// Decrypt
+	var compressed []byte
+	ad := associatedData(header)
+	if aead == 3 {
+		compressed, err = decryptStream(key, data, ad)
+	} else if len(data) < 24 {
+		err = errors.New("encrypted data is too short")
+	} else {
+		compressed, err = openXChaCha20Poly1305(key, data[:24], data[24:], ad)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Decompress
+	plain, err := unxz(compressed)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, plain, 0o600)
+}
+
+// readPassword reads the password from environment variable PASSWORD or from stdin
+func readPassword() (string, error) {
+	if password := os.Getenv("PASSWORD"); password != "" {
+		return password, nil
+	}
+	fmt.Fprint(os.Stderr, "Password (visible while typing): ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// TEXT FALLBACK
+
+var (
+	textHeading  = regexp.MustCompile(`^QR\s*CODE\s*\d+(?:.*\(\s*(\d+)\s*LINES?\s*\))?`)
+	textLine     = regexp.MustCompile(`^(\d+)\s*[:;.,]\s*([A-Z0-9 ]+?)\s*-\s*([A-Z0-9 ]+)$`)
+	textEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// parseText returns the payloads in the text printed by encode --text.
+// Returns nil if the content doesn't contain any text lines.
+func parseText(content []byte) ([][]byte, error) {
+	var payloads [][]byte
+	var lines map[int][]byte
+	lineCount := 0
+	flush := func() error {
+		if len(lines) == 0 {
+			return nil
+		}
+		if lineCount == 0 {
+			for number := range lines {
+				lineCount = max(lineCount, number)
+			}
+		}
+		var payload []byte
+		for number := 1; number <= lineCount; number++ {
+			data, ok := lines[number]
+			if !ok {
+				return fmt.Errorf("line %03d of text block %d is missing", number, len(payloads)+1)
+			}
+			payload = append(payload, data...)
+		}
+		payloads = append(payloads, payload)
+		lines, lineCount = nil, 0
+		return nil
+	}
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.ToUpper(strings.TrimSpace(line))
+		if match := textHeading.FindStringSubmatch(line); match != nil {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			lineCount, _ = strconv.Atoi(match[1])
+			continue
+		}
+		match := textLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		number, _ := strconv.Atoi(match[1])
+		if number == 1 && len(lines) > 0 {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		data, err := textEncoding.DecodeString(normalizeText(match[2]))
+		if err != nil || lineChecksum(number, data) != normalizeText(match[3]) {
+			return nil, fmt.Errorf("text line %d is invalid, please check for typos", i+1)
+		}
+		if lines == nil {
+			lines = make(map[int][]byte)
+		}
+		lines[number] = data
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return payloads, nil
+}
+
+// normalizeText removes spaces and replaces digits which are not used by Base32
+func normalizeText(text string) string {
+	return strings.NewReplacer(" ", "", "0", "O", "1", "I", "8", "B").Replace(text)
+}
+
+// lineChecksum returns the first 4 characters of the Base32 encoded CRC-32
+// over the line number (4 bytes, big endian) and the data of the line
+func lineChecksum(number int, data []byte) string {
+	input := binary.BigEndian.AppendUint32(nil, uint32(number))
+	checksum := crc32.ChecksumIEEE(append(input, data...))
+	return textEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, checksum))[:4]
+}
+
+// printChecksums prints each line of the file with its checksum, like the printed source
+func printChecksums(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	for i, line := range lines {
+		fmt.Println(listingLine(i+1, line))
+	}
+	return nil
+}
+
+// listingLine formats a line of source as printed. Checksum ignores indentation.
+func listingLine(number int, line string) string {
+	checksum := lineChecksum(number, []byte(strings.TrimSpace(line)))
+	return fmt.Sprintf("%04d %s | %s", number, checksum, strings.ReplaceAll(line, "\t", "  "))
+}
+
+// COMBINE
+
+// combine decodes the payloads and returns the header and the combined data
+func combine(payloads [][]byte) (map[string]any, []byte, error) {
+	pages := make(map[uint64][]byte)
+	var header map[string]any
+	var documentID []byte
+	for i, payload := range payloads {
+		item, _, err := decodeCBOR(payload)
+		if err != nil {
+			return nil, nil, fmt.Errorf("QR code %d: %w", i+1, err)
+		}
+		qrData, _ := item.(map[string]any)
+		number := uintField(qrData, "page_number", 0)
+		data, _ := qrData["data"].([]byte)
+		checksum, ok := qrData["crc32"].(uint64)
+		if ok && uint64(crc32.ChecksumIEEE(data)) != checksum {
+			return nil, nil, fmt.Errorf("QR code %d is corrupted", number)
+		}
+		if id, ok := qrData["document_id"].([]byte); ok {
+			if documentID != nil && !bytes.Equal(id, documentID) {
+				return nil, nil, errors.New("QR codes belong to different documents")
+			}
+			documentID = id
+		}
+		if h, ok := qrData["header"].(map[string]any); ok && header == nil {
+			header = h
+		}
+		pages[number] = data
+	}
+	if header == nil {
+		return nil, nil, errors.New("header is missing, QR code 1 is required")
+	}
+
+	// Concatenate data QR codes. Parity QR codes are at the end.
+	pageCount := uintField(header, "page_count", 0) - uintField(header, "parity_pages", 0)
+	var data []byte
+	for number := uint64(1); number <= pageCount; number++ {
+		page, ok := pages[number]
+		if !ok {
+			return nil, nil, fmt.Errorf("QR code %d is missing", number)
+		}
+		data = append(data, page...)
+	}
+	if size := uintField(header, "data_size", 0); size > 0 && size < uint64(len(data)) {
+		data = data[:size]
+	}
+	if hash, ok := header["data_hash"].([]byte); ok {
+		if actual := sha256.Sum256(data); !bytes.Equal(actual[:], hash) {
+			return nil, nil, errors.New("combined data is corrupted")
+		}
+	}
+	return header, data, nil
+}
+
+// uintField returns the unsigned integer field of the map or the fallback if absent
+func uintField(m map[string]any, key string, fallback uint64) uint64 {
+	if value, ok := m[key].(uint64); ok {
+		return value
+	}
+	return fallback
+}
+
+// associatedData returns the canonical CBOR encoding of the header, which is
+// authenticated during encryption. Legacy sheets (version 0) have no associated data.
+func associatedData(header map[string]any) []byte {
+	if uintField(header, "version", 0) < 1 {
+		return nil
+	}
+	ad := make(map[string]any, len(header))
+	for key, value := range header {
+		ad[key] = value
+	}
+	delete(ad, "share")
+	delete(ad, "data_hash")
+	if uintField(header, "aead", 1) == 3 {
+		ad["page_count"] = uint64(0)
+		delete(ad, "data_size")
+	}
+	return encodeCBOR(nil, ad)
+}
+
+// CBOR
+
+// decodeCBOR decodes a single CBOR item (RFC 8949) into uint64, []byte, string,
+// []any, map[string]any, bool or nil. Returns the remaining data.
+// Only the subset used by encrypted-paper is supported.
+func decodeCBOR(data []byte) (any, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, errors.New("unexpected end of CBOR data")
+	}
+	major, info := data[0]>>5, data[0]&0x1F
+	data = data[1:]
+	if major == 7 {
+		switch info {
+		case 20, 21:
+			return info == 21, data, nil
+		case 22:
+			return nil, data, nil
+		}
+		return nil, nil, fmt.Errorf("unsupported CBOR simple value %d", info)
+	}
+
+	// Read argument, which is the value or the length
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(data) < size {
+			return nil, nil, errors.New("unexpected end of CBOR data")
+		}
+		for _, b := range data[:size] {
+			arg = arg<<8 | uint64(b)
+		}
+		data = data[size:]
+	default:
+		return nil, nil, errors.New("indefinite length CBOR is not supported")
+	}
+
+	switch major {
+	case 0:
+		return arg, data, nil
+	case 2, 3:
+		if uint64(len(data)) < arg {
+			return nil, nil, errors.New("unexpected end of CBOR data")
+		}
+		if major == 3 {
+			return string(data[:arg]), data[arg:], nil
+		}
+		return append([]byte{}, data[:arg]...), data[arg:], nil
+	case 4:
+		items := make([]any, 0, min(arg, 1024))
+		for i := uint64(0); i < arg; i++ {
+			var item any
+			var err error
+			if item, data, err = decodeCBOR(data); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case 5:
+		items := make(map[string]any)
+		for i := uint64(0); i < arg; i++ {
+			var key, value any
+			var err error
+			if key, data, err = decodeCBOR(data); err != nil {
+				return nil, nil, err
+			}
+			if value, data, err = decodeCBOR(data); err != nil {
+				return nil, nil, err
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, nil, errors.New("CBOR map key is not a string")
+			}
+			items[keyString] = value
+		}
+		return items, data, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported CBOR major type %d", major)
+}
+
+// encodeCBOR appends the item as canonical CBOR (RFC 7049, section 3.9):
+// shortest arguments and map keys sorted by length, then bytewise.
+func encodeCBOR(out []byte, item any) []byte {
+	head := func(major byte, arg uint64) {
+		switch {
+		case arg < 24:
+			out = append(out, major<<5|byte(arg))
+		case arg <= 0xFF:
+			out = append(out, major<<5|24, byte(arg))
+		case arg <= 0xFFFF:
+			out = binary.BigEndian.AppendUint16(append(out, major<<5|25), uint16(arg))
+		case arg <= 0xFFFFFFFF:
+			out = binary.BigEndian.AppendUint32(append(out, major<<5|26), uint32(arg))
+		default:
+			out = binary.BigEndian.AppendUint64(append(out, major<<5|27), arg)
+		}
+	}
+	switch value := item.(type) {
+	case uint64:
+		head(0, value)
+	case []byte:
+		head(2, uint64(len(value)))
+		out = append(out, value...)
+	case string:
+		head(3, uint64(len(value)))
+		out = append(out, value...)
+	case []any:
+		head(4, uint64(len(value)))
+		for _, element := range value {
+			out = encodeCBOR(out, element)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		head(5, uint64(len(value)))
+		for _, key := range keys {
+			out = encodeCBOR(encodeCBOR(out, key), value[key])
+		}
+	case bool:
+		out = append(out, map[bool]byte{false: 0xF4, true: 0xF5}[value])
+	default:
+		out = append(out, 0xF6) // null
+	}
+	return out
+}
+
+// BLAKE2B (RFC 7693)
+
+var blake2bIV = [8]uint64{
+	0x6A09E667F3BCC908, 0xBB67AE8584CAA73B, 0x3C6EF372FE94F82B, 0xA54FF53A5F1D36F1,
+	0x510E527FADE682D1, 0x9B05688C2B3E6C1F, 0x1F83D9ABFB41BD6B, 0x5BE0CD19137E2179,
+}
+
+var blake2bSigma = [10][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+}
+
+// blake2b returns the unkeyed BLAKE2b hash of size bytes (1 to 64)
+func blake2b(size int, data []byte) []byte {
+	h := blake2bIV
+	h[0] ^= 0x01010000 ^ uint64(size)
+	counter := uint64(0)
+	for len(data) > 128 {
+		counter += 128
+		blake2bCompress(&h, data[:128], counter, false)
+		data = data[128:]
+	}
+	var last [128]byte
+	copy(last[:], data)
+	blake2bCompress(&h, last[:], counter+uint64(len(data)), true)
+	out := make([]byte, 64)
+	for i, word := range h {
+		binary.LittleEndian.PutUint64(out[i*8:], word)
+	}
+	return out[:size]
+}
+
+func blake2bCompress(h *[8]uint64, block []byte, counter uint64, last bool) {
+	var m, v [16]uint64
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(block[i*8:])
+	}
+	copy(v[:8], h[:])
+	copy(v[8:], blake2bIV[:])
+	v[12] ^= counter
+	if last {
+		v[14] = ^v[14]
+	}
+	g := func(a, b, c, d int, x, y uint64) {
+		v[a] += v[b] + x
+		v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+		v[a] += v[b] + y
+		v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+	}
+	for round := 0; round < 12; round++ {
+		s := &blake2bSigma[round%10]
+		g(0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+	for i := range h {
+		h[i] ^= v[i] ^ v[i+8]
+	}
+}
+
+// ARGON2ID (RFC 9106), version 0x13
+
+type argonBlock [128]uint64
+
+// argon2id derives a key of keyLen bytes. Memory is in KiB.
+func argon2id(password, salt []byte, time, memory, threads, keyLen uint32) []byte {
+	// Initial hash H0
+	var input []byte
+	for _, value := range []uint32{threads, keyLen, memory, time, 0x13, 2} {
+		input = binary.LittleEndian.AppendUint32(input, value)
+	}
+	for _, value := range [][]byte{password, salt, nil, nil} {
+		input = binary.LittleEndian.AppendUint32(input, uint32(len(value)))
+		input = append(input, value...)
+	}
+	h0 := blake2b(64, input)
+
+	// Memory is a multiple of 4 blocks per lane, with at least 8 blocks per lane
+	memory = max(memory/(4*threads)*(4*threads), 8*threads)
+	laneLength, segmentLength := memory/threads, memory/threads/4
+	blocks := make([]argonBlock, memory)
+	for lane := uint32(0); lane < threads; lane++ {
+		for i := uint32(0); i < 2; i++ {
+			seed := binary.LittleEndian.AppendUint32(append(h0[:64:64], 0, 0, 0, 0), lane)
+			binary.LittleEndian.PutUint32(seed[64:], i)
+			hash := hPrime(1024, seed)
+			for j := range blocks[lane*laneLength+i] {
+				blocks[lane*laneLength+i][j] = binary.LittleEndian.Uint64(hash[j*8:])
+			}
+		}
+	}
+
+	// Fill memory, slice by slice
+	for pass := uint32(0); pass < time; pass++ {
+		for slice := uint32(0); slice < 4; slice++ {
+			for lane := uint32(0); lane < threads; lane++ {
+				// First half of the first pass uses data independent addresses
+				independent := pass == 0 && slice < 2
+				var addresses, in, zero argonBlock
+				in[0], in[1], in[2] = uint64(pass), uint64(lane), uint64(slice)
+				in[3], in[4], in[5] = uint64(memory), uint64(time), 2
+				index := uint32(0)
+				if pass == 0 && slice == 0 {
+					index = 2 // First two blocks are already filled
+					if independent {
+						in[6]++
+						argonCompress(&addresses, &in, &zero, false)
+						argonCompress(&addresses, &addresses, &zero, false)
+					}
+				}
+				offset := lane*laneLength + slice*segmentLength + index
+				for ; index < segmentLength; index, offset = index+1, offset+1 {
+					prev := offset - 1
+					if index == 0 && slice == 0 {
+						prev += laneLength // Last block of the lane
+					}
+					var random uint64
+					if independent {
+						if index%128 == 0 {
+							in[6]++
+							argonCompress(&addresses, &in, &zero, false)
+							argonCompress(&addresses, &addresses, &zero, false)
+						}
+						random = addresses[index%128]
+					} else {
+						random = blocks[prev][0]
+					}
+					ref := argonIndex(random, laneLength, segmentLength, threads,
+						pass, slice, lane, index)
+					argonCompress(&blocks[offset], &blocks[prev], &blocks[ref], true)
+				}
+			}
+		}
+	}
+
+	// XOR last blocks of all lanes and hash into the key
+	final := blocks[memory-1]
+	for lane := uint32(0); lane < threads-1; lane++ {
+		for i, word := range blocks[lane*laneLength+laneLength-1] {
+			final[i] ^= word
+		}
+	}
+	var finalBytes []byte
+	for _, word := range final {
+		finalBytes = binary.LittleEndian.AppendUint64(finalBytes, word)
+	}
+	return hPrime(int(keyLen), finalBytes)
+}
+
+// hPrime is the variable length hash function H' of Argon2
+func hPrime(size int, input []byte) []byte {
+	input = append(binary.LittleEndian.AppendUint32(nil, uint32(size)), input...)
+	if size <= 64 {
+		return blake2b(size, input)
+	}
+	v := blake2b(64, input)
+	out := append([]byte{}, v[:32]...)
+	for size-len(out) > 64 {
+		v = blake2b(64, v)
+		out = append(out, v[:32]...)
+	}
+	return append(out, blake2b(size-len(out), v)...)
+}
+
+// argonIndex returns the index of the reference block
+func argonIndex(random uint64, laneLength, segmentLength, threads,
+	pass, slice, lane, index uint32) uint32 {
+	refLane := uint32(random>>32) % threads
+	if pass == 0 && slice == 0 {
+		refLane = lane
+	}
+	area, start := 3*segmentLength, ((slice+1)%4)*segmentLength
+	if lane == refLane {
+		area += index
+	}
+	if pass == 0 {
+		area, start = slice*segmentLength, 0
+		if slice == 0 || lane == refLane {
+			area += index
+		}
+	}
+	if index == 0 || lane == refLane {
+		area--
+	}
+	p := random & 0xFFFFFFFF
+	p = (p * p) >> 32
+	p = (p * uint64(area)) >> 32
+	return refLane*laneLength + uint32((uint64(start)+uint64(area)-(p+1))%uint64(laneLength))
+}
+
+// argonCompress is the compression function G. Result is XOR'ed into out if xor is set.
+func argonCompress(out, x, y *argonBlock, xor bool) {
+	var r, z argonBlock
+	for i := range r {
+		r[i] = x[i] ^ y[i]
+	}
+	z = r
+	for i := 0; i < 8; i++ { // Rows
+		blamka(&z, 16*i, 1, 16*i+8)
+	}
+	for i := 0; i < 8; i++ { // Columns
+		blamka(&z, 2*i, 16, 2*i+1)
+	}
+	for i := range z {
+		if xor {
+			out[i] ^= r[i] ^ z[i]
+		} else {
+			out[i] = r[i] ^ z[i]
+		}
+	}
+}
+
+// blamka applies the permutation P on 16 words of the block. Words are pairs of
+// 8 words at first, first+step, ... and second, second+step, ... interleaved.
+func blamka(b *argonBlock, first, step, second int) {
+	var idx [16]int
+	for i := 0; i < 8; i++ {
+		if step == 1 {
+			idx[2*i], idx[2*i+1] = first+2*i, first+2*i+1
+		} else {
+			idx[2*i], idx[2*i+1] = first+i*step, second+i*step
+		}
+	}
+	g := func(a, b2, c, d int) {
+		va, vb, vc, vd := &b[idx[a]], &b[idx[b2]], &b[idx[c]], &b[idx[d]]
+		mul := func(x, y uint64) uint64 { return 2 * uint64(uint32(x)) * uint64(uint32(y)) }
+		*va += *vb + mul(*va, *vb)
+		*vd = bits.RotateLeft64(*vd^*va, -32)
+		*vc += *vd + mul(*vc, *vd)
+		*vb = bits.RotateLeft64(*vb^*vc, -24)
+		*va += *vb + mul(*va, *vb)
+		*vd = bits.RotateLeft64(*vd^*va, -16)
+		*vc += *vd + mul(*vc, *vd)
+		*vb = bits.RotateLeft64(*vb^*vc, -63)
+	}
+	g(0, 4, 8, 12)
+	g(1, 5, 9, 13)
+	g(2, 6, 10, 14)
+	g(3, 7, 11, 15)
+	g(0, 5, 10, 15)
+	g(1, 6, 11, 12)
+	g(2, 7, 8, 13)
+	g(3, 4, 9, 14)
+}
+
+// XCHACHA20-POLY1305 (RFC 8439 and draft-irtf-cfrg-xchacha)
+
+// decryptStream decrypts the STREAM construction: a nonce prefix of 16 bytes,
+// followed by chunks of 64 KiB of plaintext, each followed by a tag of 16 bytes.
+// Nonce is the prefix, the chunk number (7 bytes) and 1 for the last chunk.
+func decryptStream(key, data, ad []byte) ([]byte, error) {
+	const sealedChunkSize = 64*1024 + 16
+	if len(data) < 16 {
+		return nil, errors.New("encrypted data is too short")
+	}
+	prefix, data := data[:16], data[16:]
+	var plain []byte
+	for counter := uint64(0); ; counter++ {
+		size := min(sealedChunkSize, len(data))
+		last := size == len(data)
+		nonce := binary.BigEndian.AppendUint64(append([]byte{}, prefix...), counter<<8)
+		if last {
+			nonce[23] = 1
+		}
+		chunk, err := openXChaCha20Poly1305(key, nonce, data[:size], ad)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", counter, err)
+		}
+		plain = append(plain, chunk...)
+		data = data[size:]
+		if last {
+			return plain, nil
+		}
+	}
+}
+
+// openXChaCha20Poly1305 authenticates and decrypts the ciphertext with its tag
+func openXChaCha20Poly1305(key, nonce, sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < 16 {
+		return nil, errors.New("encrypted data is too short")
+	}
+	ciphertext, tag := sealed[:len(sealed)-16], sealed[len(sealed)-16:]
+	polyKey := xchacha20(key, nonce, 0, make([]byte, 32))
+	pad := func(b []byte) []byte { return append(b, make([]byte, (16-len(b)%16)%16)...) }
+	mac := append(pad(append([]byte{}, ad...)), pad(append([]byte{}, ciphertext...))...)
+	mac = binary.LittleEndian.AppendUint64(mac, uint64(len(ad)))
+	mac = binary.LittleEndian.AppendUint64(mac, uint64(len(ciphertext)))
+	if subtle.ConstantTimeCompare(poly1305(polyKey, mac), tag) != 1 {
+		return nil, errors.New("authentication failed: wrong password or corrupted data")
+	}
+	return xchacha20(key, nonce, 1, ciphertext), nil
+}
+
+// xchacha20 XORs the data with the key stream, starting at the block counter
+func xchacha20(key, nonce []byte, counter uint32, data []byte) []byte {
+	// HChaCha20 derives a subkey from the key and the first 16 bytes of the nonce
+	s := chachaState(key, nonce[0:16])
+	chachaRounds(&s)
+	var subkey []byte
+	for _, word := range append(s[0:4:4], s[12:16]...) {
+		subkey = binary.LittleEndian.AppendUint32(subkey, word)
+	}
+
+	// ChaCha20 with the last 8 bytes of the nonce
+	out := make([]byte, len(data))
+	var stream []byte
+	for i := range data {
+		if i%64 == 0 {
+			words := binary.LittleEndian.AppendUint32(nil, counter)
+			s := chachaState(subkey, append(append(words, 0, 0, 0, 0), nonce[16:24]...))
+			block := s
+			chachaRounds(&block)
+			stream = stream[:0]
+			for j := range block {
+				stream = binary.LittleEndian.AppendUint32(stream, block[j]+s[j])
+			}
+			counter++
+		}
+		out[i] = data[i] ^ stream[i%64]
+	}
+	return out
+}
+
+// chachaState returns the initial state for the key and the last 4 words
+func chachaState(key, last []byte) [16]uint32 {
+	s := [16]uint32{0x61707865, 0x3320646E, 0x79622D32, 0x6B206574}
+	for i := 0; i < 8; i++ {
+		s[4+i] = binary.LittleEndian.Uint32(key[4*i:])
+	}
+	for i := 0; i < 4; i++ {
+		s[12+i] = binary.LittleEndian.Uint32(last[4*i:])
+	}
+	return s
+}
+
+// chachaRounds applies the 20 rounds of ChaCha20
+func chachaRounds(s *[16]uint32) {
+	qr := func(a, b, c, d int) {
+		s[a] += s[b]
+		s[d] = bits.RotateLeft32(s[d]^s[a], 16)
+		s[c] += s[d]
+		s[b] = bits.RotateLeft32(s[b]^s[c], 12)
+		s[a] += s[b]
+		s[d] = bits.RotateLeft32(s[d]^s[a], 8)
+		s[c] += s[d]
+		s[b] = bits.RotateLeft32(s[b]^s[c], 7)
+	}
+	for i := 0; i < 10; i++ {
+		qr(0, 4, 8, 12)
+		qr(1, 5, 9, 13)
+		qr(2, 6, 10, 14)
+		qr(3, 7, 11, 15)
+		qr(0, 5, 10, 15)
+		qr(1, 6, 11, 12)
+		qr(2, 7, 8, 13)
+		qr(3, 4, 9, 14)
+	}
+}
+
+// poly1305 returns the tag of the message. Slow, but simple using big integers.
+func poly1305(key, msg []byte) []byte {
+	littleEndian := func(b []byte) *big.Int {
+		reversed := make([]byte, len(b))
+		for i := range b {
+			reversed[len(b)-1-i] = b[i]
+		}
+		return new(big.Int).SetBytes(reversed)
+	}
+	clamped := append([]byte{}, key[:16]...)
+	for _, i := range []int{3, 7, 11, 15} {
+		clamped[i] &= 15
+	}
+	for _, i := range []int{4, 8, 12} {
+		clamped[i] &= 252
+	}
+	r, s := littleEndian(clamped), littleEndian(key[16:32])
+	p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 130), big.NewInt(5))
+	acc := new(big.Int)
+	for len(msg) > 0 {
+		size := min(16, len(msg))
+		acc.Add(acc, littleEndian(append(append([]byte{}, msg[:size]...), 1)))
+		acc.Mul(acc, r).Mod(acc, p)
+		msg = msg[size:]
+	}
+	acc.Add(acc, s)
+	tag := make([]byte, 16)
+	for i, b := range acc.Bytes() { // Big endian, only lowest 16 bytes are used
+		if j := len(acc.Bytes()) - 1 - i; j < 16 {
+			tag[j] = b
+		}
+	}
+	return tag
+}
+
+// XZ (https://tukaani.org/xz/xz-file-format.txt)
+
+// unxz decompresses an xz stream with LZMA2 blocks
+func unxz(data []byte) ([]byte, error) {
+	// Stream header: magic, flags and CRC-32
+	magic := []byte{0xFD, '7', 'z', 'X', 'Z', 0}
+	if len(data) < 12 || !bytes.Equal(data[:6], magic) {
+		return nil, errors.New("data is not xz compressed")
+	}
+	checkSize := map[byte]int{0: 0, 1: 4, 4: 8, 10: 32}[data[7]&0x0F]
+	pos := 12
+	var out []byte
+	for {
+		// Block header or index
+		if pos >= len(data) {
+			return nil, errors.New("unexpected end of xz data")
+		}
+		if data[pos] == 0 {
+			return out, nil // Index follows, which is not verified
+		}
+		headerSize := (int(data[pos]) + 1) * 4
+		if pos+headerSize > len(data) {
+			return nil, errors.New("unexpected end of xz data")
+		}
+		header := data[pos : pos+headerSize]
+		headerCRC := binary.LittleEndian.Uint32(header[headerSize-4:])
+		if crc32.ChecksumIEEE(header[:headerSize-4]) != headerCRC {
+			return nil, errors.New("xz block header is corrupted")
+		}
+		if header[1]&0x03 != 0 {
+			return nil, errors.New("only xz blocks with a single LZMA2 filter are supported")
+		}
+		filter := 2 // After optional compressed and uncompressed size
+		for _, flag := range []byte{0x40, 0x80} {
+			for header[1]&flag != 0 && header[filter]&0x80 != 0 {
+				filter++
+			}
+			if header[1]&flag != 0 {
+				filter++
+			}
+		}
+		if header[filter] != 0x21 {
+			return nil, errors.New("only xz blocks with a single LZMA2 filter are supported")
+		}
+
+		// LZMA2 data, padding and check
+		start := len(out)
+		var size int
+		var err error
+		out, size, err = unlzma2(data[pos+headerSize:], out)
+		if err != nil {
+			return nil, err
+		}
+		pos += (headerSize + size + 3) / 4 * 4
+		if pos+checkSize > len(data) {
+			return nil, errors.New("unexpected end of xz data")
+		}
+		if !validCheck(data[7]&0x0F, out[start:], data[pos:pos+checkSize]) {
+			return nil, errors.New("xz check failed: data is corrupted")
+		}
+		pos += checkSize
+	}
+}
+
+// validCheck verifies the check of an xz block
+func validCheck(checkType byte, data, check []byte) bool {
+	switch checkType {
+	case 1:
+		return crc32.ChecksumIEEE(data) == binary.LittleEndian.Uint32(check)
+	case 4:
+		table := crc64.MakeTable(crc64.ECMA)
+		return crc64.Checksum(data, table) == binary.LittleEndian.Uint64(check)
+	case 10:
+		hash := sha256.Sum256(data)
+		return bytes.Equal(hash[:], check)
+	}
+	return true
+}
+
+// unlzma2 decompresses LZMA2 chunks and appends them to out.
+// Returns the output and the size of the LZMA2 data.
+func unlzma2(data, out []byte) ([]byte, int, error) {
+	var decoder *lzmaDecoder
+	dictStart := len(out)
+	pos := 0
+	errEOF := errors.New("unexpected end of LZMA2 data")
+	for {
+		if pos+3 > len(data) {
+			return nil, 0, errEOF
+		}
+		control := data[pos]
+		size := int(binary.BigEndian.Uint16(data[pos+1:])) + 1
+		switch {
+		case control == 0: // End of data
+			return out, pos + 1, nil
+		case control == 1 || control == 2: // Uncompressed chunk, 1 resets dictionary
+			if control == 1 {
+				dictStart = len(out)
+			}
+			if pos+3+size > len(data) {
+				return nil, 0, errEOF
+			}
+			out = append(out, data[pos+3:pos+3+size]...)
+			pos += 3 + size
+		case control >= 0x80: // LZMA chunk
+			if pos+5 > len(data) {
+				return nil, 0, errEOF
+			}
+			unpacked := int(control&0x1F)<<16 + size
+			packed := int(binary.BigEndian.Uint16(data[pos+3:])) + 1
+			pos += 5
+			reset := (control >> 5) & 3 // 1: state, 2: state and properties, 3: all
+			if reset == 3 {
+				dictStart = len(out)
+			}
+			if reset >= 2 {
+				if pos >= len(data) || data[pos] >= 9*5*5 {
+					return nil, 0, errors.New("invalid LZMA properties")
+				}
+				decoder = &lzmaDecoder{lc: uint(data[pos] % 9), lp: uint(data[pos] / 9 % 5)}
+				decoder.pb = uint(data[pos] / 45)
+				pos++
+			}
+			if decoder == nil {
+				return nil, 0, errors.New("LZMA2 data doesn't start with properties")
+			}
+			if reset >= 1 {
+				decoder.reset()
+			}
+			if pos+packed > len(data) {
+				return nil, 0, errEOF
+			}
+			rc, err := newRangeDecoder(data[pos : pos+packed])
+			if err != nil {
+				return nil, 0, err
+			}
+			out, err = decoder.decode(rc, out, dictStart, len(out)+unpacked)
+			if err != nil {
+				return nil, 0, err
+			}
+			pos += packed
+		default:
+			return nil, 0, errors.New("invalid LZMA2 chunk")
+		}
+	}
+}
+
+// rangeDecoder decodes bits of LZMA data
+type rangeDecoder struct {
+	data        []byte
+	rng, code   uint32
+	pastTheData bool
+}
+
+func newRangeDecoder(data []byte) (*rangeDecoder, error) {
+	if len(data) < 5 || data[0] != 0 {
+		return nil, errors.New("invalid LZMA data")
+	}
+	rc := &rangeDecoder{data: data[5:], rng: 0xFFFFFFFF}
+	rc.code = binary.BigEndian.Uint32(data[1:])
+	return rc, nil
+}
+
+func (rc *rangeDecoder) normalize() {
+	if rc.rng < 1<<24 {
+		var next byte
+		if len(rc.data) > 0 {
+			next, rc.data = rc.data[0], rc.data[1:]
+		} else {
+			rc.pastTheData = true
+		}
+		rc.rng <<= 8
+		rc.code = rc.code<<8 | uint32(next)
+	}
+}
+
+// bit decodes a bit with the probability, which is adapted afterwards
+func (rc *rangeDecoder) bit(prob *uint16) uint32 {
+	bound := (rc.rng >> 11) * uint32(*prob)
+	var bit uint32
+	if rc.code < bound {
+		rc.rng = bound
+		*prob += (2048 - *prob) >> 5
+	} else {
+		rc.rng -= bound
+		rc.code -= bound
+		*prob -= *prob >> 5
+		bit = 1
+	}
+	rc.normalize()
+	return bit
+}
+
+// direct decodes bits with a fixed probability of 50%
+func (rc *rangeDecoder) direct(count uint) uint32 {
+	var result uint32
+	for ; count > 0; count-- {
+		rc.rng >>= 1
+		rc.code -= rc.rng
+		t := 0 - (rc.code >> 31)
+		rc.code += rc.rng & t
+		rc.normalize()
+		result = result<<1 + t + 1
+	}
+	return result
+}
+
+// tree decodes a number of bits, most significant bit first
+func (rc *rangeDecoder) tree(probs []uint16, count uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < count; i++ {
+		m = m<<1 | rc.bit(&probs[m])
+	}
+	return m - 1<<count
+}
+
+// reverseTree decodes a number of bits, least significant bit first
+func (rc *rangeDecoder) reverseTree(probs []uint16, count uint) uint32 {
+	m, result := uint32(1), uint32(0)
+	for i := uint(0); i < count; i++ {
+		bit := rc.bit(&probs[m])
+		m = m<<1 | bit
+		result |= bit << i
+	}
+	return result
+}
+
+// lzmaDecoder contains the state of the LZMA decoder, which is kept between LZMA2 chunks
+type lzmaDecoder struct {
+	lc, lp, pb uint
+	state      uint32
+	rep        [4]uint32
+
+	// Probabilities
+	literal                          []uint16
+	isMatch, isRep0Long              [12 << 4]uint16
+	isRep, isRepG0, isRepG1, isRepG2 [12]uint16
+	posSlot                          [4][64]uint16
+	posSpecial                       [115]uint16
+	align                            [16]uint16
+	matchLen, repLen                 lengthDecoder
+}
+
+type lengthDecoder struct {
+	choice, choice2 uint16
+	low, mid        [16][8]uint16
+	high            [256]uint16
+}
+
+// reset resets the state and all probabilities to 50%
+func (d *lzmaDecoder) reset() {
+	d.state, d.rep = 0, [4]uint32{}
+	d.literal = make([]uint16, 0x300<<(d.lc+d.lp))
+	probs := [][]uint16{d.literal, d.isMatch[:], d.isRep0Long[:], d.isRep[:],
+		d.isRepG0[:], d.isRepG1[:], d.isRepG2[:], d.posSpecial[:], d.align[:],
+		d.matchLen.high[:], d.repLen.high[:]}
+	for i := 0; i < 16; i++ {
+		probs = append(probs, d.matchLen.low[i][:], d.matchLen.mid[i][:])
+		probs = append(probs, d.repLen.low[i][:], d.repLen.mid[i][:])
+	}
+	for i := range d.posSlot {
+		probs = append(probs, d.posSlot[i][:])
+	}
+	for _, p := range probs {
+		for i := range p {
+			p[i] = 1024
+		}
+	}
+	for _, l := range []*lengthDecoder{&d.matchLen, &d.repLen} {
+		l.choice, l.choice2 = 1024, 1024
+	}
+}
+
+// decode decodes a LZMA chunk until the output has the target length
+func (d *lzmaDecoder) decode(rc *rangeDecoder, out []byte,
+	dictStart, target int) ([]byte, error) {
+	pbMask, lpMask := uint32(1)<<d.pb-1, uint32(1)<<d.lp-1
+	for len(out) < target {
+		pos := uint32(len(out) - dictStart)
+		posState := pos & pbMask
+		if rc.bit(&d.isMatch[d.state<<4+posState]) == 0 {
+			// Literal, coded with the previous byte and the byte at the last distance
+			prev := uint32(0)
+			if pos > 0 {
+				prev = uint32(out[len(out)-1])
+			}
+			offset := 0x300 * ((pos&lpMask)<<d.lc + prev>>(8-d.lc))
+			probs := d.literal[offset : offset+0x300]
+			symbol := uint32(1)
+			if d.state >= 7 && int(d.rep[0]) < int(pos) {
+				matchByte := uint32(out[len(out)-int(d.rep[0])-1]) << 1
+				mask := uint32(0x100)
+				for symbol < 0x100 {
+					matchBit := matchByte & mask
+					matchByte <<= 1
+					bit := rc.bit(&probs[mask+matchBit+symbol])
+					symbol = symbol<<1 | bit
+					if bit == 1 {
+						mask = matchBit
+					} else {
+						mask &= ^matchBit
+					}
+				}
+			} else {
+				for symbol < 0x100 {
+					symbol = symbol<<1 | rc.bit(&probs[symbol])
+				}
+			}
+			out = append(out, byte(symbol))
+			d.state = [12]uint32{0, 0, 0, 0, 1, 2, 3, 4, 5, 6, 4, 5}[d.state]
+			continue
+		}
+
+		var length uint32
+		if rc.bit(&d.isRep[d.state]) == 0 {
+			// Match with a new distance
+			length = d.matchLen.decode(rc, posState)
+			d.state = map[bool]uint32{true: 7, false: 10}[d.state < 7]
+			d.rep[3], d.rep[2], d.rep[1] = d.rep[2], d.rep[1], d.rep[0]
+			d.rep[0] = d.decodeDistance(rc, length)
+		} else {
+			// Match with one of the last 4 distances
+			if rc.bit(&d.isRepG0[d.state]) == 0 {
+				if rc.bit(&d.isRep0Long[d.state<<4+posState]) == 0 {
+					// Single byte at the last distance
+					if int(d.rep[0]) >= int(pos) {
+						return nil, errors.New("LZMA data is corrupted")
+					}
+					d.state = map[bool]uint32{true: 9, false: 11}[d.state < 7]
+					out = append(out, out[len(out)-int(d.rep[0])-1])
+					continue
+				}
+			} else {
+				var distance uint32
+				if rc.bit(&d.isRepG1[d.state]) == 0 {
+					distance = d.rep[1]
+				} else {
+					if rc.bit(&d.isRepG2[d.state]) == 0 {
+						distance = d.rep[2]
+					} else {
+						distance, d.rep[3] = d.rep[3], d.rep[2]
+					}
+					d.rep[2] = d.rep[1]
+				}
+				d.rep[1], d.rep[0] = d.rep[0], distance
+			}
+			length = d.repLen.decode(rc, posState)
+			d.state = map[bool]uint32{true: 8, false: 11}[d.state < 7]
+		}
+
+		// Copy match
+		if int(d.rep[0]) >= int(pos) || len(out)+int(length)+2 > target {
+			return nil, errors.New("LZMA data is corrupted")
+		}
+		for i := uint32(0); i < length+2; i++ {
+			out = append(out, out[len(out)-int(d.rep[0])-1])
+		}
+	}
+	if rc.pastTheData {
+		return nil, errors.New("unexpected end of LZMA data")
+	}
+	return out, nil
+}
+
+// decode returns the match length minus the minimum length of 2
+func (l *lengthDecoder) decode(rc *rangeDecoder, posState uint32) uint32 {
+	if rc.bit(&l.choice) == 0 {
+		return rc.tree(l.low[posState][:], 3)
+	}
+	if rc.bit(&l.choice2) == 0 {
+		return 8 + rc.tree(l.mid[posState][:], 3)
+	}
+	return 16 + rc.tree(l.high[:], 8)
+}
+
+// decodeDistance returns the distance minus 1 of a match with the length minus 2
+func (d *lzmaDecoder) decodeDistance(rc *rangeDecoder, length uint32) uint32 {
+	slot := rc.tree(d.posSlot[min(length, 3)][:], 6)
+	if slot < 4 {
+		return slot
+	}
+	directBits := uint(slot>>1) - 1
+	distance := (2 | slot&1) << directBits
+	if slot < 14 {
+		return distance + rc.reverseTree(d.posSpecial[distance-slot:], directBits)
+	}
+	distance += rc.direct(directBits-4) << 4
+	return distance + rc.reverseTree(d.align[:], 4)
+}
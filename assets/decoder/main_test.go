@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+	blake2bRef "golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/JenswBE/encrypted-paper/assets"
+	"github.com/JenswBE/encrypted-paper/compress"
+	"github.com/JenswBE/encrypted-paper/encode"
+	"github.com/JenswBE/encrypted-paper/encrypt"
+	"github.com/JenswBE/encrypted-paper/qrcode"
+)
+
+func TestBlake2b(t *testing.T) {
+	for _, length := range []int{0, 1, 127, 128, 129, 1000} {
+		data := bytes.Repeat([]byte{byte(length)}, length)
+		for _, size := range []int{1, 32, 64} {
+			hash, err := blake2bRef.New(size, nil)
+			require.NoError(t, err)
+			_, _ = hash.Write(data)
+			require.Equal(t, hash.Sum(nil), blake2b(size, data), "length %d, size %d", length, size)
+		}
+	}
+}
+
+func TestArgon2id(t *testing.T) {
+	for _, params := range []struct{ time, memory, threads, keyLen uint32 }{{1, 64, 1, 32}, {2, 1000, 4, 32}, {3, 512, 2, 100}} {
+		expected := argon2.IDKey([]byte("password"), []byte("somesalt"), params.time, params.memory, uint8(params.threads), params.keyLen)
+		actual := argon2id([]byte("password"), []byte("somesalt"), params.time, params.memory, params.threads, params.keyLen)
+		require.Equal(t, expected, actual, "params %+v", params)
+	}
+}
+
+func TestOpenXChaCha20Poly1305(t *testing.T) {
+	key, nonce := make([]byte, chacha20poly1305.KeySize), make([]byte, chacha20poly1305.NonceSizeX)
+	_, _ = rand.Read(key)
+	_, _ = rand.Read(nonce)
+	aead, err := chacha20poly1305.NewX(key)
+	require.NoError(t, err)
+	for _, length := range []int{0, 1, 64, 100, 1000} {
+		plain := bytes.Repeat([]byte{byte(length)}, length)
+		sealed := aead.Seal(nil, nonce, plain, []byte("associated data"))
+		opened, err := openXChaCha20Poly1305(key, nonce, sealed, []byte("associated data"))
+		require.NoError(t, err)
+		require.Equal(t, plain, opened)
+
+		// Tampered data and associated data
+		sealed[0] ^= 1
+		_, err = openXChaCha20Poly1305(key, nonce, sealed, []byte("associated data"))
+		require.Error(t, err)
+		sealed[0] ^= 1
+		_, err = openXChaCha20Poly1305(key, nonce, sealed, []byte("other data"))
+		require.Error(t, err)
+	}
+}
+
+func TestDecryptStream(t *testing.T) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	_, _ = rand.Read(key)
+	aead, err := chacha20poly1305.NewX(key)
+	require.NoError(t, err)
+	for _, length := range []int{0, 1, 64 * 1024, 64*1024 + 1, 200_000} {
+		plain := bytes.Repeat([]byte{byte(length)}, length)
+		var encrypted bytes.Buffer
+		encryptWriter, err := encrypt.NewEncryptWriter(&encrypted, aead, []byte("associated data"))
+		require.NoError(t, err)
+		_, err = encryptWriter.Write(plain)
+		require.NoError(t, err)
+		require.NoError(t, encryptWriter.Close())
+		decrypted, err := decryptStream(key, encrypted.Bytes(), []byte("associated data"))
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(plain, decrypted), "decrypted data differs for length %d", length)
+
+		// Truncated data
+		if length > 64*1024 {
+			_, err = decryptStream(key, encrypted.Bytes()[:16+64*1024+16], []byte("associated data"))
+			require.Error(t, err)
+		}
+	}
+}
+
+func TestUnxz(t *testing.T) {
+	// Mix of text and random data, which results in both LZMA and uncompressed chunks
+	random := make([]byte, 200_000)
+	_, _ = rand.Read(random)
+	text := []byte(strings.Repeat(assets.ReferenceDecoder, 20))
+	for _, data := range [][]byte{{}, []byte("a"), text, append(append(text, random...), text...)} {
+		var compressed bytes.Buffer
+		require.NoError(t, compress.Compress(bytes.NewReader(data), &compressed))
+		actual, err := unxz(compressed.Bytes())
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(data, actual), "decompressed data differs for length %d", len(data))
+	}
+}
+
+func TestRun(t *testing.T) {
+	// Encode like encode command does, with parity pages and a keyfile
+	data := []byte(strings.Repeat(assets.ReferenceDecoder, 2))
+	keyfile := []byte("keyfile content")
+	kdfParams := encrypt.KDFParams{Time: 1, Memory: 1024, Threads: 2, KeyLength: 32}
+	salt, err := encrypt.GenerateSalt()
+	require.NoError(t, err)
+	header := encode.QRHeader{
+		Version:     encode.FormatVersion,
+		Compression: compress.AlgorithmXZ,
+		KDFAlgo:     encrypt.KDFArgon2id,
+		AEAD:        encrypt.AEADXChaCha20Poly1305Stream,
+		Salt:        salt,
+		KDF:         &kdfParams,
+		Keyfile:     true,
+		ParityPages: 1,
+	}
+	aead, err := encrypt.NewAEADFromPassword(header.KDFAlgo, header.AEAD, "password", keyfile, salt, kdfParams)
+	require.NoError(t, err)
+	ad, err := header.AssociatedData()
+	require.NoError(t, err)
+	var encrypted bytes.Buffer
+	encryptWriter, err := encrypt.NewEncryptWriter(&encrypted, aead, ad)
+	require.NoError(t, err)
+	require.NoError(t, compress.Compress(bytes.NewReader(data), encryptWriter))
+	require.NoError(t, encryptWriter.Close())
+	pageCount, err := encode.CalcPageCount(header, qrcode.ECCLevelL, uint(encrypted.Len()), 0)
+	require.NoError(t, err)
+	header.PageCount = uint32(pageCount)
+	header.DataSize = uint32(len(encrypted.Bytes()))
+	documentID, err := encode.GenerateDocumentID()
+	require.NoError(t, err)
+	qrCodes, err := encode.GenerateQRCodes(header, qrcode.ECCLevelL, documentID, encrypted.Bytes())
+	require.NoError(t, err)
+	firstQRCode, _, err := decodeCBOR(qrCodes[0].Payload)
+	require.NoError(t, err)
+	require.Equal(t, ad, associatedData(firstQRCode.(map[string]any)["header"].(map[string]any)))
+
+	// Write first QR code as text, like typed from the sheet, and others as raw payloads
+	dir := t.TempDir()
+	lines := encode.TextLines(qrCodes[0].Payload)
+	text := encode.TextHeading(1, len(qrCodes), len(lines)) + "\n" + strings.Join(lines, "\n")
+	inputPaths := []string{filepath.Join(dir, "qr-1.txt")}
+	require.NoError(t, os.WriteFile(inputPaths[0], []byte(strings.ToLower(text)), 0o600))
+	for i, qrCode := range qrCodes[1:] {
+		inputPaths = append(inputPaths, filepath.Join(dir, fmt.Sprintf("qr-%d", i+2)))
+		require.NoError(t, os.WriteFile(inputPaths[len(inputPaths)-1], qrCode.Payload, 0o600))
+	}
+	keyfilePath := filepath.Join(dir, "keyfile")
+	require.NoError(t, os.WriteFile(keyfilePath, keyfile, 0o600))
+
+	// Decode
+	outputPath := filepath.Join(dir, "output")
+	t.Setenv("PASSWORD", "password")
+	require.NoError(t, run(outputPath, keyfilePath, inputPaths[:len(qrCodes)-1])) // Without parity QR code
+	output, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	require.Equal(t, data, output)
+
+	// Wrong password
+	t.Setenv("PASSWORD", "wrong")
+	require.ErrorContains(t, run(outputPath, keyfilePath, inputPaths), "authentication failed")
+
+	// Missing QR code
+	require.ErrorContains(t, run(outputPath, keyfilePath, inputPaths[:1]), "QR code 2 is missing")
+}
+
+func TestFormatVersionIsSupported(t *testing.T) {
+	require.Equal(t, uint64(encode.FormatVersion), uint64(maxFormatVersion), "ensure the reference decoder supports the new format version")
+}
+
+func TestListingMatchesPrintedSource(t *testing.T) {
+	printed := encode.DecoderListing()
+	lines := strings.Split(strings.TrimSuffix(assets.ReferenceDecoder, "\n"), "\n")
+	require.Len(t, printed, len(lines))
+	for i, line := range lines {
+		require.Equal(t, printed[i], listingLine(i+1, line))
+		require.LessOrEqual(t, len(printed[i]), 104, "line %d is too long to print", i+1)
+	}
+}
@@ -2,11 +2,15 @@ package cmd
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 
+	"filippo.io/age"
 	"github.com/spf13/cobra"
 
 	"github.com/JenswBE/encrypted-paper/compress"
@@ -15,10 +19,16 @@ import (
 )
 
 var (
-	decodeFlagOutput string
-	decodeFlagForce  bool
-	decodeCmd        = &cobra.Command{
-		Use:          "decode [flags] input_file ...",
+	decodePasswordSource encrypt.PasswordSource
+	decodeFlagOutput     string
+	decodeFlagForce      bool
+	decodeFlagIdentities []string
+	decodeFlagRaw        bool
+	decodeFlagKeyfile    string
+	decodeFlagDocumentID string
+	decodeFlagFromText   []string
+	decodeCmd            = &cobra.Command{
+		Use:          "decode [flags] [input_file ...]",
 		Short:        "Parse QR code, decrypt and decompress data",
 		RunE:         runDecode,
 		SilenceUsage: true,
@@ -28,6 +38,12 @@ var (
 func init() {
 	decodeCmd.Flags().StringVarP(&decodeFlagOutput, "output", "o", "", "Output file name")
 	decodeCmd.Flags().BoolVar(&decodeFlagForce, "force", false, "Force overwrite output file if exists")
+	decodeCmd.Flags().StringArrayVarP(&decodeFlagIdentities, "identity", "i", nil, "Identity file with X25519 private key, as generated by keygen. Required for sheets encrypted to recipients. Can be repeated.")
+	decodeCmd.Flags().BoolVar(&decodeFlagRaw, "raw", false, "Write the combined payload without decrypting and decompressing. For format age, the output can be decrypted with \"age -d\" and decompressed with \"xz -d\".")
+	decodeCmd.Flags().StringVar(&decodeFlagKeyfile, "keyfile", "", "Keyfile which was used during encode, if any")
+	decodeCmd.Flags().StringVar(&decodeFlagDocumentID, "document-id", "", "Document ID as printed in the footer of the sheets. Scans of other documents are ignored. Required if scans of multiple documents are provided.")
+	decodeCmd.Flags().StringArrayVar(&decodeFlagFromText, "from-text", nil, "Text file with the typed or OCR'd text lines, as printed by encode --text. Can be combined with scans to replace damaged QR codes. Can be repeated.")
+	addPasswordFlags(decodeCmd.Flags(), &decodePasswordSource)
 }
 
 // DECODE
@@ -39,8 +55,16 @@ func runDecode(_ *cobra.Command, args []string) error {
 	if decodeFlagOutput == "" {
 		return errors.New("output is a mandatory parameter")
 	}
-	if len(args) == 0 {
-		return errors.New("at least 1 input file should be provided")
+	if len(args) == 0 && len(decodeFlagFromText) == 0 {
+		return errors.New("at least 1 input file or text file should be provided")
+	}
+	var documentID encode.DocumentID
+	if decodeFlagDocumentID != "" {
+		var err error
+		documentID, err = encode.ParseDocumentID(decodeFlagDocumentID)
+		if err != nil {
+			return fmt.Errorf("invalid document ID: %w", err)
+		}
 	}
 
 	// Check output file already exists
@@ -60,51 +84,225 @@ func runDecode(_ *cobra.Command, args []string) error {
 			return fmt.Errorf("failed to read file %s: %w", inputFile, err)
 		}
 	}
+	textFilesContents := make(map[string][]byte, len(decodeFlagFromText))
+	for _, textFile := range decodeFlagFromText {
+		textFilesContents[textFile], err = os.ReadFile(filepath.Clean(textFile))
+		if err != nil {
+			return fmt.Errorf("failed to read text file %s: %w", textFile, err)
+		}
+	}
 
-	// Request password
-	password, err := encrypt.GetPassword(false)
-	if err != nil {
-		return fmt.Errorf("failed to get password: %w", err)
+	// Combine QR codes without decrypting
+	if decodeFlagRaw {
+		payload, err := encode.ScanAndCombineQRCodes(inputFilesContents, textFilesContents, documentID)
+		if err != nil {
+			return fmt.Errorf("failed to scan and combine QR codes: %w", err)
+		}
+		err = os.WriteFile(decodeFlagOutput, payload.Data, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+		return nil
+	}
+
+	// Read identities
+	var identities []age.Identity
+	for _, identityFile := range decodeFlagIdentities {
+		fileIdentities, err := encrypt.ReadIdentities(identityFile)
+		if err != nil {
+			return fmt.Errorf("failed to read identities: %w", err)
+		}
+		identities = append(identities, fileIdentities...)
 	}
 
-	// Decode QR codes
-	output, err := decodeQRCodes(inputFilesContents, password)
+	// Read keyfile
+	var keyfile []byte
+	if decodeFlagKeyfile != "" {
+		keyfile, err = readKeyfile(decodeFlagKeyfile)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Create output file
+	outputFile, err := os.OpenFile(filepath.Clean(decodeFlagOutput), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
 	if err != nil {
-		return fmt.Errorf("failed to decode QR codes: %w", err)
+		return fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	// Write output file
-	err = os.WriteFile(decodeFlagOutput, output, 0o600)
+	// Decode QR codes. Password is only requested if the key is derived from a password.
+	err = decodeQRCodes(inputFilesContents, textFilesContents, documentID, decodeKeys{
+		GetPassword: func() (string, error) { return decodePasswordSource.GetPassword(false) },
+		Keyfile:     keyfile,
+		Identities:  identities,
+	}, outputFile)
+	if err == nil {
+		err = outputFile.Close()
+	}
 	if err != nil {
-		return fmt.Errorf("failed to write output file: %w", err)
+		// Output might contain decrypted chunks before the failure, which shouldn't be used
+		_ = outputFile.Close()
+		_ = os.Remove(decodeFlagOutput)
+		return fmt.Errorf("failed to decode QR codes: %w", err)
 	}
 	return nil
 }
 
-func decodeQRCodes(qrCodes map[string][]byte, password string) ([]byte, error) {
+// decodeKeys provides the secrets to obtain the key. Only the secrets required by the sheets are used.
+type decodeKeys struct {
+	// GetPassword is only called if the key is derived from a password
+	GetPassword func() (string, error)
+
+	// Keyfile is mixed into the key derivation if required by the sheets
+	Keyfile []byte
+
+	// Identities are used to unwrap the key of sheets encrypted to recipients
+	Identities []age.Identity
+
+	// Key skips unwrapping the key of sheets encrypted to recipients. Used to validate generated sheets.
+	Key []byte
+}
+
+// decodeQRCodes scans, decrypts and decompresses the QR codes and texts of the text fallback into output.
+// Set documentID to nil to require all QR codes to belong to the same document.
+// Data is streamed, so output might be partially written on failure.
+func decodeQRCodes(qrCodes, texts map[string][]byte, documentID encode.DocumentID, keys decodeKeys, output io.Writer) error {
 	// Scan and combine QR codes
-	encryptedData, salt, err := encode.ScanAndCombineQRCodes(qrCodes)
+	payload, err := encode.ScanAndCombineQRCodes(qrCodes, texts, documentID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to scan and combine QR codes: %w", err)
+		return fmt.Errorf("failed to scan and combine QR codes: %w", err)
 	}
 
-	// Generate authenticated encryption cipher
-	aead, err := encrypt.AEADFromPassword(password, salt)
+	return decryptAndDecompress(payload.Header, payload.KeyShares, bytes.NewReader(payload.Data), keys, output)
+}
+
+// decryptAndDecompress decrypts and decompresses the combined data of the QR codes into output.
+// Key shares are only used if the key is split. Output might be partially written on failure.
+func decryptAndDecompress(header *encode.QRHeader, keyShares []encrypt.KeyShare, data io.Reader, keys decodeKeys, output io.Writer) error {
+	// Decrypt data
+	var compressedData io.Reader
+	var err error
+	if header.AEAD == encrypt.AEADAge {
+		compressedData, err = decryptAge(data, keys)
+	} else {
+		compressedData, err = decryptNative(header, keyShares, data, keys)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher from password and salt: %w", err)
+		return fmt.Errorf("failed to decrypt data: %w", explainDecryptionError(header, err))
 	}
 
-	// Decode data
-	compressedData, err := encrypt.Decrypt(encryptedData, aead)
+	// Decompress data
+	err = compress.DecompressWith(header.Compression, compressedData, output)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt data: %w", err)
+		return fmt.Errorf("failed to decrypt and decompress data: %w", explainDecryptionError(header, err))
+	}
+	return nil
+}
+
+// explainDecryptionError adds the likely cause to errors caused by failed authentication.
+// If the data hash in the header matches, the pages are intact and the key must be wrong.
+func explainDecryptionError(header *encode.QRHeader, err error) error {
+	if !errors.Is(err, encrypt.ErrAuthenticationFailed) {
+		return err
+	}
+	if header.DataHash == nil {
+		slog.Error("Decryption failed. Please check you password and retry. If the password is correct, the sheets might have been tampered with.")
+		return err
 	}
+	switch {
+	case header.KDFAlgo == encrypt.KDFShamir:
+		return fmt.Errorf("pages are intact, so the key shares are wrong or the header was tampered with: %w", err)
+	case header.KDFAlgo == encrypt.KDFX25519:
+		return fmt.Errorf("pages are intact, so the wrapped key or the header was tampered with: %w", err)
+	case header.Keyfile:
+		return fmt.Errorf("pages are intact, so the password or keyfile is wrong: %w", err)
+	default:
+		return fmt.Errorf("pages are intact, so the password is wrong: %w", err)
+	}
+}
 
-	// Decompress input file
-	var data bytes.Buffer
-	err = compress.Decompress(bytes.NewReader(compressedData), &data)
+// decryptNative returns a reader which decrypts data encrypted with an AEAD, authenticating the header
+func decryptNative(header *encode.QRHeader, keyShares []encrypt.KeyShare, data io.Reader, keys decodeKeys) (io.Reader, error) {
+	// Generate authenticated encryption cipher
+	var aead cipher.AEAD
+	var err error
+	switch header.KDFAlgo {
+	case encrypt.KDFShamir:
+		key, err := encrypt.CombineKey(keyShares)
+		if err != nil {
+			return nil, fmt.Errorf("failed to combine key shares: %w", err)
+		}
+		aead, err = encrypt.NewAEAD(header.AEAD, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher from key: %w", err)
+		}
+	case encrypt.KDFX25519:
+		key := keys.Key
+		if key == nil {
+			if len(keys.Identities) == 0 {
+				return nil, errors.New("sheets are encrypted to recipients: please provide an identity file with flag --identity")
+			}
+			key, err = encrypt.UnwrapKey(header.Recipients, keys.Identities)
+			if err != nil {
+				return nil, fmt.Errorf("failed to unwrap key: %w", err)
+			}
+		}
+		aead, err = encrypt.NewAEAD(header.AEAD, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher from key: %w", err)
+		}
+	default:
+		keyfile := keys.Keyfile
+		if header.Keyfile && keyfile == nil {
+			return nil, errors.New("sheets require a keyfile: please provide it with flag --keyfile")
+		}
+		if !header.Keyfile && keyfile != nil {
+			slog.Warn("Ignoring keyfile, as sheets don't require a keyfile")
+			keyfile = nil
+		}
+		password, err := keys.GetPassword()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get password: %w", err)
+		}
+		aead, err = encrypt.NewAEADFromPassword(header.KDFAlgo, header.AEAD, password, keyfile, header.Salt, *header.KDF)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher from password and salt: %w", err)
+		}
+	}
+
+	// Decrypt data
+	associatedData, err := header.AssociatedData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive associated data from header: %w", err)
+	}
+	if header.AEAD == encrypt.AEADXChaCha20Poly1305Stream {
+		return encrypt.NewDecryptReader(data, aead, associatedData)
+	}
+	encryptedData, err := io.ReadAll(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encrypted data: %w", err)
+	}
+	decryptedData, err := encrypt.Decrypt(encryptedData, aead, associatedData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decompress data: %w", err)
+		return nil, err
+	}
+	return bytes.NewReader(decryptedData), nil
+}
+
+// decryptAge returns a reader which decrypts data in the age format. Password is only requested if no identities are provided.
+func decryptAge(data io.Reader, keys decodeKeys) (io.Reader, error) {
+	// Collect identities
+	identities := keys.Identities
+	if len(identities) == 0 {
+		password, err := keys.GetPassword()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get password: %w", err)
+		}
+		identity, err := encrypt.NewPasswordIdentity(password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create password identity: %w", err)
+		}
+		identities = []age.Identity{identity}
 	}
-	return data.Bytes(), nil
+	return encrypt.NewAgeReader(data, identities)
 }
@@ -2,22 +2,55 @@ package cmd
 
 import (
 	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"filippo.io/age"
 	"github.com/spf13/cobra"
 
 	"github.com/JenswBE/encrypted-paper/compress"
 	"github.com/JenswBE/encrypted-paper/encode"
 	"github.com/JenswBE/encrypted-paper/encrypt"
+	"github.com/JenswBE/encrypted-paper/qrcode"
+)
+
+// Supported encryption formats
+const (
+	formatNative = "native"
+	formatAge    = "age" // Payload is a standard age file, see https://age-encryption.org
 )
 
 var (
+	encodePasswordSource     encrypt.PasswordSource
 	encodeFlagTitle          string
 	encodeFlagOutput         string
 	encodeFlagMaxOutputFiles uint
+	encodeFlagKDFTime        uint32
+	encodeFlagKDFMemory      uint32
+	encodeFlagKDFThreads     uint8
+	encodeFlagParityPages    uint
+	encodeFlagShares         uint
+	encodeFlagThreshold      uint
+	encodeFlagRecipients     []string
+	encodeFlagFormat         string
+	encodeFlagGenPassphrase  bool
+	encodeFlagWords          uint
+	encodeFlagKeySheet       string
+	encodeFlagKeyfile        string
+	encodeFlagLayout         string
+	encodeFlagQRPerPage      uint
+	encodeFlagECCLevel       string
+	encodeFlagText           bool
+	encodeFlagCover          bool
+	encodeFlagIncludeDecoder bool
 	encodeCmd                = &cobra.Command{
 		Use:          "encode [flags] input_file",
 		Short:        "Compress, encrypt and convert data into QR codes",
@@ -30,126 +63,568 @@ var (
 func init() {
 	encodeCmd.Flags().StringVarP(&encodeFlagTitle, "title", "t", "", "Title on each output page")
 	encodeCmd.Flags().StringVarP(&encodeFlagOutput, "output", "o", "encrypted-paper.pdf", "Output file name")
-	encodeCmd.Flags().UintVar(&encodeFlagMaxOutputFiles, "max-output-files", 10, "Maximum number of output files to generate. Set to 0 to disable limit.")
+	encodeCmd.Flags().UintVar(&encodeFlagMaxOutputFiles, "max-output-files", 10, "Maximum number of QR codes in each output file, as sanity check against large inputs. Parity QR codes are not included. With a layout, multiple QR codes are printed on each page. Set to 0 to only limit by the maximum supported number of QR codes (65535).")
+	encodeCmd.Flags().Uint32Var(&encodeFlagKDFTime, "kdf-time", encrypt.DefaultKDFParams.Time, fmt.Sprintf("Number of Argon2id passes over the memory (at most %d)", encrypt.MaxKDFTime))
+	encodeCmd.Flags().Uint32Var(&encodeFlagKDFMemory, "kdf-memory", encrypt.DefaultKDFParams.Memory, fmt.Sprintf("Argon2id memory size in KiB (at most %d)", encrypt.MaxKDFMemory))
+	encodeCmd.Flags().Uint8Var(&encodeFlagKDFThreads, "kdf-threads", encrypt.DefaultKDFParams.Threads, "Argon2id degree of parallelism")
+	encodeCmd.Flags().UintVar(&encodeFlagParityPages, "parity-pages", 0, "Number of Reed-Solomon parity pages to add. Up to this number of missing or unreadable pages can be recovered.")
+	encodeCmd.Flags().UintVar(&encodeFlagShares, "shares", 0, "Split a random key in this number of shares using Shamir's Secret Sharing instead of using a password. Each share is written to its own PDF.")
+	encodeCmd.Flags().UintVar(&encodeFlagThreshold, "threshold", 0, "Number of shares required to decode. Required when using --shares.")
+	encodeCmd.Flags().StringArrayVarP(&encodeFlagRecipients, "recipient", "r", nil, "Encrypt to the X25519 public key (age1...) instead of using a password. Can be repeated.")
+	encodeCmd.Flags().StringVar(&encodeFlagFormat, "format", formatNative, `Encryption format. Either "native" or "age". Payload of format "age" can be decrypted with the stock age tool.`)
+	encodeCmd.Flags().BoolVar(&encodeFlagGenPassphrase, "generate-passphrase", false, "Generate a diceware passphrase instead of requesting a password. Passphrase is printed once.")
+	encodeCmd.Flags().UintVar(&encodeFlagWords, "words", 7, "Number of words in the generated passphrase")
+	encodeCmd.Flags().StringVar(&encodeFlagKeySheet, "key-sheet", "", "Also write the generated passphrase to this PDF file, to be stored apart from the data sheets")
+	encodeCmd.Flags().StringVar(&encodeFlagKeyfile, "keyfile", "", "Mix the content of this file into the key derivation. Both password and keyfile are required to decode.")
+	encodeCmd.Flags().StringVar(&encodeFlagLayout, "layout", "", "Grid of QR codes on each page, formatted as COLUMNSxROWS, e.g. 2x2. Defaults to a single QR code per page.")
+	encodeCmd.Flags().UintVar(&encodeFlagQRPerPage, "qr-per-page", 0, "Number of QR codes on each page, laid out in the most square grid. Cannot be combined with --layout.")
+	encodeCmd.Flags().StringVar(&encodeFlagECCLevel, "ecc", qrcode.ECCLevelL.String(), "Error correction level of the QR codes: L (7%), M (15%), Q (25%) or H (30%). Higher levels tolerate more damage, but require more pages.")
+	encodeCmd.Flags().BoolVar(&encodeFlagText, "text", false, "Also print the data of each QR code as text lines with checksums, which can be typed or OCR'd and decoded with decode --from-text if a QR code is damaged")
+	encodeCmd.Flags().BoolVar(&encodeFlagCover, "cover", false, "Prepend a cover page with recovery instructions and a description of the format, so the sheets can be decoded without this tool")
+	encodeCmd.Flags().BoolVar(&encodeFlagIncludeDecoder, "include-decoder", false, "Append the source of a minimal reference decoder, which only depends on the Go standard library, with a checksum on each line")
+	addPasswordFlags(encodeCmd.Flags(), &encodePasswordSource)
 }
 
 func runEncode(_ *cobra.Command, args []string) error {
 	// Parse encode config
-	config, err := parseEncodeConfig(encodeFlagTitle, args[0], encodeFlagOutput, encodeFlagMaxOutputFiles)
+	kdfParams := encrypt.KDFParams{
+		Time:      encodeFlagKDFTime,
+		Memory:    encodeFlagKDFMemory,
+		Threads:   encodeFlagKDFThreads,
+		KeyLength: encrypt.DefaultKDFParams.KeyLength,
+	}
+	var passphraseWords uint
+	if encodeFlagGenPassphrase {
+		passphraseWords = encodeFlagWords
+		if encodePasswordSource.IsSet() {
+			return errors.New("generate passphrase cannot be combined with a password source")
+		}
+	}
+	config, err := parseEncodeConfig(encodeFlags{
+		Title:            encodeFlagTitle,
+		InputFile:        args[0],
+		OutputFileName:   encodeFlagOutput,
+		MaxOutputFiles:   encodeFlagMaxOutputFiles,
+		KDFParams:        kdfParams,
+		ParityPages:      encodeFlagParityPages,
+		Shares:           encodeFlagShares,
+		Threshold:        encodeFlagThreshold,
+		Recipients:       encodeFlagRecipients,
+		Format:           encodeFlagFormat,
+		PassphraseWords:  passphraseWords,
+		KeySheetFileName: encodeFlagKeySheet,
+		KeyfilePath:      encodeFlagKeyfile,
+		Layout:           encodeFlagLayout,
+		QRPerPage:        encodeFlagQRPerPage,
+		ECCLevel:         encodeFlagECCLevel,
+		Text:             encodeFlagText,
+		Cover:            encodeFlagCover,
+		Decoder:          encodeFlagIncludeDecoder,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to parse encode config: %w", err)
 	}
 
-	// Request password
-	password, err := encrypt.GetPassword(true)
-	if err != nil {
-		return fmt.Errorf("failed to get password: %w", err)
+	// Get password. Not needed when the key is split in shares or encrypted to recipients.
+	var password string
+	switch {
+	case config.PassphraseWords > 0:
+		password, err = encrypt.GeneratePassphrase(int(config.PassphraseWords))
+		if err != nil {
+			return fmt.Errorf("failed to generate passphrase: %w", err)
+		}
+	case config.Shares == 0 && len(config.Recipients) == 0:
+		password, err = encodePasswordSource.GetPassword(true)
+		if err != nil {
+			return fmt.Errorf("failed to get password: %w", err)
+		}
+	}
+
+	// Write key sheet and show generated passphrase before any data sheet, so the passphrase is never lost
+	if config.PassphraseWords > 0 {
+		if config.KeySheetFileName != "" {
+			err = encode.GenerateKeySheetPDF(config.KeySheetFileName, config.Title, password)
+			if err != nil {
+				return fmt.Errorf("failed to generate key sheet: %w", err)
+			}
+		}
+		fmt.Printf("Generated passphrase (shown only once): %s\n", password)
 	}
 
 	// Marshal data
 	err = marshal(config, password)
 	if err != nil {
+		if config.PassphraseWords > 0 {
+			// Some data sheets might already be written and stdout might not be shown on failure
+			fmt.Fprintf(os.Stderr, "Generated passphrase: %s\n", password)
+		}
 		return fmt.Errorf("failed to encode data: %w", err)
 	}
 	return nil
 }
 
+// encodeFlags contains the unparsed flags and arguments of the encode command
+type encodeFlags struct {
+	Title            string
+	InputFile        string
+	OutputFileName   string
+	MaxOutputFiles   uint
+	KDFParams        encrypt.KDFParams
+	ParityPages      uint
+	Shares           uint
+	Threshold        uint
+	Recipients       []string
+	Format           string
+	PassphraseWords  uint // 0 if no passphrase should be generated
+	KeySheetFileName string
+	KeyfilePath      string
+	Layout           string
+	QRPerPage        uint
+	ECCLevel         string
+	Text             bool
+	Cover            bool
+	Decoder          bool
+}
+
 type EncodeConfig struct {
+	Title          string
 	InputPath      string
 	MaxOutputFiles uint
 	OutputFileName string
+	KDFParams      encrypt.KDFParams
+	ParityPages    uint
+	Shares         uint // 0 if the key is not split in shares
+	Threshold      uint
+	Recipients     []age.Recipient
+	Format         string
+
+	// PassphraseWords is the number of words of the generated passphrase. 0 if no passphrase should be generated.
+	PassphraseWords  uint
+	KeySheetFileName string // Empty if no key sheet should be generated
+
+	Keyfile []byte // Content of the keyfile. Nil if no keyfile is used.
+
+	Layout   encode.Layout   // Grid of QR codes on each page
+	ECCLevel qrcode.ECCLevel // Error correction level of the QR codes
+	Text     bool            // Print text fallback of the QR codes
+	Cover    bool            // Prepend cover page with recovery instructions
+	Decoder  bool            // Append source of the reference decoder
 }
 
-func parseEncodeConfig(title, inputFile, outputFileName string, maxOutputFiles uint) (EncodeConfig, error) {
+func parseEncodeConfig(flags encodeFlags) (EncodeConfig, error) {
 	// Validate flags
-	if title == "" {
+	if flags.Title == "" {
 		return EncodeConfig{}, errors.New("title is a mandatory parameter")
 	}
-	if inputFile == "" {
+	if flags.InputFile == "" {
 		return EncodeConfig{}, errors.New("input file is a mandatory parameter")
 	}
-	if outputFileName == "" {
+	if flags.OutputFileName == "" {
 		return EncodeConfig{}, errors.New("output file name cannot be empty")
 	}
-	if filepath.Ext(outputFileName) != ".pdf" {
+	if filepath.Ext(flags.OutputFileName) != ".pdf" {
 		return EncodeConfig{}, errors.New("output file must have extension .pdf")
 	}
+	if err := flags.KDFParams.Validate(); err != nil {
+		return EncodeConfig{}, fmt.Errorf("invalid KDF parameters: %w", err)
+	}
+	if flags.ParityPages >= encode.MaxPageCount {
+		return EncodeConfig{}, fmt.Errorf("parity pages must be less than %d", encode.MaxPageCount)
+	}
+	if flags.Shares > 0 {
+		if flags.Shares < 2 || flags.Shares > math.MaxUint8 {
+			return EncodeConfig{}, fmt.Errorf("shares must be between 2 and %d", math.MaxUint8)
+		}
+		if flags.Threshold < 2 || flags.Threshold > flags.Shares {
+			return EncodeConfig{}, fmt.Errorf("threshold must be between 2 and the number of shares (%d)", flags.Shares)
+		}
+	} else if flags.Threshold > 0 {
+		return EncodeConfig{}, errors.New("threshold can only be set together with shares")
+	}
+	if flags.Shares > 0 && len(flags.Recipients) > 0 {
+		return EncodeConfig{}, errors.New("shares and recipients cannot be combined")
+	}
+	if flags.Format != formatNative && flags.Format != formatAge {
+		return EncodeConfig{}, fmt.Errorf(`format must be either "%s" or "%s"`, formatNative, formatAge)
+	}
+	if flags.Format == formatAge && flags.Shares > 0 {
+		return EncodeConfig{}, errors.New("shares are not supported by format age")
+	}
+	if flags.PassphraseWords > 0 {
+		if flags.PassphraseWords < encrypt.MinPassphraseWords {
+			return EncodeConfig{}, fmt.Errorf("passphrase must at least contain %d words", encrypt.MinPassphraseWords)
+		}
+		if flags.Shares > 0 || len(flags.Recipients) > 0 {
+			return EncodeConfig{}, errors.New("generate passphrase cannot be combined with shares or recipients")
+		}
+	}
+	if flags.KeySheetFileName != "" {
+		if flags.PassphraseWords == 0 {
+			return EncodeConfig{}, errors.New("key sheet can only be generated together with a generated passphrase")
+		}
+		if filepath.Ext(flags.KeySheetFileName) != ".pdf" {
+			return EncodeConfig{}, errors.New("key sheet file must have extension .pdf")
+		}
+	}
+	if flags.KeyfilePath != "" && (flags.Shares > 0 || len(flags.Recipients) > 0 || flags.Format == formatAge) {
+		return EncodeConfig{}, errors.New("keyfile can only be combined with a password and format native")
+	}
+	if flags.Decoder && (flags.Shares > 0 || len(flags.Recipients) > 0 || flags.Format == formatAge) {
+		return EncodeConfig{}, errors.New("reference decoder only supports a password and format native")
+	}
+	parsedRecipients, err := encrypt.ParseRecipients(flags.Recipients)
+	if err != nil {
+		return EncodeConfig{}, fmt.Errorf("invalid recipients: %w", err)
+	}
+	parsedLayout := encode.DefaultLayout
+	switch {
+	case flags.Layout != "" && flags.QRPerPage > 0:
+		return EncodeConfig{}, errors.New("layout and QR codes per page cannot be combined")
+	case flags.Layout != "":
+		parsedLayout, err = encode.ParseLayout(flags.Layout)
+	case flags.QRPerPage > 0:
+		parsedLayout, err = encode.LayoutForCount(int(flags.QRPerPage))
+	}
+	if err == nil {
+		err = parsedLayout.Validate()
+	}
+	if err != nil {
+		return EncodeConfig{}, fmt.Errorf("invalid layout: %w", err)
+	}
+	parsedECCLevel, err := qrcode.ParseECCLevel(flags.ECCLevel)
+	if err != nil {
+		return EncodeConfig{}, fmt.Errorf("invalid error correction level: %w", err)
+	}
 
 	// Ensure input file is readable
-	if _, err := os.Stat(inputFile); err != nil {
+	if _, err := os.Stat(flags.InputFile); err != nil {
 		return EncodeConfig{}, fmt.Errorf("unable to read input file: %w", err)
 	}
 
+	// Read keyfile
+	var keyfile []byte
+	if flags.KeyfilePath != "" {
+		keyfile, err = readKeyfile(flags.KeyfilePath)
+		if err != nil {
+			return EncodeConfig{}, err
+		}
+	}
+
 	// Build and return flags
 	return EncodeConfig{
-		InputPath:      inputFile,
-		MaxOutputFiles: maxOutputFiles,
-		OutputFileName: outputFileName,
+		Title:          flags.Title,
+		InputPath:      flags.InputFile,
+		MaxOutputFiles: flags.MaxOutputFiles,
+		OutputFileName: flags.OutputFileName,
+		KDFParams:      flags.KDFParams,
+		ParityPages:    flags.ParityPages,
+		Shares:         flags.Shares,
+		Threshold:      flags.Threshold,
+		Recipients:     parsedRecipients,
+		Format:         flags.Format,
+
+		PassphraseWords:  flags.PassphraseWords,
+		KeySheetFileName: flags.KeySheetFileName,
+
+		Keyfile: keyfile,
+
+		Layout:   parsedLayout,
+		ECCLevel: parsedECCLevel,
+		Text:     flags.Text,
+		Cover:    flags.Cover,
+		Decoder:  flags.Decoder,
 	}, nil
 }
 
 // MARSHAL
-//  1. Compress with XZ
-//  2. Encrypt using Argon2 and XChaCha20
-//  3. Convert to QR code (include metadata)
-//  4. Validate if output is decodeable and yields same as input
+//  1. Stream input through XZ compression and chunked encryption into the pages, hashing the input on the way.
+//     Encrypt using Argon2 (or a random key split in shares or wrapped for recipients) and XChaCha20 STREAM, authenticating the header.
+//     Or encrypt into a standard age file. Encrypted data is collected in a temporary file.
+//  2. Validate if encrypted data is decryptable and yields same hash as input
+//  3. Convert to QR code (include metadata) one page at a time, one set per key share.
+//     Validate if each QR code is decodable, before it's added to the PDF.
 func marshal(config EncodeConfig, password string) error {
-	// Read input file
-	inputFileContents, err := os.ReadFile(config.InputPath)
+	// Open input file
+	inputFile, err := os.Open(filepath.Clean(config.InputPath))
 	if err != nil {
-		return fmt.Errorf("failed to read input file contents: %w", err)
+		return fmt.Errorf("failed to open input file: %w", err)
 	}
+	defer inputFile.Close()
 
-	// Compress input file
-	var compressedInput bytes.Buffer
-	err = compress.Compress(bytes.NewReader(inputFileContents), &compressedInput)
+	// Build header
+	header := encode.QRHeader{
+		Version:     encode.FormatVersion,
+		Compression: compress.AlgorithmXZ,
+		ParityPages: uint32(config.ParityPages),
+	}
+
+	// Set up encryption. Header is complete afterwards, except for page count and data size.
+	var aead cipher.AEAD
+	var ageRecipients []age.Recipient
+	var keyShares []encrypt.KeyShare
+	keys := decodeKeys{GetPassword: func() (string, error) { return password, nil }, Keyfile: config.Keyfile}
+	if config.Format == formatAge {
+		ageRecipients, err = setupAgeEncryption(config, password, &header)
+	} else {
+		aead, keyShares, err = setupNativeEncryption(config, password, &header, &keys)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to compress input file: %w", err)
+		return fmt.Errorf("failed to set up encryption: %w", err)
 	}
 
-	// Generate salt
-	salt, err := encrypt.GenerateSalt()
+	// Build pipeline: input => hash => compress => encrypt => pages
+	// Spool pages next to the output, as the container image has no temporary directory
+	pageWriter, err := encode.NewPageWriter(filepath.Dir(config.OutputFileName), header, config.ECCLevel, config.MaxOutputFiles)
 	if err != nil {
-		return fmt.Errorf("failed to generate salt: %w", err)
+		return fmt.Errorf("failed to create page writer: %w", err)
+	}
+	defer func() {
+		if closeErr := pageWriter.Close(); closeErr != nil {
+			slog.Warn("Failed to clean up encrypted data", "error", closeErr)
+		}
+	}()
+	var encryptWriter io.WriteCloser
+	if config.Format == formatAge {
+		var fileKeyIdentity age.Identity
+		encryptWriter, fileKeyIdentity, err = encrypt.NewAgeWriter(pageWriter, ageRecipients)
+		keys.Identities = []age.Identity{fileKeyIdentity} // Identities of recipients are unknown
+	} else {
+		var associatedData []byte
+		associatedData, err = header.AssociatedData()
+		if err != nil {
+			return fmt.Errorf("failed to derive associated data from header: %w", err)
+		}
+		encryptWriter, err = encrypt.NewEncryptWriter(pageWriter, aead, associatedData)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create encryption writer: %w", err)
 	}
 
-	// Generate authenticated encryption cipher
-	aead, err := encrypt.AEADFromPassword(password, salt)
+	// Run pipeline
+	inputHash := sha256.New()
+	err = compress.Compress(io.TeeReader(inputFile, inputHash), encryptWriter)
 	if err != nil {
-		return fmt.Errorf("failed to create cipher from password and salt: %w", err)
+		return fmt.Errorf("failed to compress and encrypt input file: %w", err)
+	}
+	if err = encryptWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finalize encryption: %w", err)
 	}
+	if err = setPageCount(config, &header, pageWriter.Size()); err != nil {
+		return err
+	}
+	header.DataHash = pageWriter.Hash()
 
-	// Encrypt input file
-	encryptedInput, err := encrypt.Encrypt(compressedInput.Bytes(), aead)
+	// Each key share gets its own set, which only differs in the header.
+	// All sets share the same document ID, as they can be combined during decode.
+	documentID, err := encode.GenerateDocumentID()
 	if err != nil {
-		return fmt.Errorf("failed to encrypt input: %w", err)
+		return err
+	}
+	var sets []outputSet
+	if len(keyShares) == 0 {
+		sets = append(sets, outputSet{FileName: config.OutputFileName, Title: config.Title})
+	}
+	for _, keyShare := range keyShares {
+		sets = append(sets, outputSet{
+			FileName: fmt.Sprintf("%s-share-%d.pdf", strings.TrimSuffix(config.OutputFileName, ".pdf"), keyShare.Index),
+			Title:    fmt.Sprintf("%s (share %d of %d, %d required)", config.Title, keyShare.Index, keyShare.Count, keyShare.Threshold),
+			Share:    &keyShare,
+		})
 	}
 
-	// Encode into QR codes
-	qrCodes, err := encode.GenerateQRCodes(salt, encryptedInput, config.MaxOutputFiles)
+	// Ensure the QR codes are decodable before any PDF is written
+	err = validatePayloads(config, header, documentID, sets, pageWriter, keys, inputHash.Sum(nil))
 	if err != nil {
-		return fmt.Errorf("failed to encode data into QR code: %w", err)
+		return fmt.Errorf("failed to validate QR codes: %w", err)
+	}
+
+	// Encode into QR codes and generate PDFs
+	for _, set := range sets {
+		setHeader := header
+		setHeader.Share = set.Share
+		qrCodes, err := encode.NewQRCodes(setHeader, config.ECCLevel, documentID, pageWriter.Data(), pageWriter.Size())
+		if err != nil {
+			return fmt.Errorf("failed to encode data into QR codes for %s: %w", set.FileName, err)
+		}
+		var cover *encode.QRHeader
+		if config.Cover {
+			cover = &setHeader
+		}
+		err = encode.GeneratePDF(set.FileName, set.Title, documentID, config.Layout, verifiedQRCodes{qrCodes}, config.Text, cover, config.Decoder)
+		if err != nil {
+			return fmt.Errorf("failed to generate PDF %s: %w", set.FileName, err)
+		}
 	}
 
-	// Ensure QR codes are decodable
-	qrCodesMap := make(map[string][]byte, len(qrCodes))
-	for i, qrCode := range qrCodes {
-		qrCodesMap[fmt.Sprintf("roundtrip-%d", i)] = qrCode
+	// Show summary
+	fmt.Printf("Encoded %d bytes into %d QR codes (%d parity) on %d page(s) of QR codes per PDF\n",
+		pageWriter.Size(), header.PageCount, header.ParityPages, config.Layout.PageCount(int(header.PageCount)))
+	fmt.Printf("Error correction level: %s\n", config.ECCLevel)
+	if config.Text {
+		fmt.Println("Text fallback: printed on the page(s) following each page of QR codes")
+	}
+	if config.Cover {
+		fmt.Println("Cover page: recovery instructions printed on the first page")
+	}
+	if config.Decoder {
+		fmt.Println("Reference decoder: source printed on the last pages")
+	}
+	fmt.Printf("Document ID: %s\n", documentID)
+	for _, set := range sets {
+		fmt.Printf("Written: %s\n", set.FileName)
+	}
+	return nil
+}
+
+// setupNativeEncryption sets the key derivation in the header and returns the AEAD to encrypt the chunks.
+// Key is derived from the password, split in shares or wrapped for recipients.
+// As the identities of recipients are unknown, the key is set in keys to validate the output.
+func setupNativeEncryption(config EncodeConfig, password string, header *encode.QRHeader, keys *decodeKeys) (cipher.AEAD, []encrypt.KeyShare, error) {
+	header.AEAD = encrypt.AEADXChaCha20Poly1305Stream
+	var aead cipher.AEAD
+	var keyShares []encrypt.KeyShare
+	var err error
+	switch {
+	case config.Shares > 0:
+		// Generate random key and split in shares
+		header.KDFAlgo = encrypt.KDFShamir
+		key, err := encrypt.GenerateKey()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		keyShares, err = encrypt.SplitKey(key, int(config.Shares), int(config.Threshold))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to split key in shares: %w", err)
+		}
+		header.Share = &keyShares[0] // Required to calculate the page count, replaced for each set
+		aead, err = encrypt.NewAEAD(header.AEAD, key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create cipher from key: %w", err)
+		}
+	case len(config.Recipients) > 0:
+		// Generate random key and wrap for each recipient
+		header.KDFAlgo = encrypt.KDFX25519
+		keys.Key, header.Recipients, err = encrypt.WrapKey(config.Recipients)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to wrap key for recipients: %w", err)
+		}
+		aead, err = encrypt.NewAEAD(header.AEAD, keys.Key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create cipher from key: %w", err)
+		}
+	default:
+		// Derive key from password
+		header.KDFAlgo = encrypt.KDFArgon2id
+		header.KDF = &config.KDFParams
+		header.Keyfile = config.Keyfile != nil
+		header.Salt, err = encrypt.GenerateSalt()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+		aead, err = encrypt.NewAEADFromPassword(header.KDFAlgo, header.AEAD, password, config.Keyfile, header.Salt, config.KDFParams)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create cipher from password and salt: %w", err)
+		}
+	}
+	return aead, keyShares, nil
+}
+
+// setupAgeEncryption sets the age format in the header and returns the recipients for the password or public keys.
+// As age doesn't support associated data, the header is not authenticated.
+func setupAgeEncryption(config EncodeConfig, password string, header *encode.QRHeader) ([]age.Recipient, error) {
+	header.KDFAlgo = encrypt.KDFAge
+	header.AEAD = encrypt.AEADAge
+	if len(config.Recipients) > 0 {
+		return config.Recipients, nil
+	}
+	recipient, err := encrypt.NewPasswordRecipient(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create password recipient: %w", err)
 	}
-	decodedData, err := decodeQRCodes(qrCodesMap, password)
+	return []age.Recipient{recipient}, nil
+}
+
+// setPageCount calculates and sets the page count for the encrypted data in the header
+func setPageCount(config EncodeConfig, header *encode.QRHeader, encryptedSize uint) error {
+	pageCount, err := encode.CalcPageCount(*header, config.ECCLevel, encryptedSize, config.MaxOutputFiles)
 	if err != nil {
-		return fmt.Errorf("failed to decode generated QR codes for validation: %w", err)
+		return fmt.Errorf("failed to calculate page count: %w", err)
 	}
+	header.PageCount = uint32(pageCount)
+	if config.ParityPages > 0 {
+		header.DataSize = uint32(encryptedSize)
+	}
+	return nil
+}
+
+// outputSet is a set of QR codes which is written to a single PDF
+type outputSet struct {
+	FileName string
+	Title    string
+	Share    *encrypt.KeyShare // Key share in the header of the set. Nil if the key is not split.
+}
 
-	// Compare input data and decoded QR codes
-	if !bytes.Equal(inputFileContents, decodedData) {
-		return errors.New("input data and decoded QR data are different")
+// validatePayloads decodes the payloads of the QR codes the same way as decode and compares the result with the input.
+// With parity, as many data QR codes as there are parity QR codes are left out to validate the reconstruction.
+// With shares, the minimum number of sets is combined. Of the other sets, only QR codes with a header are used.
+func validatePayloads(config EncodeConfig, header encode.QRHeader, documentID encode.DocumentID, sets []outputSet, pageWriter *encode.PageWriter, keys decodeKeys, inputHash []byte) error {
+	// Collect payloads
+	var payloads [][]byte
+	for i, set := range sets[:max(config.Threshold, 1)] {
+		setHeader := header
+		setHeader.Share = set.Share
+		qrCodes, err := encode.NewQRCodes(setHeader, config.ECCLevel, documentID, pageWriter.Data(), pageWriter.Size())
+		if err != nil {
+			return fmt.Errorf("failed to encode data into QR codes for %s: %w", set.FileName, err)
+		}
+		for j := range qrCodes.Len() {
+			isParity := j >= int(header.DataPageCount())
+			switch {
+			case i == 0 && !isParity && j < int(header.ParityPages):
+				continue // Left out to be reconstructed
+			case i > 0 && !isParity && (j > 0 || header.ParityPages > 0):
+				continue // Only used for its header
+			}
+			payload, err := qrCodes.Payload(j)
+			if err != nil {
+				return fmt.Errorf("failed to encode QR code %d for %s: %w", j+1, set.FileName, err)
+			}
+			payloads = append(payloads, payload)
+		}
 	}
 
-	// Generate PDF
-	err = encode.GeneratePDF(config.OutputFileName, encodeFlagTitle, qrCodes)
+	// Decode payloads. Warnings about the left out QR codes are expected.
+	defer slog.SetLogLoggerLevel(slog.SetLogLoggerLevel(slog.LevelError))
+	payload, err := encode.CombinePayloads(payloads)
 	if err != nil {
-		return fmt.Errorf("failed to generate PDF: %w", err)
+		return fmt.Errorf("failed to combine QR codes: %w", err)
+	}
+	if !bytes.Equal(documentID, payload.DocumentID) {
+		return errors.New("document ID of combined QR codes differs from generated document ID")
+	}
+	decodedHash := sha256.New()
+	err = decryptAndDecompress(payload.Header, payload.KeyShares, bytes.NewReader(payload.Data), keys, decodedHash)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt combined QR codes: %w", err)
+	}
+	if !bytes.Equal(inputHash, decodedHash.Sum(nil)) {
+		return errors.New("input data and decoded data are different")
 	}
 	return nil
 }
+
+// verifiedQRCodes ensures each QR code is decodable, before it's added to the PDF
+type verifiedQRCodes struct {
+	encode.QRCodeSource
+}
+
+func (v verifiedQRCodes) QRCode(i int) (encode.QRCode, error) {
+	qrCode, err := v.QRCodeSource.QRCode(i)
+	if err != nil {
+		return encode.QRCode{}, err
+	}
+	if err = encode.VerifyQRCode(qrCode); err != nil {
+		return encode.QRCode{}, fmt.Errorf("failed to validate generated QR code: %w", err)
+	}
+	return qrCode, nil
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"filippo.io/age"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keygenFlagOutput string
+	keygenFlagForce  bool
+	keygenCmd        = &cobra.Command{
+		Use:          "keygen [flags]",
+		Short:        "Generate a X25519 identity to encrypt sheets to",
+		Args:         cobra.NoArgs,
+		RunE:         runKeygen,
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	keygenCmd.Flags().StringVarP(&keygenFlagOutput, "output", "o", "", "Output file name for the identity")
+	keygenCmd.Flags().BoolVar(&keygenFlagForce, "force", false, "Force overwrite output file if exists")
+}
+
+// KEYGEN
+// 1. Generate X25519 identity
+// 2. Write identity file, compatible with age-keygen
+// 3. Print public key to use as recipient
+func runKeygen(_ *cobra.Command, _ []string) error {
+	// Validate flags
+	if keygenFlagOutput == "" {
+		return errors.New("output is a mandatory parameter")
+	}
+
+	// Check output file already exists
+	_, err := os.Stat(keygenFlagOutput)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to check if output file exists: %w", err)
+	}
+	if err == nil && !keygenFlagForce {
+		return errors.New("output file already exists: either set flag --force or use another output file")
+	}
+
+	// Generate identity
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return fmt.Errorf("failed to generate identity: %w", err)
+	}
+
+	// Write identity file
+	publicKey := identity.Recipient().String()
+	contents := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), publicKey, identity)
+	err = os.WriteFile(keygenFlagOutput, []byte(contents), 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write identity file: %w", err)
+	}
+	fmt.Printf("Public key: %s\n", publicKey)
+	return nil
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/pflag"
+
+	"github.com/JenswBE/encrypted-paper/encrypt"
+)
+
+// addPasswordFlags adds the flags to provide the password without terminal, e.g. for automated backups
+func addPasswordFlags(flags *pflag.FlagSet, source *encrypt.PasswordSource) {
+	flags.StringVar(&source.File, "password-file", "", "Read password from file instead of prompting")
+	flags.StringVar(&source.Env, "password-env", "", "Read password from environment variable with this name instead of prompting")
+	flags.IntVar(&source.FD, "password-fd", -1, "Read password from file descriptor instead of prompting")
+}
+
+// readKeyfile reads the content of the keyfile which is mixed into the key derivation
+func readKeyfile(path string) ([]byte, error) {
+	keyfile, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyfile: %w", err)
+	}
+	if len(keyfile) == 0 {
+		return nil, errors.New("keyfile is empty")
+	}
+	return keyfile, nil
+}
@@ -14,5 +14,5 @@ func Execute() error {
 }
 
 func init() {
-	rootCmd.AddCommand(encodeCmd, decodeCmd)
+	rootCmd.AddCommand(encodeCmd, decodeCmd, keygenCmd)
 }
@@ -0,0 +1,38 @@
+package compress
+
+import (
+	"fmt"
+	"io"
+)
+
+// Algorithm identifies the compression algorithm used to produce a payload.
+// Values are stored on paper, so existing values must never be changed.
+type Algorithm uint8
+
+const (
+	AlgorithmXZ Algorithm = 1
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case AlgorithmXZ:
+		return "xz"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint8(a))
+	}
+}
+
+// IsSupported returns true if data compressed with this algorithm can be decompressed.
+func (a Algorithm) IsSupported() bool {
+	return a == AlgorithmXZ
+}
+
+// DecompressWith decompresses the input with the provided algorithm.
+func DecompressWith(algorithm Algorithm, input io.Reader, output io.Writer) error {
+	switch algorithm {
+	case AlgorithmXZ:
+		return Decompress(input, output)
+	default:
+		return fmt.Errorf("unsupported compression algorithm %s", algorithm)
+	}
+}
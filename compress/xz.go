@@ -1,15 +1,42 @@
 package compress
 
 import (
+	"fmt"
 	"io"
 
-	"github.com/JenswBE/encrypted-paper/utils"
+	"github.com/ulikunitz/xz"
 )
 
+// xzDictCap matches the dictionary size of "xz -9"
+const xzDictCap = 64 << 20
+
 func Compress(input io.Reader, output io.Writer) error {
-	return utils.RunCommand("compress input data", input, output, "xz", "--compress", "-9", "--extreme", "--stdout")
+	// Create writer
+	writer, err := xz.WriterConfig{DictCap: xzDictCap, CheckSum: xz.CRC64}.NewWriter(output)
+	if err != nil {
+		return fmt.Errorf("failed to create xz writer: %w", err)
+	}
+
+	// Compress input
+	if _, err = io.Copy(writer, input); err != nil {
+		return fmt.Errorf("failed to compress input data: %w", err)
+	}
+	if err = writer.Close(); err != nil {
+		return fmt.Errorf("failed to finalize compressed data: %w", err)
+	}
+	return nil
 }
 
 func Decompress(input io.Reader, output io.Writer) error {
-	return utils.RunCommand("decompress input data", input, output, "xz", "--decompress", "--stdout")
+	// Create reader
+	reader, err := xz.NewReader(input)
+	if err != nil {
+		return fmt.Errorf("failed to create xz reader: %w", err)
+	}
+
+	// Decompress input
+	if _, err = io.Copy(output, reader); err != nil {
+		return fmt.Errorf("failed to decompress input data: %w", err)
+	}
+	return nil
 }
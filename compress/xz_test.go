@@ -2,6 +2,7 @@ package compress
 
 import (
 	"bytes"
+	"os"
 	"strings"
 	"testing"
 
@@ -27,3 +28,17 @@ func TestValidateCompressRoundtrip(t *testing.T) {
 	// Validate result
 	require.Equal(t, input, decompressedOutput.String())
 }
+
+func TestDecompressOutputOfXZBinary(t *testing.T) {
+	// Data
+	compressedInput, err := os.ReadFile("testdata/xz-binary.txt.xz")
+	require.NoError(t, err)
+
+	// Decompress
+	var decompressedOutput bytes.Buffer
+	err = Decompress(bytes.NewReader(compressedInput), &decompressedOutput)
+	require.NoError(t, err)
+
+	// Validate result
+	require.Equal(t, strings.Repeat("Data compressed by the xz binary\n", 50), decompressedOutput.String())
+}
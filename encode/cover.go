@@ -0,0 +1,204 @@
+package encode
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/signintech/gopdf"
+
+	"github.com/JenswBE/encrypted-paper/encrypt"
+)
+
+// Cover page is printed in a smaller font to fit the full description on a single page
+const (
+	coverFontSize     = 9
+	coverLineHeight   = 11
+	coverHeadingSpace = 6 // Extra space above each heading
+)
+
+// coverSection is a section of the cover page. Paragraphs are wrapped, while code is printed as is in a monospace font.
+type coverSection struct {
+	title      string
+	paragraphs []string
+	code       []string
+}
+
+// coverSections returns the recovery instructions for the cover page. The description should allow
+// someone to write a decoder from scratch, even if this project is no longer available.
+func coverSections(header QRHeader, documentID DocumentID, text bool) []coverSection {
+	// How to recover
+	instructions := coverSection{
+		title: "How to recover",
+		paragraphs: []string{
+			"These sheets contain an encrypted file, stored as QR codes. To recover the file, scan all sheets and decode them with encrypted-paper (https://github.com/JenswBE/encrypted-paper):",
+		},
+		code: []string{"encrypted-paper decode -o recovered_file scan-1.jpg scan-2.jpg ..."},
+	}
+	switch header.KDFAlgo {
+	case encrypt.KDFArgon2id:
+		required := "The password is required to decrypt the file."
+		if header.Keyfile {
+			required = "The password and the keyfile are required to decrypt the file."
+		}
+		instructions.paragraphs = append(instructions.paragraphs, required)
+	case encrypt.KDFShamir:
+		instructions.paragraphs = append(instructions.paragraphs, fmt.Sprintf("The key is split in %d shares. Each share has its own set of sheets. Sheets of %d different shares are required to decrypt the file.", header.Share.Count, header.Share.Threshold))
+	case encrypt.KDFX25519:
+		instructions.paragraphs = append(instructions.paragraphs, "The file is encrypted to one or more public keys. The private key (AGE-SECRET-KEY-1...) of one of the recipients is required to decrypt the file.")
+	case encrypt.KDFAge:
+		instructions.paragraphs = append(instructions.paragraphs, "The file is encrypted as a standard age file (https://age-encryption.org), which can also be decrypted with the age tool once the data is combined. The password or the private key of one of the recipients is required.")
+	}
+	if header.ParityPages > 0 {
+		instructions.paragraphs = append(instructions.paragraphs, fmt.Sprintf("Up to %d QR codes may be missing or unreadable.", header.ParityPages))
+	}
+	if text {
+		instructions.paragraphs = append(instructions.paragraphs, "The data of each QR code is also printed as text on the pages following the QR codes. If a QR code is damaged, scan or type its text instead.")
+	}
+	instructions.paragraphs = append(instructions.paragraphs, "If encrypted-paper isn't available, the sections below describe the format, so a decoder can be written from scratch.")
+
+	// Document
+	document := coverSection{
+		title: "Document",
+		paragraphs: []string{
+			fmt.Sprintf("Document ID: %s. Format version: %d.", documentID, header.Version),
+			fmt.Sprintf("QR codes: %d, of which %d data and %d parity. The number of a QR code is its page_number below.", header.PageCount, header.DataPageCount(), header.ParityPages),
+		},
+	}
+
+	// Algorithms
+	algorithms := coverSection{
+		title:      "Algorithms",
+		paragraphs: []string{fmt.Sprintf("Compression: %s (LZMA2 with CRC-64 check), applied before encryption.", header.Compression)},
+	}
+	switch header.KDFAlgo {
+	case encrypt.KDFArgon2id:
+		kdf := encrypt.DefaultKDFParams
+		if header.KDF != nil {
+			kdf = *header.KDF
+		}
+		secret := "the password (UTF-8)"
+		if header.Keyfile {
+			secret = "the SHA-256 hash of the keyfile, followed by the password (UTF-8)"
+		}
+		algorithms.paragraphs = append(algorithms.paragraphs, fmt.Sprintf("Key derivation: Argon2id (version 0x13) over %s, with the salt from the header, time %d, memory %d KiB, threads %d and key length %d bytes.", secret, kdf.Time, kdf.Memory, kdf.Threads, kdf.KeyLength))
+	case encrypt.KDFShamir:
+		algorithms.paragraphs = append(algorithms.paragraphs, "Key derivation: a random key of 32 bytes, split with Shamir's Secret Sharing. Each byte of the key is the constant term of a random polynomial over GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1. Byte i of a share is that polynomial evaluated at x = index. Combine threshold shares with Lagrange interpolation at x = 0.")
+	case encrypt.KDFX25519:
+		algorithms.paragraphs = append(algorithms.paragraphs, `Key derivation: a random file key of 16 bytes is wrapped for each recipient as an age X25519 stanza (https://age-encryption.org/v1). The key is HKDF-SHA-256 of the file key without salt and with info "encrypted-paper X25519 recipients", 32 bytes long.`)
+	}
+	switch header.AEAD {
+	case encrypt.AEADXChaCha20Poly1305Stream:
+		algorithms.paragraphs = append(algorithms.paragraphs,
+			"Encryption: XChaCha20-Poly1305 in the STREAM construction. The encrypted data starts with a random nonce prefix of 16 bytes, followed by chunks of 64 KiB of plaintext, each with a 16 byte tag. The nonce of a chunk is the prefix, followed by the chunk number (starting at 0) as 7 bytes big endian and a byte which is 1 for the last chunk and 0 otherwise.",
+			"Associated data of each chunk is the header encoded as canonical CBOR (RFC 7049, section 3.9), with page_count set to 0 and without share, data_size and data_hash.",
+		)
+	case encrypt.AEADXChaCha20Poly1305:
+		algorithms.paragraphs = append(algorithms.paragraphs, "Encryption: XChaCha20-Poly1305. The encrypted data is a random nonce of 24 bytes, followed by the ciphertext and its 16 byte tag. Associated data is the header encoded as canonical CBOR (RFC 7049, section 3.9), without share and data_hash.")
+	case encrypt.AEADAge:
+		algorithms.paragraphs = append(algorithms.paragraphs, "Encryption: the encrypted data is a standard age file (https://age-encryption.org/v1). The header is not authenticated.")
+	}
+
+	// Layout
+	layout := coverSection{
+		title: "Data layout",
+		paragraphs: []string{
+			"Each QR code contains a CBOR map (RFC 8949) with text keys. Optional fields are omitted if empty.",
+		},
+		code: []string{
+			`page_number  uint   Number of the QR code, starting at 1`,
+			`data         bytes  Part of the encrypted data`,
+			`document_id  bytes  Same for all QR codes of the document`,
+			`crc32        uint   IEEE CRC-32 of data`,
+			`header       map    On the first QR code and parity QR codes:`,
+			`  version      uint   Format version`,
+			`  compression  uint   1 = xz`,
+			`  kdf_algo     uint   1 = Argon2id, 2 = Shamir, 3 = X25519, 4 = age`,
+			`  aead         uint   1 = XChaCha20-Poly1305, 2 = age, 3 = STREAM`,
+			`  salt         bytes  Salt for Argon2id`,
+			`  page_count   uint   Number of QR codes, including parity`,
+			`  kdf          map    Argon2id: time, memory, threads, key_length`,
+			`  keyfile      bool   Keyfile is mixed into the key derivation`,
+			`  parity_pages uint   Number of parity QR codes at the end`,
+			`  data_size    uint   Size of the encrypted data, if parity`,
+			`  share        map    Key share: index, threshold, count, value`,
+			`  recipients   array  Stanzas: type, args, body`,
+			`  data_hash    bytes  SHA-256 of the encrypted data`,
+		},
+	}
+	combine := "Concatenate data of all QR codes in order of page_number to get the encrypted data. Then decrypt and decompress it."
+	if header.ParityPages > 0 {
+		combine = "Concatenate data of the data QR codes in order of page_number and truncate to data_size to get the encrypted data. Then decrypt and decompress it. " +
+			"Parity QR codes contain Reed-Solomon parity shards over the data of the data QR codes. " +
+			"Their encoding is not specified here: recovering missing data QR codes from parity QR codes requires encrypted-paper. " +
+			"Without it, all data QR codes are needed."
+	}
+	layout.paragraphs = append(layout.paragraphs, combine)
+	return []coverSection{instructions, document, algorithms, layout}
+}
+
+// coverLine is a single line of the cover page
+type coverLine struct {
+	text      string
+	heading   bool
+	monospace bool
+}
+
+// layoutCover wraps the sections of the cover page to the width and flows them over pages of rows lines
+func layoutCover(pdf *gopdf.GoPdf, sections []coverSection, width float64, rows int) ([][]coverLine, error) {
+	// Wrap paragraphs. Wrapping depends on the font.
+	err := pdf.SetFontSize(coverFontSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set font size for cover page: %w", err)
+	}
+	var lines []coverLine
+	for i, section := range sections {
+		if i > 0 {
+			lines = append(lines, coverLine{})
+		}
+		lines = append(lines, coverLine{text: section.title, heading: true})
+		for _, paragraph := range section.paragraphs {
+			wrapped, err := pdf.SplitText(paragraph, width)
+			if err != nil {
+				return nil, fmt.Errorf("failed to wrap paragraph of section %s: %w", section.title, err)
+			}
+			for _, line := range wrapped {
+				lines = append(lines, coverLine{text: strings.TrimSpace(line)})
+			}
+		}
+		for _, line := range section.code {
+			lines = append(lines, coverLine{text: line, monospace: true})
+		}
+	}
+
+	// Split in pages
+	var pages [][]coverLine
+	for start := 0; start < len(lines); start += rows {
+		pages = append(pages, lines[start:min(start+rows, len(lines))])
+	}
+	return pages, nil
+}
+
+// addCoverPage adds a page of the cover with headings in the regular font and code in the monospace font
+func addCoverPage(pdf *gopdf.GoPdf, lines []coverLine) error {
+	pdf.AddPage()
+	y := float64(qrHeaderHeight)
+	for _, line := range lines {
+		font, size := fontName, coverFontSize
+		switch {
+		case line.heading:
+			size = FontSize
+			y += coverHeadingSpace
+		case line.monospace:
+			font = monospaceFont
+		}
+		if err := pdf.SetFont(font, "", size); err != nil {
+			return fmt.Errorf("failed to set font to %s: %w", font, err)
+		}
+		pdf.SetXY(qrMargin, y)
+		if err := pdf.Cell(nil, line.text); err != nil {
+			return fmt.Errorf("failed to add line to cover page: %w", err)
+		}
+		y += coverLineHeight
+	}
+	return pdf.SetFont(fontName, "", FontSize)
+}
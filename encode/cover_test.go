@@ -0,0 +1,64 @@
+package encode
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/signintech/gopdf"
+	"github.com/stretchr/testify/require"
+
+	"github.com/JenswBE/encrypted-paper/encrypt"
+	"github.com/JenswBE/encrypted-paper/qrcode"
+)
+
+func TestCoverSectionsDescribeAllFields(t *testing.T) {
+	_, qrDatas := buildTestQRData(t, 2*MaxQRCodeCapacity, 1)
+	header := *qrDatas[0].Header
+	header.AEAD, header.Keyfile = encrypt.AEADXChaCha20Poly1305Stream, true // Longest description
+	sections := coverSections(header, qrDatas[0].DocumentID, true)
+	var text strings.Builder
+	for _, section := range sections {
+		text.WriteString(strings.Join(section.paragraphs, "\n") + "\n" + strings.Join(section.code, "\n") + "\n")
+	}
+
+	// Layout must be updated when fields are added
+	for _, value := range []any{QRData{}, QRHeader{}} {
+		valueType := reflect.TypeOf(value)
+		for i := range valueType.NumField() {
+			name, _, _ := strings.Cut(valueType.Field(i).Tag.Get("json"), ",")
+			require.Contains(t, text.String(), name+" ", "field %s is not described", name)
+		}
+	}
+
+	// Parameters of this document
+	require.Contains(t, text.String(), "Document ID: "+qrDatas[0].DocumentID.String())
+	require.Contains(t, text.String(), "Up to 1 QR codes may be missing or unreadable")
+	require.Contains(t, text.String(), "memory 65536 KiB")
+
+	// Cover fits on a single page
+	pdf := gopdf.GoPdf{}
+	pdf.Start(gopdf.Config{PageSize: *gopdf.PageSizeA4})
+	require.NoError(t, setFont(&pdf))
+	pages, err := layoutCover(&pdf, sections, gopdf.PageSizeA4.W-2*qrMargin, 60)
+	require.NoError(t, err)
+	require.Len(t, pages, 1)
+}
+
+func TestGeneratePDFWithCover(t *testing.T) {
+	data, qrDatas := buildTestQRData(t, 2*MaxQRCodeCapacity, 0)
+	header := *qrDatas[0].Header
+	qrCodes, err := GenerateQRCodes(header, qrcode.ECCLevelL, qrDatas[0].DocumentID, data)
+	require.NoError(t, err)
+
+	// Cover doesn't prevent scanning the PDF
+	outputPath := filepath.Join(t.TempDir(), "sheets.pdf")
+	require.NoError(t, GeneratePDF(outputPath, "Test", qrDatas[0].DocumentID, DefaultLayout, QRCodeSlice(qrCodes), true, &header, false))
+	output, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	payload, err := ScanAndCombineQRCodes(map[string][]byte{"sheets.pdf": output}, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, data, payload.Data)
+}
@@ -0,0 +1,41 @@
+package encode
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/JenswBE/encrypted-paper/assets"
+)
+
+// decoderIntro is printed above the source of the reference decoder
+var decoderIntro = []string{
+	"Reference decoder",
+	"",
+	"Minimal decoder for sheets encrypted with a password, written in Go using only the standard library.",
+	"If encrypted-paper is no longer available, type the source below in decoder.go and run:",
+	"",
+	"  go run decoder.go -o recovered_file qr-1.txt qr-2.txt ...",
+	"",
+	"Each input file contains the payload of a QR code, as returned by a QR code reader, or its printed text.",
+	"Each line of the source is prefixed by its line number and a checksum. The checksum is the first 4",
+	"characters of the Base32 (RFC 4648) encoded CRC-32 (IEEE) over the line number (4 bytes, big endian)",
+	"and the line without leading and trailing whitespace. Indentation is printed as 2 spaces per level.",
+	"Once the source compiles, compare the checksums printed by: go run decoder.go -checksums decoder.go",
+}
+
+// DecoderListing returns the source of the reference decoder as printed, one line per source line.
+// Each line is prefixed by its line number and a checksum which ignores indentation.
+func DecoderListing() []string {
+	lines := strings.Split(strings.TrimSuffix(assets.ReferenceDecoder, "\n"), "\n")
+	listing := make([]string, 0, len(lines))
+	for i, line := range lines {
+		checksum := textChecksum(i+1, []byte(strings.TrimSpace(line)))
+		listing = append(listing, fmt.Sprintf("%04d %s | %s", i+1, checksum, strings.ReplaceAll(line, "\t", "  ")))
+	}
+	return listing
+}
+
+// layoutDecoder flows the introduction and the source of the reference decoder over pages of rows lines
+func layoutDecoder(rows int) []textPage {
+	return layoutText([][]string{decoderIntro, DecoderListing()}, 1, rows)
+}
@@ -0,0 +1,39 @@
+package encode
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/JenswBE/encrypted-paper/qrcode"
+)
+
+func TestLayoutDecoderContainsFullSource(t *testing.T) {
+	pages := layoutDecoder(80)
+	var lines []string
+	for _, page := range pages {
+		require.Len(t, page, 1)
+		require.LessOrEqual(t, len(page[0]), 80)
+		lines = append(lines, page[0]...)
+	}
+	require.Equal(t, decoderIntro[0], lines[0])
+	require.Equal(t, DecoderListing(), slices.DeleteFunc(lines[len(decoderIntro):], func(line string) bool { return line == "" }))
+}
+
+func TestGeneratePDFWithDecoder(t *testing.T) {
+	data, qrDatas := buildTestQRData(t, 2*MaxQRCodeCapacity, 0)
+	qrCodes, err := GenerateQRCodes(*qrDatas[0].Header, qrcode.ECCLevelL, qrDatas[0].DocumentID, data)
+	require.NoError(t, err)
+
+	// Reference decoder doesn't prevent scanning the PDF
+	outputPath := filepath.Join(t.TempDir(), "sheets.pdf")
+	require.NoError(t, GeneratePDF(outputPath, "Test", qrDatas[0].DocumentID, DefaultLayout, QRCodeSlice(qrCodes), false, nil, true))
+	output, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	payload, err := ScanAndCombineQRCodes(map[string][]byte{"sheets.pdf": output}, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, data, payload.Data)
+}
@@ -0,0 +1,119 @@
+package encode
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// DocumentIDSize is the size in bytes of a document ID
+const DocumentIDSize = 8
+
+// DocumentID is a random ID which is shared by all pages of a single encode.
+// It's used to detect scans of different documents, e.g. of an older backup.
+type DocumentID []byte
+
+// GenerateDocumentID returns a random document ID
+func GenerateDocumentID() (DocumentID, error) {
+	id := make(DocumentID, DocumentIDSize)
+	_, err := cryptorand.Read(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate document ID: %w", err)
+	}
+	return id, nil
+}
+
+// ParseDocumentID parses a document ID as printed on the sheets
+func ParseDocumentID(input string) (DocumentID, error) {
+	id, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(input), "-", ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode document ID as hex: %w", err)
+	}
+	if len(id) != DocumentIDSize {
+		return nil, fmt.Errorf("document ID is %d bytes, but must be %d bytes", len(id), DocumentIDSize)
+	}
+	return id, nil
+}
+
+// String returns the document ID as uppercase hex in groups of 4 characters, e.g. 1A2B-3C4D-5E6F-7A8B
+func (id DocumentID) String() string {
+	if len(id) == 0 {
+		return "without document ID" // Legacy sheets
+	}
+	encoded := strings.ToUpper(hex.EncodeToString(id))
+	groups := make([]string, 0, (len(encoded)+3)/4)
+	for chunk := range slices.Chunk([]byte(encoded), 4) {
+		groups = append(groups, string(chunk))
+	}
+	return strings.Join(groups, "-")
+}
+
+// scannedQRData is the content of a scanned QR code, together with the file it was scanned from
+type scannedQRData struct {
+	fileName string
+	qrData   QRData
+}
+
+// documentScans are the scans which belong to a single document
+type documentScans struct {
+	id        DocumentID
+	fileNames []string
+	scans     []scannedQRData
+}
+
+// selectDocument groups the scans by document ID and returns the scans of a single document.
+// If documentID is nil, all scans must belong to the same document. Otherwise, scans of other documents are ignored.
+func selectDocument(scans []scannedQRData, documentID DocumentID) ([]scannedQRData, DocumentID, error) {
+	// Group scans by document ID
+	var documents []*documentScans
+	for _, scan := range scans {
+		index := slices.IndexFunc(documents, func(d *documentScans) bool { return slices.Equal(d.id, scan.qrData.DocumentID) })
+		if index == -1 {
+			index = len(documents)
+			documents = append(documents, &documentScans{id: scan.qrData.DocumentID})
+		}
+		documents[index].fileNames = append(documents[index].fileNames, scan.fileName)
+		documents[index].scans = append(documents[index].scans, scan)
+	}
+	for _, document := range documents {
+		slices.Sort(document.fileNames)
+		document.fileNames = slices.Compact(document.fileNames) // File might contain multiple QR codes
+	}
+	slices.SortFunc(documents, func(a, b *documentScans) int { return strings.Compare(a.fileNames[0], b.fileNames[0]) })
+
+	// Select document
+	switch {
+	case len(documents) == 0:
+		return nil, documentID, nil
+	case documentID == nil && len(documents) == 1:
+		return documents[0].scans, documents[0].id, nil
+	case documentID == nil:
+		descriptions := make([]string, len(documents))
+		for i, document := range documents {
+			descriptions[i] = fmt.Sprintf("document %s in files %s", document.id, quoteFileNames(document.fileNames))
+		}
+		return nil, nil, fmt.Errorf("scans belong to %d different documents (%s): please remove the scans of other documents or select a document with flag --document-id", len(documents), strings.Join(descriptions, "; "))
+	}
+	index := slices.IndexFunc(documents, func(d *documentScans) bool { return slices.Equal(d.id, documentID) })
+	if index == -1 {
+		return nil, nil, fmt.Errorf("none of the scans belong to document %s", documentID)
+	}
+	for i, document := range documents {
+		if i != index {
+			slog.Warn("Ignoring scans of another document", "document_id", document.id.String(), "files", document.fileNames)
+		}
+	}
+	return documents[index].scans, documentID, nil
+}
+
+// quoteFileNames returns the file names as quoted, comma separated list
+func quoteFileNames(fileNames []string) string {
+	quoted := make([]string, len(fileNames))
+	for i, fileName := range fileNames {
+		quoted[i] = fmt.Sprintf(`"%s"`, fileName)
+	}
+	return strings.Join(quoted, ", ")
+}
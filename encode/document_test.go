@@ -0,0 +1,53 @@
+package encode
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentIDStringRoundtrip(t *testing.T) {
+	id, err := GenerateDocumentID()
+	require.NoError(t, err)
+	require.Len(t, id, DocumentIDSize)
+	require.Regexp(t, `^[0-9A-F]{4}(-[0-9A-F]{4}){3}$`, id.String())
+
+	parsed, err := ParseDocumentID(id.String())
+	require.NoError(t, err)
+	require.Equal(t, id, parsed)
+
+	_, err = ParseDocumentID("1A2B-3C4D")
+	require.ErrorContains(t, err, "must be 8 bytes")
+}
+
+func TestSelectDocument(t *testing.T) {
+	current := DocumentID("current1")
+	old := DocumentID("oldbckp1")
+	scans := []scannedQRData{
+		{fileName: "page-2.png", qrData: QRData{PageNumber: 2, DocumentID: current}},
+		{fileName: "page-1.png", qrData: QRData{PageNumber: 1, DocumentID: current}},
+		{fileName: "old-page-2.png", qrData: QRData{PageNumber: 2, DocumentID: old}},
+	}
+
+	// Single document
+	qrDatas, documentID, err := selectDocument(scans[:2], nil)
+	require.NoError(t, err)
+	require.Len(t, qrDatas, 2)
+	require.Equal(t, current, documentID)
+
+	// Mixed documents without selection
+	_, _, err = selectDocument(scans, nil)
+	require.ErrorContains(t, err, "scans belong to 2 different documents")
+	require.ErrorContains(t, err, `document `+old.String()+` in files "old-page-2.png"`)
+	require.ErrorContains(t, err, `document `+current.String()+` in files "page-1.png", "page-2.png"`)
+
+	// Mixed documents with selection
+	qrDatas, documentID, err = selectDocument(scans, current)
+	require.NoError(t, err)
+	require.Len(t, qrDatas, 2)
+	require.Equal(t, current, documentID)
+
+	// Selected document not found
+	_, _, err = selectDocument(scans[:2], old)
+	require.ErrorContains(t, err, "none of the scans belong to document")
+}
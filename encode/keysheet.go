@@ -0,0 +1,90 @@
+package encode
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/signintech/gopdf"
+)
+
+// keySheetWordFontSize is the font size of the passphrase words on the key sheet
+const keySheetWordFontSize = 20
+
+// GenerateKeySheetPDF generates a single page PDF with the passphrase, to be stored apart from the data sheets
+func GenerateKeySheetPDF(outputPath string, title string, passphrase string) error {
+	// Init PDF
+	pdf := gopdf.GoPdf{}
+	pageSize := *gopdf.PageSizeA4
+	pdf.Start(gopdf.Config{PageSize: pageSize})
+	pdf.AddPage()
+
+	// Set font
+	err := setFont(&pdf)
+	if err != nil {
+		return err
+	}
+
+	// Add title and instructions
+	margin := float64(50)
+	lines := []string{
+		"Key sheet for: " + title,
+		"",
+		"This passphrase decrypts the data sheets with the same title.",
+		"Store this sheet apart from the data sheets.",
+	}
+	pdf.SetY(margin)
+	for _, line := range lines {
+		pdf.SetX(margin)
+		err = pdf.Cell(nil, line)
+		if err != nil {
+			return fmt.Errorf("failed to add instructions to key sheet: %w", err)
+		}
+		pdf.Br(FontSize + 6)
+	}
+
+	// Add numbered words
+	err = pdf.SetFontSize(keySheetWordFontSize)
+	if err != nil {
+		return fmt.Errorf("failed to set font size for passphrase: %w", err)
+	}
+	pdf.Br(keySheetWordFontSize)
+	for i, word := range strings.Fields(passphrase) {
+		pdf.SetX(margin)
+		err = pdf.Cell(nil, fmt.Sprintf("%d. %s", i+1, word))
+		if err != nil {
+			return fmt.Errorf("failed to add word %d to key sheet: %w", i+1, err)
+		}
+		pdf.Br(keySheetWordFontSize + 10)
+	}
+
+	// Add passphrase as typed
+	err = pdf.SetFontSize(FontSize)
+	if err != nil {
+		return fmt.Errorf("failed to set font size for passphrase: %w", err)
+	}
+	pdf.Br(FontSize)
+	pdf.SetX(margin)
+	err = pdf.Cell(nil, "Words are separated by a single space: "+passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to add passphrase to key sheet: %w", err)
+	}
+
+	// Add footer
+	err = pdf.SetFontSize(8)
+	if err != nil {
+		return fmt.Errorf("failed to set font size in footer: %w", err)
+	}
+	pdf.SetX(20)
+	pdf.SetY(pageSize.H - 20)
+	err = pdf.Cell(nil, generatedWith())
+	if err != nil {
+		return fmt.Errorf("failed to set project URL in footer: %w", err)
+	}
+
+	// Write PDF file
+	err = pdf.WritePdf(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to write PDF file to path %s: %w", outputPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package encode
+
+import (
+	"cmp"
+	"image"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/JenswBE/encrypted-paper/assets"
+	"github.com/JenswBE/encrypted-paper/ocr"
+)
+
+// textAlphabet contains all characters of the data lines of the text fallback, except space
+const textAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ:-"
+
+// Limits on the alternative characters which are tried to correct a line which fails its checksum
+const (
+	ocrAlternatives      = 2 // Next best candidates tried for each character
+	ocrDoubleCorrections = 8 // Least certain characters in which two errors are tried
+)
+
+// textRecognizer recognizes the text fallback, which is printed in the monospace font from the assets
+var textRecognizer = sync.OnceValues(func() (*ocr.Recognizer, error) {
+	return ocr.NewRecognizer(assets.GoMonoTTF, textAlphabet)
+})
+
+// recognizeText returns the data lines of the text fallback in a scanned image, in the format accepted by parseText.
+// Lines with misread characters are corrected using their checksum where possible.
+// Returns an empty string if the image doesn't contain any data lines.
+func recognizeText(img image.Image) (string, error) {
+	recognizer, err := textRecognizer()
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, line := range recognizer.Recognize(img) {
+		text := correctOCRLine(line)
+		if text != line.String() {
+			slog.Info("Corrected misread characters using the line checksum", "read", line.String(), "corrected", text)
+		}
+		if textLineRegexp.MatchString(text) {
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// correctOCRLine returns the line with the most likely characters for which the checksum is valid.
+// Single errors are corrected anywhere in the line, double errors only in the least certain characters.
+// If the line can't be corrected, the most likely reading is returned, so parseText reports it.
+func correctOCRLine(line ocr.Line) string {
+	chars := []rune(line.String())
+	if validTextLine(string(chars)) || !textLineRegexp.MatchString(string(chars)) {
+		return string(chars) // Also skip correcting lines which aren't data lines, e.g. headings
+	}
+	alternatives := func(position int) []ocr.Candidate {
+		return line[position][1:min(len(line[position]), 1+ocrAlternatives)]
+	}
+
+	// Try single errors
+	for position := range line {
+		for _, candidate := range alternatives(position) {
+			corrected := slices.Clone(chars)
+			corrected[position] = candidate.Char
+			if validTextLine(string(corrected)) {
+				return string(corrected)
+			}
+		}
+	}
+
+	// Try double errors in the least certain characters
+	positions := make([]int, 0, len(line))
+	for position, glyph := range line {
+		if len(glyph) > 1 {
+			positions = append(positions, position)
+		}
+	}
+	margin := func(position int) float64 { return line[position][1].Distance - line[position][0].Distance }
+	slices.SortFunc(positions, func(a, b int) int { return cmp.Compare(margin(a), margin(b)) })
+	positions = positions[:min(len(positions), ocrDoubleCorrections)]
+	for i, first := range positions {
+		for _, second := range positions[i+1:] {
+			for _, firstCandidate := range alternatives(first) {
+				for _, secondCandidate := range alternatives(second) {
+					corrected := slices.Clone(chars)
+					corrected[first], corrected[second] = firstCandidate.Char, secondCandidate.Char
+					if validTextLine(string(corrected)) {
+						return string(corrected)
+					}
+				}
+			}
+		}
+	}
+	return string(chars)
+}
+
+// validTextLine returns true if the line is a data line with a valid checksum
+func validTextLine(line string) bool {
+	match := textLineRegexp.FindStringSubmatch(line)
+	if match == nil {
+		return false
+	}
+	lineNumber, err := strconv.Atoi(match[1])
+	if err != nil {
+		return false
+	}
+	data, err := textEncoding.DecodeString(normalizeText(match[2]))
+	return err == nil && textChecksum(lineNumber, data) == normalizeText(match[3])
+}
@@ -0,0 +1,102 @@
+package encode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/JenswBE/encrypted-paper/assets"
+	"github.com/JenswBE/encrypted-paper/ocr"
+	"github.com/JenswBE/encrypted-paper/qrcode"
+)
+
+func TestCorrectOCRLine(t *testing.T) {
+	line := TextLines(bytes.Repeat([]byte{0x5a}, textLineSize))[0]
+	toOCRLine := func(line string, misread map[int]rune) ocr.Line {
+		output := make(ocr.Line, 0, len(line))
+		for i, char := range line {
+			glyph := ocr.Glyph{{Char: char}, {Char: 'X', Distance: 5}, {Char: 'Y', Distance: 6}}
+			if wrong, ok := misread[i]; ok {
+				glyph = ocr.Glyph{{Char: wrong, Distance: 2}, {Char: char, Distance: 3}, {Char: 'Y', Distance: 6}}
+			}
+			output = append(output, glyph)
+		}
+		return output
+	}
+
+	// Valid line is unchanged
+	require.Equal(t, line, correctOCRLine(toOCRLine(line, nil)))
+
+	// Single and double errors are corrected
+	require.Equal(t, line, correctOCRLine(toOCRLine(line, map[int]rune{7: 'Q'})))
+	require.Equal(t, line, correctOCRLine(toOCRLine(line, map[int]rune{2: '7', 12: 'Q'})))
+
+	// Line which can't be corrected is returned as read, so it's reported by parseText
+	uncorrectable := "001: Q" + line[6:]
+	require.Equal(t, uncorrectable, correctOCRLine(toOCRLine(uncorrectable, nil)))
+}
+
+func TestScanAndCombineQRCodesWithOCR(t *testing.T) {
+	data, qrDatas := buildTestQRData(t, 3*MaxQRCodeCapacity, 0)
+	qrCodes, err := GenerateQRCodes(*qrDatas[0].Header, qrcode.ECCLevelL, qrDatas[0].DocumentID, data)
+	require.NoError(t, err)
+	require.Len(t, qrCodes, 4)
+
+	// Render text of a damaged QR code as printed. Title and heading are not recognized, but ignored.
+	lines := TextLines(qrCodes[2].Payload)
+	pages := layoutText([][]string{append([]string{TextHeading(3, len(qrCodes), len(lines))}, lines...)}, textColumns, 80)
+	require.Len(t, pages, 1)
+	img := renderTextPage(t, pages[0])
+
+	// Smudge a few characters
+	for _, smudge := range []image.Rectangle{image.Rect(300, 200, 318, 212), image.Rect(1400, 1000, 1410, 1030)} {
+		draw.Draw(img, smudge.Add(img.Bounds().Min), image.Black, image.Point{}, draw.Src)
+	}
+
+	// Decode with the text scan instead of the QR code
+	var scan bytes.Buffer
+	require.NoError(t, png.Encode(&scan, img))
+	scans := map[string][]byte{"text.png": scan.Bytes()}
+	for i, qrCode := range qrCodes {
+		if i != 2 {
+			scans[fmt.Sprintf("scan-%d.png", i+1)] = qrCode.PNG
+		}
+	}
+	payload, err := ScanAndCombineQRCodes(scans, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, data, payload.Data)
+}
+
+// renderTextPage renders the columns of text like a page of the text fallback, printed at 8 points and scanned at 300 DPI
+func renderTextPage(t *testing.T, page textPage) *image.Gray {
+	t.Helper()
+	scale := 300.0 / 72
+	parsedFont, err := opentype.Parse(assets.GoMonoTTF)
+	require.NoError(t, err)
+	face, err := opentype.NewFace(parsedFont, &opentype.FaceOptions{Size: textFontSize * scale, DPI: 72, Hinting: font.HintingNone})
+	require.NoError(t, err)
+	defer face.Close()
+
+	img := image.NewGray(image.Rect(0, 0, int(595*scale), int(842*scale)))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	drawer := &font.Drawer{Dst: img, Src: image.Black, Face: face}
+	drawer.Dot = fixed.P(int(qrMargin*scale), int(30*scale))
+	drawer.DrawString(strings.Repeat("Title ", 5))
+	columnWidth := (595.0 - 2*qrMargin - (textColumns-1)*textColumnGap) / textColumns
+	for i, column := range page {
+		for j, line := range column {
+			drawer.Dot = fixed.P(int((qrMargin+float64(i)*(columnWidth+textColumnGap))*scale), int((qrHeaderHeight+float64(j+1)*textLineHeight)*scale))
+			drawer.DrawString(line)
+		}
+	}
+	return img
+}
@@ -0,0 +1,87 @@
+package encode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// calcParityShards returns the Reed-Solomon parity shards for the data shards, which are read on demand.
+// Data shards are zero padded to the size of the largest shard, rounded up to parityShardAlignment.
+// Parity is calculated over a window of parityShardAlignment bytes of all shards at a time, so only the
+// parity shards are kept in memory. Both Reed-Solomon codecs calculate each aligned window independently.
+func calcParityShards(dataShards []*io.SectionReader, parityShards int) ([][]byte, error) {
+	enc, err := reedsolomon.New(len(dataShards), parityShards)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Reed-Solomon encoder: %w", err)
+	}
+
+	// Calculate shard size
+	var shardSize int64
+	for _, shard := range dataShards {
+		shardSize = max(shardSize, shard.Size())
+	}
+	shardSize = (shardSize + parityShardAlignment - 1) / parityShardAlignment * parityShardAlignment
+	parity := make([][]byte, parityShards)
+	for i := range parity {
+		parity[i] = make([]byte, shardSize)
+	}
+
+	// Calculate parity per window
+	window := make([][]byte, len(dataShards)+parityShards)
+	for i := range window {
+		window[i] = make([]byte, parityShardAlignment)
+	}
+	for offset := int64(0); offset < shardSize; offset += parityShardAlignment {
+		for i, shard := range dataShards {
+			clear(window[i])
+			if _, err = shard.ReadAt(window[i], offset); err != nil && !errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("failed to read data shard %d: %w", i+1, err)
+			}
+		}
+		if err = enc.Encode(window); err != nil {
+			return nil, fmt.Errorf("failed to calculate parity: %w", err)
+		}
+		for i := range parity {
+			copy(parity[i][offset:], window[len(dataShards)+i])
+		}
+	}
+	return parity, nil
+}
+
+// reconstructDataShards returns the data shards, reconstructing the missing (nil) data shards.
+// Reconstructed shards are zero padded to the size of the parity shards.
+func reconstructDataShards(shards [][]byte, dataShards int) ([][]byte, error) {
+	enc, err := reedsolomon.New(dataShards, len(shards)-dataShards)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Reed-Solomon encoder: %w", err)
+	}
+
+	// Pad available shards to the size of the parity shards
+	shardSize := 0
+	for _, shard := range shards {
+		shardSize = max(shardSize, len(shard))
+	}
+	padded := make([][]byte, len(shards))
+	for i, shard := range shards {
+		if shard != nil {
+			padded[i] = make([]byte, shardSize)
+			copy(padded[i], shard)
+		}
+	}
+
+	// Reconstruct missing shards
+	if err = enc.ReconstructData(padded); err != nil {
+		return nil, fmt.Errorf("failed to reconstruct data: %w", err)
+	}
+
+	// Only keep padding for reconstructed shards, as original size is unknown
+	for i := range dataShards {
+		if shards[i] != nil {
+			padded[i] = shards[i]
+		}
+	}
+	return padded[:dataShards], nil
+}
@@ -1,31 +1,171 @@
 package encode
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/signintech/gopdf"
 
 	"github.com/JenswBE/encrypted-paper/assets"
+	"github.com/JenswBE/encrypted-paper/qrcode"
 )
 
 const FontSize = 12
 
-func GeneratePDF(outputPath string, title string, qrCodes [][]byte) (err error) {
+// Dimensions in points of the area on a page in which the QR codes are placed
+const (
+	qrMargin        = 25 // Left and right margin
+	qrHeaderHeight  = 50 // Reserved for the title
+	qrFooterHeight  = 50 // Reserved for the document ID, URL and page number
+	qrGap           = 10 // Between QR codes in a grid
+	qrCaptionHeight = 12 // Below each QR code in a grid
+)
+
+// MinQRModuleSize is the minimum printed size in millimeters of a QR code module, to be reliably scanned.
+// Data is split in QR codes of the largest version, so the grid determines the module size.
+const MinQRModuleSize = 0.5
+
+// Text fallback is printed in columns in a monospace font
+const (
+	textFontSize   = 8
+	textLineHeight = 9.5
+	textColumns    = 2
+	textColumnGap  = 20
+)
+
+// Names of the embedded fonts
+const (
+	fontName      = "dejavu"
+	monospaceFont = "gomono"
+)
+
+// Layout is the grid of QR codes on each page of the PDF
+type Layout struct {
+	Columns int
+	Rows    int
+}
+
+// DefaultLayout places a single QR code on each page
+var DefaultLayout = Layout{Columns: 1, Rows: 1}
+
+// ParseLayout parses a layout formatted as COLUMNSxROWS, e.g. 2x2
+func ParseLayout(input string) (Layout, error) {
+	var layout Layout
+	_, err := fmt.Sscanf(strings.ToLower(input), "%dx%d", &layout.Columns, &layout.Rows)
+	if err != nil || fmt.Sprintf("%dx%d", layout.Columns, layout.Rows) != strings.ToLower(input) {
+		return Layout{}, fmt.Errorf(`layout "%s" must be formatted as COLUMNSxROWS, e.g. 2x2`, input)
+	}
+	if layout.Columns < 1 || layout.Rows < 1 {
+		return Layout{}, fmt.Errorf("layout %s must have at least 1 column and 1 row", layout)
+	}
+	return layout, nil
+}
+
+// LayoutForCount returns the smallest, most square grid which fits the number of QR codes
+func LayoutForCount(qrCodesPerPage int) (Layout, error) {
+	if qrCodesPerPage < 1 {
+		return Layout{}, errors.New("number of QR codes per page must be at least 1")
+	}
+	columns := int(math.Ceil(math.Sqrt(float64(qrCodesPerPage))))
+	return Layout{Columns: columns, Rows: (qrCodesPerPage + columns - 1) / columns}, nil
+}
+
+// PerPage returns the number of QR codes on each page
+func (l Layout) PerPage() int {
+	return l.Columns * l.Rows
+}
+
+// PageCount returns the number of pages needed for the provided number of QR codes
+func (l Layout) PageCount(qrCodes int) int {
+	return (qrCodes + l.PerPage() - 1) / l.PerPage()
+}
+
+// Validate checks if the QR codes of the largest version are printed large enough to be scanned
+func (l Layout) Validate() error {
+	imageSize, _, _ := qrGrid(*gopdf.PageSizeA4, l)
+	modules := qrcode.MaxVersion*4 + 17 + 2*qrcode.QuietZone
+	moduleSize := imageSize / float64(modules) * 25.4 / 72 // Points to millimeters
+	if moduleSize < MinQRModuleSize {
+		return fmt.Errorf("layout %s prints QR code modules of %.2f mm, which is below the minimum of %.1f mm to be reliably scanned", l, moduleSize, MinQRModuleSize)
+	}
+	return nil
+}
+
+func (l Layout) String() string {
+	return fmt.Sprintf("%dx%d", l.Columns, l.Rows)
+}
+
+// QRCodeSource provides the QR codes of a set by zero based index, so they don't have to be kept in memory at once
+type QRCodeSource interface {
+	Len() int
+	Payload(i int) ([]byte, error)
+	QRCode(i int) (QRCode, error)
+}
+
+// QRCodeSlice is a QRCodeSource for QR codes which are already generated
+type QRCodeSlice []QRCode
+
+func (s QRCodeSlice) Len() int                      { return len(s) }
+func (s QRCodeSlice) Payload(i int) ([]byte, error) { return s[i].Payload, nil }
+func (s QRCodeSlice) QRCode(i int) (QRCode, error)  { return s[i], nil }
+
+// GeneratePDF writes a PDF with the QR codes laid out in a grid on each page. Document ID is printed in the footer of every page.
+// QR codes are requested one at a time from the source, in order.
+// If text is set, the payload of the QR codes on a page is printed as text on the following pages.
+// Printed double-sided, a short text fits on the back of the sheet.
+// If cover is set, a cover page with recovery instructions for the header is prepended.
+// If decoder is set, the source of the reference decoder is appended.
+func GeneratePDF(outputPath string, title string, documentID DocumentID, layout Layout, qrCodes QRCodeSource, text bool, cover *QRHeader, decoder bool) (err error) {
+	// Validate layout
+	if err = layout.Validate(); err != nil {
+		return err
+	}
+
 	// Init PDF
 	pdf := gopdf.GoPdf{}
 	pageSize := *gopdf.PageSizeA4
 	pdf.Start(gopdf.Config{PageSize: pageSize})
 
 	// Set font
-	fontName := "dejavu"
-	err = pdf.AddTTFFontData(fontName, assets.DejaVuSansTTF)
+	err = setFont(&pdf)
 	if err != nil {
-		return fmt.Errorf("failed to add TTF font %s: %w", fontName, err)
+		return err
 	}
-	err = pdf.SetFont(fontName, "", FontSize)
-	if err != nil {
-		return fmt.Errorf("failed to set font to %s: %w", fontName, err)
+	if text || cover != nil || decoder {
+		err = pdf.AddTTFFontData(monospaceFont, assets.GoMonoTTF)
+		if err != nil {
+			return fmt.Errorf("failed to add TTF font %s: %w", monospaceFont, err)
+		}
+	}
+
+	// Layout cover, text fallback and reference decoder, which are needed upfront for the page count
+	var coverPages [][]coverLine
+	if cover != nil {
+		sections := coverSections(*cover, documentID, text)
+		coverRows := int((pageSize.H - qrHeaderHeight - qrFooterHeight - float64(len(sections))*coverHeadingSpace) / coverLineHeight)
+		coverPages, err = layoutCover(&pdf, sections, pageSize.W-2*qrMargin, coverRows)
+		if err != nil {
+			return err
+		}
+	}
+	// Text pages following each page of QR codes are only counted upfront, as the text is laid out again when added
+	textRows := int((pageSize.H - qrHeaderHeight - qrFooterHeight) / textLineHeight)
+	qrPageCount := layout.PageCount(qrCodes.Len())
+	totalPageCount := len(coverPages) + qrPageCount
+	for i := 0; text && i < qrPageCount; i++ {
+		textPages, err := layoutQRCodeText(qrCodes, layout, i, textRows)
+		if err != nil {
+			return err
+		}
+		totalPageCount += len(textPages)
+	}
+	var decoderPages []textPage
+	if decoder {
+		decoderPages = layoutDecoder(textRows)
+		totalPageCount += len(decoderPages)
 	}
 
 	// Set header
@@ -48,15 +188,22 @@ func GeneratePDF(outputPath string, title string, qrCodes [][]byte) (err error)
 			return
 		}
 		pdf.SetX(20)
+		pdf.SetY(pageSize.H - 32)
+		err = pdf.Cell(nil, "Document ID: "+documentID.String())
+		if err != nil {
+			err = fmt.Errorf("failed to set document ID in footer: %w", err)
+			return
+		}
+		pdf.SetX(20)
 		pdf.SetY(pageSize.H - 20)
-		err = pdf.Cell(nil, "Generated with https://github.com/JenswBE/encrypted-paper on "+time.Now().Format("02 Jan 2006 15:04 -0700"))
+		err = pdf.Cell(nil, generatedWith())
 		if err != nil {
 			err = fmt.Errorf("failed to set project URL in footer: %w", err)
 			return
 		}
 		pdf.SetX(pageSize.W - 75)
 		pdf.SetY(pageSize.H - 20)
-		err = pdf.Cell(nil, fmt.Sprintf("Page %d of %d", pdf.GetNumberOfPages(), len(qrCodes)))
+		err = pdf.Cell(nil, fmt.Sprintf("Page %d of %d", pdf.GetNumberOfPages(), totalPageCount))
 		if err != nil {
 			err = fmt.Errorf("failed to set page number in footer: %w", err)
 			return
@@ -66,23 +213,74 @@ func GeneratePDF(outputPath string, title string, qrCodes [][]byte) (err error)
 		return err
 	}
 
+	// Add cover
+	for _, page := range coverPages {
+		if err = addCoverPage(&pdf, page); err != nil {
+			return fmt.Errorf("failed to add cover page: %w", err)
+		}
+	}
+
+	// Calculate grid. Grid is centered on the page.
+	imageSize, gap, captionHeight := qrGrid(pageSize, layout)
+	columns, rows := float64(layout.Columns), float64(layout.Rows)
+	gridXPos := pageSize.W/2 - (columns*imageSize+(columns-1)*gap)/2
+	gridYPos := pageSize.H/2 - (rows*(imageSize+captionHeight)+(rows-1)*gap)/2
+
 	// Generate pages
-	for i, qrCode := range qrCodes {
-		pdf.AddPage()
-		pdf.SetY(50)
+	for i := range qrCodes.Len() {
+		qrCode, err := qrCodes.QRCode(i)
+		if err != nil {
+			return fmt.Errorf("failed to generate QR code %d: %w", i+1, err)
+		}
+		cell := i % layout.PerPage()
+		if cell == 0 {
+			pdf.AddPage()
+			pdf.SetY(50)
+		}
 
-		holder, err := gopdf.ImageHolderByBytes(qrCode)
+		holder, err := gopdf.ImageHolderByBytes(qrCode.PNG)
 		if err != nil {
 			return fmt.Errorf("failed to convert QR code image %d to holder: %w", i+1, err)
 		}
 
-		imageMargin := float64(25)
-		imageSize := pageSize.W - imageMargin*2
-		imageYPos := pageSize.H/2 - imageSize/2
-		err = pdf.ImageByHolder(holder, imageMargin, imageYPos, &gopdf.Rect{W: imageSize, H: imageSize})
+		imageXPos := gridXPos + float64(cell%layout.Columns)*(imageSize+gap)
+		imageYPos := gridYPos + float64(cell/layout.Columns)*(imageSize+captionHeight+gap)
+		err = pdf.ImageByHolder(holder, imageXPos, imageYPos, &gopdf.Rect{W: imageSize, H: imageSize})
 		if err != nil {
 			return fmt.Errorf("failed to add QR code %d image holder to PDF file: %w", i+1, err)
 		}
+
+		// Add caption
+		if captionHeight > 0 {
+			if err = pdf.SetFontSize(8); err != nil {
+				return fmt.Errorf("failed to set font size for caption of QR code %d: %w", i+1, err)
+			}
+			pdf.SetXY(imageXPos, imageYPos+imageSize)
+			err = pdf.CellWithOption(&gopdf.Rect{W: imageSize, H: captionHeight}, fmt.Sprintf("QR code %d of %d", i+1, qrCodes.Len()), gopdf.CellOption{Align: gopdf.Center})
+			if err != nil {
+				return fmt.Errorf("failed to add caption of QR code %d: %w", i+1, err)
+			}
+		}
+
+		// Add text fallback after the last QR code on the page
+		if text && (cell == layout.PerPage()-1 || i == qrCodes.Len()-1) {
+			textPages, err := layoutQRCodeText(qrCodes, layout, i/layout.PerPage(), textRows)
+			if err != nil {
+				return err
+			}
+			for _, page := range textPages {
+				if err = addTextPage(&pdf, page, pageSize); err != nil {
+					return fmt.Errorf("failed to add text of QR codes on page %d: %w", i/layout.PerPage()+1, err)
+				}
+			}
+		}
+	}
+
+	// Add reference decoder
+	for _, page := range decoderPages {
+		if err = addTextPage(&pdf, page, pageSize); err != nil {
+			return fmt.Errorf("failed to add page of reference decoder: %w", err)
+		}
 	}
 
 	// Write PDF file
@@ -92,3 +290,101 @@ func GeneratePDF(outputPath string, title string, qrCodes [][]byte) (err error)
 	}
 	return nil
 }
+
+// qrGrid returns the size of the QR codes in the layout, including the gap and caption between them.
+// QR codes are captioned if multiple QR codes are on a page.
+func qrGrid(pageSize gopdf.Rect, layout Layout) (imageSize, gap, captionHeight float64) {
+	if layout.PerPage() > 1 {
+		gap, captionHeight = qrGap, qrCaptionHeight
+	}
+	columns, rows := float64(layout.Columns), float64(layout.Rows)
+	availableWidth := pageSize.W - 2*qrMargin - (columns-1)*gap
+	availableHeight := pageSize.H - qrHeaderHeight - qrFooterHeight - (rows-1)*gap - rows*captionHeight
+	return min(availableWidth/columns, availableHeight/rows), gap, captionHeight
+}
+
+// layoutQRCodeText flows the text fallback of the QR codes on the zero based page of QR codes over pages of rows lines
+func layoutQRCodeText(qrCodes QRCodeSource, layout Layout, page, rows int) ([]textPage, error) {
+	var blocks [][]string
+	for i := page * layout.PerPage(); i < min((page+1)*layout.PerPage(), qrCodes.Len()); i++ {
+		payload, err := qrCodes.Payload(i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate payload of QR code %d: %w", i+1, err)
+		}
+		lines := TextLines(payload)
+		blocks = append(blocks, append([]string{TextHeading(i+1, qrCodes.Len(), len(lines))}, lines...))
+	}
+	return layoutText(blocks, textColumns, rows), nil
+}
+
+// textPage is a page of the text fallback, which contains columns of lines
+type textPage [][]string
+
+// layoutText flows the blocks of lines over columns and pages. Blocks are separated by an empty line.
+func layoutText(blocks [][]string, columns, rows int) []textPage {
+	pages := []textPage{{nil}}
+	column := func() *[]string {
+		page := pages[len(pages)-1]
+		return &page[len(page)-1]
+	}
+	nextColumn := func() {
+		if len(pages[len(pages)-1]) == columns {
+			pages = append(pages, textPage{})
+		}
+		pages[len(pages)-1] = append(pages[len(pages)-1], nil)
+	}
+	for _, block := range blocks {
+		if len(*column()) > 0 {
+			if len(*column())+3 > rows {
+				// Prevent heading at the bottom of a column
+				nextColumn()
+			} else {
+				*column() = append(*column(), "")
+			}
+		}
+		for _, line := range block {
+			if len(*column()) == rows {
+				nextColumn()
+			}
+			*column() = append(*column(), line)
+		}
+	}
+	return pages
+}
+
+// addTextPage adds a page with the text fallback in a monospace font
+func addTextPage(pdf *gopdf.GoPdf, page textPage, pageSize gopdf.Rect) error {
+	pdf.AddPage()
+	err := pdf.SetFont(monospaceFont, "", textFontSize)
+	if err != nil {
+		return fmt.Errorf("failed to set font to %s: %w", monospaceFont, err)
+	}
+	columnWidth := (pageSize.W - 2*qrMargin - (textColumns-1)*textColumnGap) / textColumns
+	for i, column := range page {
+		for j, line := range column {
+			pdf.SetXY(qrMargin+float64(i)*(columnWidth+textColumnGap), qrHeaderHeight+float64(j)*textLineHeight)
+			if err = pdf.Cell(nil, line); err != nil {
+				return fmt.Errorf("failed to add text line: %w", err)
+			}
+		}
+	}
+	return pdf.SetFont(fontName, "", FontSize)
+}
+
+// setFont adds the embedded font to the PDF and selects it
+func setFont(pdf *gopdf.GoPdf) error {
+	err := pdf.AddTTFFontData(fontName, assets.DejaVuSansTTF)
+	if err != nil {
+		return fmt.Errorf("failed to add TTF font %s: %w", fontName, err)
+	}
+	err = pdf.SetFont(fontName, "", FontSize)
+	if err != nil {
+		return fmt.Errorf("failed to set font to %s: %w", fontName, err)
+	}
+	return nil
+}
+
+// generatedWith returns the notice with project URL and current time for in the footer
+func generatedWith() string {
+	return "Generated with https://github.com/JenswBE/encrypted-paper on " + time.Now().Format("02 Jan 2006 15:04 -0700")
+}
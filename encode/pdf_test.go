@@ -0,0 +1,77 @@
+package encode
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/JenswBE/encrypted-paper/qrcode"
+)
+
+func TestParseLayout(t *testing.T) {
+	layout, err := ParseLayout("2x3")
+	require.NoError(t, err)
+	require.Equal(t, Layout{Columns: 2, Rows: 3}, layout)
+	require.Equal(t, 6, layout.PerPage())
+	require.Equal(t, 2, layout.PageCount(7))
+
+	for _, input := range []string{"", "2", "2x", "0x2", "2x2x2", "ax2"} {
+		_, err = ParseLayout(input)
+		require.Error(t, err, input)
+	}
+}
+
+func TestLayoutForCount(t *testing.T) {
+	for count, expected := range map[int]Layout{
+		1: {Columns: 1, Rows: 1},
+		2: {Columns: 2, Rows: 1},
+		4: {Columns: 2, Rows: 2},
+		5: {Columns: 3, Rows: 2},
+		9: {Columns: 3, Rows: 3},
+	} {
+		layout, err := LayoutForCount(count)
+		require.NoError(t, err)
+		require.Equal(t, expected, layout, count)
+	}
+
+	_, err := LayoutForCount(0)
+	require.Error(t, err)
+}
+
+func TestLayoutValidate(t *testing.T) {
+	for _, layout := range []Layout{DefaultLayout, {Columns: 2, Rows: 1}, {Columns: 1, Rows: 2}, {Columns: 2, Rows: 2}} {
+		require.NoError(t, layout.Validate(), layout)
+	}
+	for _, layout := range []Layout{{Columns: 2, Rows: 3}, {Columns: 3, Rows: 1}, {Columns: 3, Rows: 3}, {Columns: 4, Rows: 4}} {
+		require.ErrorContains(t, layout.Validate(), "below the minimum", layout)
+	}
+}
+
+func TestLayoutText(t *testing.T) {
+	blocks := [][]string{{"A", "a1", "a2"}, {"B", "b1", "b2", "b3", "b4"}, {"C", "c1"}}
+	pages := layoutText(blocks, 2, 4)
+	require.Equal(t, []textPage{
+		{{"A", "a1", "a2"}, {"B", "b1", "b2", "b3"}},
+		{{"b4", "", "C", "c1"}},
+	}, pages)
+}
+
+func TestScanAndCombineQRCodesFromPDF(t *testing.T) {
+	data, qrDatas := buildTestQRData(t, 4*MaxQRCodeCapacity, 0)
+	documentID := qrDatas[0].DocumentID
+	qrCodes, err := GenerateQRCodes(*qrDatas[0].Header, qrcode.ECCLevelL, documentID, data)
+	require.NoError(t, err)
+
+	// Generate PDF with multiple QR codes per page
+	outputPath := filepath.Join(t.TempDir(), "sheets.pdf")
+	require.NoError(t, GeneratePDF(outputPath, "Test", documentID, Layout{Columns: 2, Rows: 1}, QRCodeSlice(qrCodes), false, nil, false))
+	pdf, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+
+	// Scan PDF
+	payload, err := ScanAndCombineQRCodes(map[string][]byte{"sheets.pdf": pdf}, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, data, payload.Data)
+}
@@ -3,127 +3,421 @@ package encode
 import (
 	"bytes"
 	"cmp"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"image/png"
+	"io"
 	"log/slog"
+	"maps"
 	"math"
+	"reflect"
 	"slices"
+	"strings"
+	"sync"
 
 	"github.com/fxamacker/cbor/v2"
-	"golang.org/x/sync/errgroup"
 
+	"github.com/JenswBE/encrypted-paper/compress"
 	"github.com/JenswBE/encrypted-paper/encrypt"
-	"github.com/JenswBE/encrypted-paper/utils"
+	"github.com/JenswBE/encrypted-paper/qrcode"
+	"github.com/JenswBE/encrypted-paper/scan"
 )
 
 // See https://en.wikipedia.org/wiki/QR_code#Information_capacity
 const (
-	MaxBytesInQRCode = 2953
-	MaxPageCount     = math.MaxUint8
+	MaxQRCodeCapacity = 2953           // Absolute maximum, with error correction level L. See MaxBytesInQRCodeForLevel for other levels.
+	MaxPageCount      = math.MaxUint16 // Also the maximum number of shards supported by Reed-Solomon
 )
 
+// parityShardAlignment is the multiple of which parity shards must be in size.
+// Reed-Solomon switches to GF(2^16) for more than 256 pages, which requires shards sized in multiples of 64 bytes.
+const parityShardAlignment = 64
+
+// QRModuleSize is the size in pixels of a single QR code module in the generated images
+const QRModuleSize = 10
+
+// FormatVersion is the version of the payload format written by this build.
+// Sheets without version (legacy) are treated as version 0.
+// Bump this version once per release with changes which prevent older builds from decoding new sheets.
+const FormatVersion uint8 = 1
+
+// formatVersionAuthenticatedHeader is the first format version which authenticates the header as associated data.
+const formatVersionAuthenticatedHeader uint8 = 1
+
 type QRHeader struct {
+	Version     uint8                 `json:"version,omitempty"`
+	Compression compress.Algorithm    `json:"compression,omitempty"`
+	KDFAlgo     encrypt.KDFAlgorithm  `json:"kdf_algo,omitempty"`
+	AEAD        encrypt.AEADAlgorithm `json:"aead,omitempty"`
+
+	// PageCount is encoded as CBOR integer, which has a variable length.
+	// Therefore, sheets written with 8-bit page counts can still be decoded.
 	Salt      []byte `json:"salt"`
-	PageCount uint8  `json:"page_count"`
+	PageCount uint32 `json:"page_count"`
+
+	// KDF is nil for legacy sheets. In that case, encrypt.DefaultKDFParams should be assumed.
+	KDF *encrypt.KDFParams `json:"kdf,omitempty"`
+
+	// Keyfile is true if the content of a keyfile is mixed into the key derivation, together with the password
+	Keyfile bool `json:"keyfile,omitempty"`
+
+	// ParityPages is the number of Reed-Solomon parity pages at the end of the set. These are included in the page count.
+	ParityPages uint32 `json:"parity_pages,omitempty"`
+
+	// DataSize is the size of the combined data. Only set when parity pages are used, to remove the padding of the last data page.
+	DataSize uint32 `json:"data_size,omitempty"`
+
+	// Share is the key share of this set of sheets. Only set when the key is split using Shamir's Secret Sharing.
+	Share *encrypt.KeyShare `json:"share,omitempty"`
+
+	// Recipients contains the key wrapped for each X25519 recipient. Only set when encrypting to recipients.
+	Recipients []encrypt.Stanza `json:"recipients,omitempty"`
+
+	// DataHash is the SHA-256 hash of the combined data. Used to distinguish corrupted pages from a wrong key during decode.
+	DataHash []byte `json:"data_hash,omitempty"`
+}
+
+// DataPageCount returns the number of pages which contain data, excluding parity pages
+func (h QRHeader) DataPageCount() uint {
+	return uint(h.PageCount) - uint(h.ParityPages)
+}
+
+// AssociatedData returns the canonical CBOR encoding of the header,
+// which is authenticated together with the payload during encryption.
+// Returns nil for legacy sheets which didn't authenticate the header and for age payloads, as age doesn't support associated data.
+func (h QRHeader) AssociatedData() ([]byte, error) {
+	if h.Version < formatVersionAuthenticatedHeader || h.AEAD == encrypt.AEADAge {
+		return nil, nil
+	}
+	h.Share = nil    // Sets of a split key share the same ciphertext, so the key share can't be authenticated
+	h.DataHash = nil // Hash of the ciphertext can't be part of its own associated data
+	if h.AEAD == encrypt.AEADXChaCha20Poly1305Stream {
+		// Data is encrypted before the page layout is known. Instead, the STREAM construction authenticates
+		// the length of the data, to which the page count and data size are bound by validatePageLayout.
+		h.PageCount, h.DataSize = 0, 0
+	}
+	encMode, err := cbor.CanonicalEncOptions().EncMode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create canonical CBOR encoder: %w", err)
+	}
+	output, err := encMode.Marshal(h)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode header as canonical CBOR: %w", err)
+	}
+	return output, nil
 }
 
 type QRData struct {
 	Header     *QRHeader `json:"header,omitempty"`
-	PageNumber uint8     `json:"page_number"`
+	PageNumber uint32    `json:"page_number"`
 	Data       []byte    `json:"data"`
+
+	// DocumentID is shared by all pages of a single encode. Empty for legacy sheets.
+	DocumentID DocumentID `json:"document_id,omitempty"`
+
+	// CRC32 is the IEEE CRC-32 checksum of Data. Nil for legacy sheets.
+	CRC32 *uint32 `json:"crc32,omitempty"`
 }
 
-func getQRDataOverhead(withHeader bool) uint {
+// hasValidChecksum returns true if the checksum matches the data. Legacy pages without checksum are assumed valid.
+func (d QRData) hasValidChecksum() bool {
+	return d.CRC32 == nil || crc32.ChecksumIEEE(d.Data) == *d.CRC32
+}
+
+// getQRDataOverhead returns the size of the page metadata for the error correction level. Header is nil for pages without header.
+// Values which are only known after calculating the page count are set to their maximum.
+func getQRDataOverhead(header *QRHeader, level qrcode.ECCLevel) (uint, error) {
+	capacity := MaxBytesInQRCodeForLevel(level)
 	qrData := QRData{
-		Data:       []byte{1},
+		Data:       make([]byte, capacity), // Ensures the length prefix of the data has its maximum size
 		PageNumber: MaxPageCount,
+		DocumentID: make(DocumentID, DocumentIDSize),
+		CRC32:      new(uint32),
 	}
-	if withHeader {
-		qrData.Header = &QRHeader{
-			Salt:      make([]byte, encrypt.SaltSizeBytes),
-			PageCount: MaxPageCount,
+	*qrData.CRC32 = math.MaxUint32
+	if header != nil {
+		worstCaseHeader := *header
+		worstCaseHeader.PageCount = MaxPageCount
+		worstCaseHeader.DataSize = math.MaxUint32
+		worstCaseHeader.DataHash = make([]byte, sha256.Size)
+		if worstCaseHeader.Share != nil {
+			// Sets of a split key must have the same page count
+			worstCaseHeader.Share = &encrypt.KeyShare{
+				Index:     math.MaxUint8,
+				Threshold: math.MaxUint8,
+				Count:     math.MaxUint8,
+				Value:     header.Share.Value,
+			}
 		}
+		qrData.Header = &worstCaseHeader
 	}
 	output, err := cbor.Marshal(qrData)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to calculate QR data overhead: %v", err))
+		return 0, fmt.Errorf("failed to calculate QR data overhead: %w", err)
 	}
-	return uint(len(output))
+	return uint(len(output)) - capacity, nil
 }
 
-func GenerateQRCodes(salt []byte, data []byte, maxOutputPages uint) ([][]byte, error) {
-	// Calculate overhead
-	maxDataSizeWithHeader := MaxBytesInQRCode - getQRDataOverhead(true)
-	maxDataSizeWithoutHeader := MaxBytesInQRCode - getQRDataOverhead(false)
-	pageCount := calcPageCount(maxDataSizeWithHeader, maxDataSizeWithoutHeader, uint(len(data)))
-	if pageCount > math.MaxUint8 {
-		return nil, fmt.Errorf("page count is %d, but maximum supported page count in header is %d", pageCount, MaxPageCount)
+// MaxDataSize returns the maximum size of data which fits in the provided number of pages, including the parity pages set in the header
+func MaxDataSize(header QRHeader, level qrcode.ECCLevel, maxPages uint) (uint, error) {
+	maxDataSizeWithHeader, maxDataSizeWithoutHeader, err := maxDataSizes(header, level)
+	if err != nil {
+		return 0, err
+	}
+	dataPages := min(maxPages, MaxPageCount) - min(uint(header.ParityPages), maxPages)
+	switch {
+	case dataPages == 0:
+		return 0, nil
+	case header.ParityPages > 0:
+		return dataPages * maxDataSizeWithHeader, nil
+	default:
+		return maxDataSizeWithHeader + (dataPages-1)*maxDataSizeWithoutHeader, nil
+	}
+}
+
+// CalcPageCount returns the number of pages needed to store data of the provided size, including the parity pages set in the header.
+// Set maxOutputPages to 0 to only limit by MaxPageCount.
+func CalcPageCount(header QRHeader, level qrcode.ECCLevel, dataSize, maxOutputPages uint) (uint, error) {
+	// Calculate page count
+	maxDataSizeWithHeader, maxDataSizeWithoutHeader, err := maxDataSizes(header, level)
+	if err != nil {
+		return 0, err
+	}
+	var pageCount uint
+	if header.ParityPages == 0 {
+		pageCount = calcPageCount(maxDataSizeWithHeader, maxDataSizeWithoutHeader, dataSize)
+	} else {
+		// All data pages must have the same size to calculate parity. As parity pages also contain
+		// the header, all pages are limited to the size of a page with header.
+		pageCount = calcPageCount(maxDataSizeWithHeader, maxDataSizeWithHeader, dataSize) + uint(header.ParityPages)
+	}
+	if pageCount > MaxPageCount {
+		return 0, fmt.Errorf("page count is %d, but maximum supported page count is %d", pageCount, MaxPageCount)
 	}
 
 	// Validate max output pages
-	if pageCount > maxOutputPages {
-		return nil, fmt.Errorf("%d expected output pages is more than configured maximum of %d allowed output pages", pageCount, maxOutputPages)
-	}
-
-	// Generate QR codes
-	var cursor uint
-	var qrData QRData
-	var err error
-	output := make([][]byte, pageCount)
-	for i := range pageCount {
-		pageNumber := i + 1 // 1 for zero indexed
-		if pageNumber == 1 {
-			// Stage first page
-			qrData = QRData{
-				Header: &QRHeader{
-					Salt:      salt,
-					PageCount: uint8(pageCount),
-				},
-				PageNumber: 1,
-				Data:       data,
-			}
-			if pageCount > 1 {
-				// Data is too big for a single page
-				qrData.Data = data[:maxDataSizeWithHeader]
-				cursor = maxDataSizeWithHeader
-			}
-		} else {
-			// Stage remaining pages
-			readUntil := cursor + maxDataSizeWithoutHeader
-			if readUntil > uint(len(data)) {
-				readUntil = uint(len(data))
-			}
-			qrData = QRData{
-				PageNumber: uint8(pageNumber),
-				Data:       data[cursor:readUntil],
-			}
-			cursor = readUntil
+	if maxOutputPages > 0 && pageCount > maxOutputPages {
+		return 0, fmt.Errorf("%d expected output pages is more than configured maximum of %d allowed output pages", pageCount, maxOutputPages)
+	}
+	return pageCount, nil
+}
+
+// GenerateQRCodes splits the data over the amount of QR codes set as page count in the header.
+// Page size depends on the error correction level, so the page count must be calculated for the same level.
+// Document ID is added to every page.
+func GenerateQRCodes(header QRHeader, level qrcode.ECCLevel, documentID DocumentID, data []byte) ([]QRCode, error) {
+	qrCodes, err := NewQRCodes(header, level, documentID, bytes.NewReader(data), uint(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	output := make([]QRCode, qrCodes.Len())
+	for i := range output {
+		output[i], err = qrCodes.QRCode(i)
+		if err != nil {
+			return nil, err
 		}
+	}
+	return output, nil
+}
+
+// QRCodes generates the QR codes of a set one page at a time, reading the data of each page on demand.
+// Only the parity pages are kept in memory, so the data doesn't have to fit in memory.
+// Implements QRCodeSource.
+type QRCodes struct {
+	header     QRHeader
+	level      qrcode.ECCLevel
+	documentID DocumentID
+	dataPages  []*io.SectionReader
+	parity     [][]byte
+}
 
-		// Marchal to CBOR and generate QR code
-		output[i], err = marshalAndCreateQR(qrData)
+// NewQRCodes splits the data over the amount of QR codes set as page count in the header and calculates the parity pages.
+// Page size depends on the error correction level, so the page count must be calculated for the same level.
+// Document ID is added to every page.
+func NewQRCodes(header QRHeader, level qrcode.ECCLevel, documentID DocumentID, data io.ReaderAt, dataSize uint) (*QRCodes, error) {
+	// Split data in pages
+	maxDataSizeWithHeader, maxDataSizeWithoutHeader, err := maxDataSizes(header, level)
+	if err != nil {
+		return nil, err
+	}
+	if header.ParityPages > 0 {
+		maxDataSizeWithoutHeader = maxDataSizeWithHeader
+	}
+	dataPages := splitPages(data, dataSize, maxDataSizeWithHeader, maxDataSizeWithoutHeader)
+	if uint(len(dataPages)) != header.DataPageCount() {
+		return nil, fmt.Errorf("data requires %d data pages, but header states %d data pages", len(dataPages), header.DataPageCount())
+	}
+
+	// Calculate parity
+	qrCodes := &QRCodes{header: header, level: level, documentID: documentID, dataPages: dataPages}
+	if header.ParityPages > 0 {
+		qrCodes.parity, err = calcParityShards(dataPages, int(header.ParityPages))
 		if err != nil {
-			return nil, fmt.Errorf("failed to generate page %d: %w", pageNumber, err)
+			return nil, fmt.Errorf("failed to calculate parity pages: %w", err)
+		}
+	}
+	return qrCodes, nil
+}
+
+// Len returns the number of QR codes, including parity
+func (q *QRCodes) Len() int {
+	return int(q.header.PageCount)
+}
+
+// Payload returns the page with the zero based index encoded as CBOR
+func (q *QRCodes) Payload(i int) ([]byte, error) {
+	qrData, err := q.qrData(i)
+	if err != nil {
+		return nil, err
+	}
+	return marshalQRData(qrData)
+}
+
+// QRCode generates the QR code of the page with the zero based index
+func (q *QRCodes) QRCode(i int) (QRCode, error) {
+	qrData, err := q.qrData(i)
+	if err != nil {
+		return QRCode{}, err
+	}
+	qrCode, err := marshalAndCreateQR(qrData, q.level)
+	if err != nil {
+		return QRCode{}, fmt.Errorf("failed to generate page %d: %w", qrData.PageNumber, err)
+	}
+	return qrCode, nil
+}
+
+// qrData builds the page with the zero based index. Header is added to the first page and all parity pages.
+func (q *QRCodes) qrData(i int) (QRData, error) {
+	if i < 0 || i >= q.Len() {
+		return QRData{}, fmt.Errorf("page %d is out of range, header states %d pages", i+1, q.Len())
+	}
+	var data []byte
+	if i < len(q.dataPages) {
+		data = make([]byte, q.dataPages[i].Size())
+		if _, err := q.dataPages[i].ReadAt(data, 0); err != nil {
+			return QRData{}, fmt.Errorf("failed to read data of page %d: %w", i+1, err)
+		}
+	} else {
+		data = q.parity[i-len(q.dataPages)]
+	}
+	checksum := crc32.ChecksumIEEE(data)
+	qrData := QRData{
+		PageNumber: uint32(i + 1), // 1 for zero indexed
+		Data:       data,
+		DocumentID: q.documentID,
+		CRC32:      &checksum,
+	}
+	if i == 0 || i >= len(q.dataPages) {
+		qrData.Header = &q.header
+	}
+	return qrData, nil
+}
+
+// splitQRData splits the data over the data pages and calculates the parity pages.
+// Header is added to the first page and all parity pages.
+func splitQRData(header QRHeader, level qrcode.ECCLevel, documentID DocumentID, data []byte) ([]QRData, error) {
+	qrCodes, err := NewQRCodes(header, level, documentID, bytes.NewReader(data), uint(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	output := make([]QRData, qrCodes.Len())
+	for i := range output {
+		output[i], err = qrCodes.qrData(i)
+		if err != nil {
+			return nil, err
 		}
 	}
 	return output, nil
 }
 
-func marshalAndCreateQR(qrData QRData) ([]byte, error) {
+// splitPages splits the data in pages. First page has a different maximum size to make room for the header.
+func splitPages(data io.ReaderAt, dataSize, maxFirstPageSize, maxPageSize uint) []*io.SectionReader {
+	firstPageSize := min(maxFirstPageSize, dataSize)
+	pages := []*io.SectionReader{io.NewSectionReader(data, 0, int64(firstPageSize))}
+	for cursor := firstPageSize; cursor < dataSize; cursor += maxPageSize {
+		pages = append(pages, io.NewSectionReader(data, int64(cursor), int64(min(maxPageSize, dataSize-cursor))))
+	}
+	return pages
+}
+
+// maxDataSizes returns the maximum data size of a page with and without header for the error correction level.
+// With parity pages, the size of a page with header is aligned to parityShardAlignment.
+func maxDataSizes(header QRHeader, level qrcode.ECCLevel) (withHeader, withoutHeader uint, err error) {
+	overheadWithHeader, err := getQRDataOverhead(&header, level)
+	if err != nil {
+		return 0, 0, err
+	}
+	overheadWithoutHeader, err := getQRDataOverhead(nil, level)
+	if err != nil {
+		return 0, 0, err
+	}
+	capacity := MaxBytesInQRCodeForLevel(level)
+	if overheadWithHeader+parityShardAlignment > capacity {
+		return 0, 0, fmt.Errorf("header is %d bytes, which doesn't fit in a QR code of %d bytes with error correction level %s: please reduce the number of recipients or lower the error correction level", overheadWithHeader, capacity, level)
+	}
+	withHeader, withoutHeader = capacity-overheadWithHeader, capacity-overheadWithoutHeader
+	if header.ParityPages > 0 {
+		// Parity pages contain a parity shard of the same size as a data page
+		withHeader -= withHeader % parityShardAlignment
+	}
+	return withHeader, withoutHeader, nil
+}
+
+// MaxBytesInQRCodeForLevel returns the capacity of the largest QR code with the error correction level
+func MaxBytesInQRCodeForLevel(level qrcode.ECCLevel) uint {
+	return uint(qrcode.Capacity(qrcode.MaxVersion, level))
+}
+
+// QRCode is a generated QR code of a single page
+type QRCode struct {
+	Payload []byte // Page encoded as CBOR, which is also printed by the text fallback
+	PNG     []byte
+}
+
+func marshalAndCreateQR(qrData QRData, level qrcode.ECCLevel) (QRCode, error) {
 	// Marshal into CBOR
+	payload, err := marshalQRData(qrData)
+	if err != nil {
+		return QRCode{}, err
+	}
+
+	// Encode as QR code
+	qrCode, err := qrcode.Encode(payload, level)
+	if err != nil {
+		return QRCode{}, fmt.Errorf("failed to generate QR code: %w", err)
+	}
+	pngData, err := qrCode.PNG(QRModuleSize)
+	if err != nil {
+		return QRCode{}, fmt.Errorf("failed to generate PNG of QR code: %w", err)
+	}
+	return QRCode{Payload: payload, PNG: pngData}, nil
+}
+
+func marshalQRData(qrData QRData) ([]byte, error) {
 	var cborData bytes.Buffer
 	err := cbor.NewEncoder(&cborData).Encode(qrData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode data as CBOR: %w", err)
 	}
+	return cborData.Bytes(), nil
+}
 
-	// Encode as QR code
-	var qrCode bytes.Buffer
-	err = utils.RunCommand("generate QR code", &cborData, &qrCode, "qrencode", "--8bit", "--level=L", "--output=-", "--dpi=300", "--size=10")
+// VerifyQRCode ensures the image of the QR code decodes to its payload
+func VerifyQRCode(qrCode QRCode) error {
+	img, err := png.Decode(bytes.NewReader(qrCode.PNG))
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate QR code: %w", err)
+		return fmt.Errorf("failed to decode PNG of QR code: %w", err)
+	}
+	result, err := qrcode.Decode(img)
+	if err != nil {
+		return fmt.Errorf("failed to decode QR code: %w", err)
+	}
+	if !bytes.Equal(result.Data, qrCode.Payload) {
+		return errors.New("decoded QR code differs from its payload")
 	}
-	return qrCode.Bytes(), nil
+	return nil
 }
 
 func calcPageCount(maxDataSizeWithHeader, maxDataSizeWithoutHeader, totalDataSize uint) uint {
@@ -131,77 +425,461 @@ func calcPageCount(maxDataSizeWithHeader, maxDataSizeWithoutHeader, totalDataSiz
 		return 1
 	}
 	remainingSize := totalDataSize - maxDataSizeWithHeader
-	return remainingSize/maxDataSizeWithoutHeader + 2 // 1 for page with header and 1 for int decimal cutoff
+	return 1 + (remainingSize+maxDataSizeWithoutHeader-1)/maxDataSizeWithoutHeader // 1 for page with header
 }
 
-func ScanAndCombineQRCodes(qrCodes map[string][]byte) (data, salt []byte, err error) {
-	// Scan QR codes
-	qrDatas, err := scanQRCodes(qrCodes)
+// Payload is the combined content of the scanned sheets
+type Payload struct {
+	Header     *QRHeader
+	DocumentID DocumentID // Nil for legacy sheets
+	Data       []byte
+
+	// KeyShares are the distinct key shares found in the scanned sets. Only set when the key is split.
+	KeyShares []encrypt.KeyShare
+}
+
+// ScanAndCombineQRCodes scans the QR codes and combines the pages of a single document.
+// Texts are typed or OCR'd text files of the text fallback, which can replace or complement the scans.
+// Set documentID to nil to require all scans to belong to the same document. Otherwise, scans of other documents are ignored.
+func ScanAndCombineQRCodes(qrCodes, texts map[string][]byte, documentID DocumentID) (*Payload, error) {
+	// Scan QR codes and parse texts. Unreadable QR codes or texts might be recoverable with parity pages.
+	scans, scanErr := scanQRCodes(qrCodes)
+	textScans, textErr := parseTexts(texts)
+	scans = append(scans, textScans...)
+	return combineScans(scans, errors.Join(scanErr, textErr), documentID)
+}
+
+// CombinePayloads combines the CBOR encoded payloads of QR codes the same way as ScanAndCombineQRCodes,
+// without scanning images. Used to validate generated QR codes before they are printed.
+func CombinePayloads(payloads [][]byte) (*Payload, error) {
+	scans := make([]scannedQRData, 0, len(payloads))
+	for i, payload := range payloads {
+		var qrData QRData
+		if err := cbor.Unmarshal(payload, &qrData); err != nil {
+			return nil, fmt.Errorf("failed to decode payload %d as CBOR: %w", i+1, err)
+		}
+		scans = append(scans, scannedQRData{fileName: fmt.Sprintf("payload %d", i+1), qrData: qrData})
+	}
+	return combineScans(scans, nil, nil)
+}
+
+// combineScans selects the document and combines its scans into the payload.
+// Scan error is returned if the data can't be combined, otherwise it's only logged.
+func combineScans(scans []scannedQRData, scanErr error, documentID DocumentID) (*Payload, error) {
+	// Select document
+	scans, documentID, err := selectDocument(scans, documentID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to scan QR codes: %w", err)
+		return nil, err
+	}
+
+	// Duplicate pages are expected, e.g. with overlapping scans, as long as they agree
+	if err = checkDuplicatePages(scans); err != nil {
+		return nil, err
+	}
+	qrDatas := make([]QRData, len(scans))
+	for i, scan := range scans {
+		qrDatas[i] = scan.qrData
 	}
 
-	// Sort and combine codes
+	// Combine QR codes
+	data, header, err := combineQRData(qrDatas)
+	if err != nil {
+		if scanErr != nil {
+			return nil, fmt.Errorf("failed to scan QR codes: %w", errors.Join(scanErr, err))
+		}
+		return nil, fmt.Errorf("failed to combine QR codes: %w", err)
+	}
+	if scanErr != nil {
+		slog.Warn("Data was recovered using parity pages, despite unreadable QR codes", "error", scanErr)
+	}
+
+	// Collect key shares
+	payload := &Payload{Header: header, DocumentID: documentID, Data: data}
+	if header.KDFAlgo == encrypt.KDFShamir {
+		payload.KeyShares, err = collectKeyShares(qrDatas)
+		if err != nil {
+			return nil, fmt.Errorf("failed to collect key shares: %w", err)
+		}
+	}
+	return payload, nil
+}
+
+// checkDuplicatePages returns an error naming the files if a page is scanned multiple times with different data.
+// Pages with a checksum mismatch are skipped, as they are treated as missing while combining.
+func checkDuplicatePages(scans []scannedQRData) error {
+	scans = slices.Clone(scans)
+	slices.SortStableFunc(scans, func(a, b scannedQRData) int { return strings.Compare(a.fileName, b.fileName) })
+	firstScans := make(map[uint32]scannedQRData)
+	for _, scan := range scans {
+		if !scan.qrData.hasValidChecksum() {
+			continue
+		}
+		first, ok := firstScans[scan.qrData.PageNumber]
+		if !ok {
+			firstScans[scan.qrData.PageNumber] = scan
+			continue
+		}
+		switch {
+		case bytes.Equal(first.qrData.Data, scan.qrData.Data):
+			continue
+		case first.fileName == scan.fileName:
+			return fmt.Errorf(`page %d is found multiple times with different data in file "%s"`, scan.qrData.PageNumber, scan.fileName)
+		default:
+			return fmt.Errorf(`page %d is scanned multiple times with different data, in file "%s" and in file "%s"`, scan.qrData.PageNumber, first.fileName, scan.fileName)
+		}
+	}
+	return nil
+}
+
+// collectKeyShares returns the distinct key shares in the headers of the pages.
+// Pages from multiple sets of the same split key can be combined to collect enough key shares.
+func collectKeyShares(qrDatas []QRData) ([]encrypt.KeyShare, error) {
+	sharesByIndex := make(map[uint8]encrypt.KeyShare)
+	for _, qrData := range qrDatas {
+		if qrData.Header == nil || qrData.Header.Share == nil {
+			continue
+		}
+		share := *qrData.Header.Share
+		if existing, ok := sharesByIndex[share.Index]; ok && !bytes.Equal(existing.Value, share.Value) {
+			return nil, fmt.Errorf("key share %d is provided multiple times with different values", share.Index)
+		}
+		sharesByIndex[share.Index] = share
+	}
+	shares := slices.SortedFunc(maps.Values(sharesByIndex), func(a, b encrypt.KeyShare) int { return cmp.Compare(a.Index, b.Index) })
+	if len(shares) > 0 && len(shares) < int(shares[0].Threshold) {
+		return nil, fmt.Errorf("found key shares %v, but %d distinct key shares are required: please add scans of other sets", shareIndexes(shares), shares[0].Threshold)
+	}
+	return shares, nil
+}
+
+func shareIndexes(shares []encrypt.KeyShare) []uint8 {
+	indexes := make([]uint8, len(shares))
+	for i, share := range shares {
+		indexes[i] = share.Index
+	}
+	return indexes
+}
+
+// combineQRData validates the pages and combines their data. Missing pages are reconstructed if parity pages are available.
+// Pages might be provided multiple times when combining sets of a split key, as long as their data is identical.
+func combineQRData(qrDatas []QRData) (data []byte, header *QRHeader, err error) {
+	// Find header on first page or parity pages
 	slices.SortFunc(qrDatas, func(a, b QRData) int { return cmp.Compare(a.PageNumber, b.PageNumber) })
-	var buf bytes.Buffer
-	buf.Grow(MaxBytesInQRCode * len(qrCodes)) // Ignore overhead of metadata to keep code KISS
-	for i, qrData := range qrDatas {
-		if qrData.PageNumber != uint8(i+1) {
-			return nil, nil, fmt.Errorf("page %d is missing", i+1)
-		}
-		if i == 0 {
-			// First page contains salt and page count
-			if qrData.Header == nil {
-				return nil, nil, errors.New("header with metadata not found in first page")
+	for _, qrData := range qrDatas {
+		if qrData.Header == nil {
+			continue
+		}
+		if header == nil {
+			header = qrData.Header
+		} else if !headersEqualExceptShare(*header, *qrData.Header) {
+			return nil, nil, fmt.Errorf("header on page %d differs from header on other pages", qrData.PageNumber)
+		}
+	}
+	if header == nil {
+		return nil, nil, errors.New("header with metadata not found in first page or parity pages")
+	}
+	if err = validateHeader(header); err != nil {
+		return nil, nil, fmt.Errorf("invalid header: %w", err)
+	}
+
+	// Index pages. Corrupted pages are treated as missing, as they might be provided multiple times or be recoverable.
+	pages := make([][]byte, header.PageCount)
+	corruptedPages := make(map[int]bool)
+	for _, qrData := range qrDatas {
+		if qrData.PageNumber == 0 || qrData.PageNumber > header.PageCount {
+			return nil, nil, fmt.Errorf("page %d is out of range, header states %d pages", qrData.PageNumber, header.PageCount)
+		}
+		if !qrData.hasValidChecksum() {
+			slog.Warn("Ignoring page with corrupted data", "page", qrData.PageNumber)
+			corruptedPages[int(qrData.PageNumber)] = true
+			continue
+		}
+		if pages[qrData.PageNumber-1] != nil {
+			if bytes.Equal(pages[qrData.PageNumber-1], qrData.Data) {
+				continue
 			}
-			if len(qrData.Header.Salt) != encrypt.SaltSizeBytes {
-				return nil, nil, fmt.Errorf("salt in header is %d bytes, but salt must be %d bytes", len(qrData.Header.Salt), encrypt.SaltSizeBytes)
+			return nil, nil, fmt.Errorf("page %d is provided multiple times with different data", qrData.PageNumber)
+		}
+		pages[qrData.PageNumber-1] = qrData.Data
+	}
+
+	// Reconstruct missing pages
+	var missingPages []int
+	for i, page := range pages {
+		if page == nil {
+			missingPages = append(missingPages, i+1)
+		}
+	}
+	dataPages := pages[:header.DataPageCount()]
+	if len(missingPages) > 0 {
+		if len(missingPages) > int(header.ParityPages) {
+			if header.ParityPages == 0 {
+				if corruptedPages[missingPages[0]] {
+					return nil, nil, fmt.Errorf("page %d data is corrupted", missingPages[0])
+				}
+				return nil, nil, fmt.Errorf("page %d is missing", missingPages[0])
 			}
-			salt = qrData.Header.Salt
-			if uint(qrData.Header.PageCount) != uint(len(qrCodes)) {
-				return nil, nil, fmt.Errorf("%d qr codes received, but accordingly to header, there must be %d qr codes", len(qrCodes), qrData.Header.PageCount)
+			if len(corruptedPages) > 0 {
+				return nil, nil, fmt.Errorf("%d pages are missing or corrupted %v, but only %d pages can be recovered using parity pages", len(missingPages), missingPages, header.ParityPages)
 			}
+			return nil, nil, fmt.Errorf("%d pages are missing %v, but only %d pages can be recovered using parity pages", len(missingPages), missingPages, header.ParityPages)
+		}
+		slog.Warn("Reconstructing missing pages using parity pages", "pages", missingPages)
+		if dataPages, err = reconstructDataShards(pages, int(header.DataPageCount())); err != nil {
+			return nil, nil, fmt.Errorf("failed to reconstruct missing pages: %w", err)
+		}
+	}
+
+	// Combine pages
+	if header.AEAD == encrypt.AEADXChaCha20Poly1305Stream {
+		if err = validatePageLayout(header, dataPages); err != nil {
+			return nil, nil, err
 		}
-		buf.Write(qrData.Data)
 	}
-	return buf.Bytes(), salt, nil
+	data = slices.Concat(dataPages...)
+	if header.ParityPages > 0 {
+		// Remove padding added during reconstruction
+		if uint(header.DataSize) > uint(len(data)) {
+			return nil, nil, fmt.Errorf("header states %d bytes of data, but pages only contain %d bytes", header.DataSize, len(data))
+		}
+		data = data[:header.DataSize]
+	}
+
+	// Validate data hash
+	if header.DataHash != nil {
+		dataHash := sha256.Sum256(data)
+		if !bytes.Equal(dataHash[:], header.DataHash) {
+			return nil, nil, errors.New("combined data doesn't match the data hash in the header: one or more pages are corrupted")
+		}
+	}
+	return data, header, nil
+}
+
+// validatePageLayout ensures the data pages are the minimal number of pages for the data, which is how they are split by encode.
+// Page count and data size aren't part of the associated data of STREAM payloads. As STREAM authenticates the length of
+// the data, requiring the minimal layout binds both to the authenticated data. E.g. pages without data can't be added.
+// Data pages must be unpadded, except for reconstructed pages.
+func validatePageLayout(header *QRHeader, dataPages [][]byte) error {
+	// All data pages must be full, except the last. Without parity, the first page is smaller to make room for the header.
+	fullPages := dataPages[:len(dataPages)-1]
+	if header.ParityPages == 0 && len(fullPages) > 0 {
+		fullPages = fullPages[1:]
+	}
+	pageSize := len(dataPages[len(dataPages)-1])
+	if len(fullPages) > 0 {
+		pageSize = len(fullPages[0])
+	}
+	for i, page := range fullPages {
+		if len(page) != pageSize {
+			return fmt.Errorf("page %d contains %d bytes, but other pages contain %d bytes: pages are tampered with", int(header.DataPageCount())-len(fullPages)+i, len(page), pageSize)
+		}
+	}
+	if len(dataPages[len(dataPages)-1]) == 0 {
+		return fmt.Errorf("last data page %d is empty: pages are tampered with", len(dataPages))
+	}
+
+	// Data must end in the last data page
+	if header.ParityPages > 0 && (uint(header.DataSize) <= uint(len(dataPages)-1)*uint(pageSize) || uint(header.DataSize) > uint(len(dataPages))*uint(pageSize)) {
+		return fmt.Errorf("header states %d bytes of data, which doesn't end in the last data page of %d pages of %d bytes: header is tampered with", header.DataSize, len(dataPages), pageSize)
+	}
+	return nil
+}
+
+// headersEqualExceptShare returns true if both headers are equal, ignoring the key share which differs between sets
+func headersEqualExceptShare(a, b QRHeader) bool {
+	a.Share, b.Share = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// validateHeader ensures the header can be decoded by this build.
+// Legacy headers are completed with the algorithms and parameters which were implied at the time.
+func validateHeader(header *QRHeader) error {
+	// Validate version
+	switch {
+	case header.Version == 0:
+		// Legacy sheets always used xz, Argon2id and XChaCha20-Poly1305
+		header.Compression = compress.AlgorithmXZ
+		header.KDFAlgo = encrypt.KDFArgon2id
+		header.AEAD = encrypt.AEADXChaCha20Poly1305
+	case header.Version > FormatVersion:
+		return fmt.Errorf("sheet uses format version %d, but this build only supports up to version %d: please use a newer version of encrypted-paper", header.Version, FormatVersion)
+	}
+
+	// Validate page count
+	if header.PageCount == 0 || header.PageCount > MaxPageCount {
+		return fmt.Errorf("header states %d pages, but page count must be between 1 and %d", header.PageCount, MaxPageCount)
+	}
+	if header.ParityPages >= header.PageCount {
+		return fmt.Errorf("header states %d parity pages, which must be less than the page count of %d", header.ParityPages, header.PageCount)
+	}
+
+	// Validate algorithms
+	if !header.Compression.IsSupported() {
+		return fmt.Errorf("compression algorithm %s is not supported", header.Compression)
+	}
+	if !header.KDFAlgo.IsSupported() {
+		return fmt.Errorf("KDF algorithm %s is not supported", header.KDFAlgo)
+	}
+	if !header.AEAD.IsSupported() {
+		return fmt.Errorf("AEAD algorithm %s is not supported", header.AEAD)
+	}
+
+	// Validate parameters
+	switch header.KDFAlgo {
+	case encrypt.KDFArgon2id:
+		if len(header.Salt) != encrypt.SaltSizeBytes {
+			return fmt.Errorf("salt in header is %d bytes, but salt must be %d bytes", len(header.Salt), encrypt.SaltSizeBytes)
+		}
+		if header.KDF == nil {
+			// Sheets without KDF parameters used the defaults
+			kdfParams := encrypt.DefaultKDFParams
+			header.KDF = &kdfParams
+		}
+		if err := header.KDF.Validate(); err != nil {
+			return fmt.Errorf("invalid KDF parameters in header: %w", err)
+		}
+	case encrypt.KDFShamir:
+		if header.Share == nil {
+			return errors.New("key is split in shares, but header doesn't contain a key share")
+		}
+		if header.Share.Index == 0 || header.Share.Threshold < 2 || header.Share.Threshold > header.Share.Count {
+			return fmt.Errorf("key share %d has invalid threshold %d of %d shares", header.Share.Index, header.Share.Threshold, header.Share.Count)
+		}
+	case encrypt.KDFX25519:
+		if len(header.Recipients) == 0 {
+			return errors.New("key is encrypted to recipients, but header doesn't contain any recipients")
+		}
+	}
+	if header.Keyfile && header.KDFAlgo != encrypt.KDFArgon2id {
+		return fmt.Errorf("keyfile is not supported by KDF algorithm %s", header.KDFAlgo)
+	}
+	if (header.KDFAlgo == encrypt.KDFAge) != (header.AEAD == encrypt.AEADAge) {
+		return fmt.Errorf("KDF algorithm %s cannot be combined with AEAD algorithm %s", header.KDFAlgo, header.AEAD)
+	}
+	return nil
 }
 
-func scanQRCodes(qrCodes map[string][]byte) ([]QRData, error) {
-	// Scan and unmarshal QR codes
-	qrDatasChan := make(chan QRData, len(qrCodes))
-	g := new(errgroup.Group)
+// scanQRCodes scans all QR codes. Returns the QR codes which could be scanned,
+// together with an error if one or more QR codes couldn't be scanned.
+func scanQRCodes(qrCodes map[string][]byte) ([]scannedQRData, error) {
+	// Scan and unmarshal QR codes. A single file might contain multiple QR codes.
+	type scanResult struct {
+		scans []scannedQRData
+		err   error
+	}
+	resultsChan := make(chan scanResult, len(qrCodes))
+	var wg sync.WaitGroup
 	for fileName, qrCode := range qrCodes {
-		g.Go(func() error {
-			// Scan QR code
-			var cborData bytes.Buffer
-			err := utils.RunCommand("scan QR code", bytes.NewReader(qrCode), &cborData, "zbarimg", "--raw", "--oneshot", "--set=binary", "-")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Decode pages. A PDF or TIFF file might contain multiple pages.
+			pages, err := scan.DecodePages(qrCode)
 			if err != nil {
-				slog.Error("failed to scan QR code in file", "file", fileName, "error", err)
-				return fmt.Errorf(`failed to scan QR code in file "%s": %w`, fileName, err)
+				slog.Error("failed to decode file", "file", fileName, "error", err)
+				resultsChan <- scanResult{err: fmt.Errorf(`failed to decode file "%s": %w`, fileName, err)}
+				return
 			}
 
-			// Unmarshal from CBOR
-			var qrData QRData
-			err = cbor.NewDecoder(&cborData).Decode(&qrData)
-			if err != nil {
-				slog.Error("failed to decode data as CBOR", "file", fileName, "error", err)
-				return fmt.Errorf(`failed to decode data from file "%s" as CBOR: %w`, fileName, err)
+			var output scanResult
+			var recognizedText []string
+			for _, page := range pages {
+				pageName := fileName
+				if page.Name != "" {
+					pageName = fmt.Sprintf("%s (%s)", fileName, page.Name)
+				}
+
+				// Scan QR codes
+				results, failures, err := qrcode.DecodeAll(page.Image)
+				if err != nil {
+					// Page might be a scan of the text fallback or QR codes might be smudged
+					text, ocrErr := recognizeText(page.Image)
+					if ocrErr == nil && text != "" {
+						slog.Info("No QR codes found, recovering from printed text instead", "file", pageName, "error", err)
+						recognizedText = append(recognizedText, text)
+						continue
+					}
+					slog.Error("failed to scan QR code in file", "file", pageName, "error", errors.Join(err, ocrErr))
+					output.err = errors.Join(output.err, fmt.Errorf(`failed to scan QR code in file "%s": %w`, pageName, err))
+					continue
+				}
+
+				for _, failure := range failures {
+					slog.Warn("Found QR code which could not be decoded, other QR codes in file are used", "file", pageName, "error", failure)
+				}
+
+				// Unmarshal from CBOR
+				for _, result := range results {
+					if len(result.CorrectedCodewords) > 0 {
+						slog.Warn("QR code is damaged, but could be corrected", "file", pageName, "corrected_codewords", result.CorrectedCodewords)
+					}
+					var qrData QRData
+					err = cbor.Unmarshal(result.Data, &qrData)
+					if err != nil {
+						slog.Error("failed to decode data as CBOR", "file", pageName, "error", err)
+						output.err = errors.Join(output.err, fmt.Errorf(`failed to decode data from file "%s" as CBOR: %w`, pageName, err))
+						continue
+					}
+					output.scans = append(output.scans, scannedQRData{fileName: pageName, qrData: qrData})
+				}
 			}
-			qrDatasChan <- qrData
-			return nil
-		})
-	}
-	if err := g.Wait(); err != nil {
-		close(qrDatasChan)
-		return nil, errors.New("failed to scan and decode one or more QR codes")
+
+			// Parse recognized text. Text of all pages is combined, as a QR code might continue on the next page.
+			if len(recognizedText) > 0 {
+				textScans, err := parseTexts(map[string][]byte{fileName + " (OCR)": []byte(strings.Join(recognizedText, "\n"))})
+				output.scans = append(output.scans, textScans...)
+				output.err = errors.Join(output.err, err)
+			}
+			resultsChan <- output
+		}()
 	}
+	wg.Wait()
+	close(resultsChan)
 
 	// Collect results
-	close(qrDatasChan)
-	qrDatas := make([]QRData, 0, len(qrCodes))
-	for qrData := range qrDatasChan {
-		qrDatas = append(qrDatas, qrData)
+	scans := make([]scannedQRData, 0, len(qrCodes))
+	var errs []error
+	for result := range resultsChan {
+		if result.err != nil {
+			errs = append(errs, result.err)
+		}
+		scans = append(scans, result.scans...)
+	}
+	if len(errs) > 0 {
+		return scans, fmt.Errorf("failed to scan and decode %d QR codes: %w", len(errs), errors.Join(errs...))
+	}
+	return scans, nil
+}
+
+// parseTexts parses the text files of the text fallback. Returns the pages which could be parsed,
+// together with an error which points at the invalid lines.
+func parseTexts(texts map[string][]byte) ([]scannedQRData, error) {
+	var scans []scannedQRData
+	var errs []error
+	for fileName, text := range texts {
+		// Parse text
+		payloads, err := parseText(string(text))
+		if err != nil {
+			slog.Error("failed to parse text file", "file", fileName, "error", err)
+			errs = append(errs, fmt.Errorf(`failed to parse text file "%s": %w`, fileName, err))
+		}
+
+		// Unmarshal from CBOR
+		for _, payload := range payloads {
+			name := fmt.Sprintf("%s (%s)", fileName, payload.name)
+			var qrData QRData
+			err = cbor.Unmarshal(payload.payload, &qrData)
+			if err != nil {
+				slog.Error("failed to decode text as CBOR", "file", name, "error", err)
+				errs = append(errs, fmt.Errorf(`failed to decode text from file "%s" as CBOR: %w`, name, err))
+				continue
+			}
+			scans = append(scans, scannedQRData{fileName: name, qrData: qrData})
+		}
+	}
+	if len(errs) > 0 {
+		return scans, fmt.Errorf("failed to parse text files: %w", errors.Join(errs...))
 	}
-	return qrDatas, nil
+	return scans, nil
 }
@@ -0,0 +1,490 @@
+package encode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/JenswBE/encrypted-paper/compress"
+	"github.com/JenswBE/encrypted-paper/encrypt"
+	"github.com/JenswBE/encrypted-paper/qrcode"
+)
+
+func TestValidateHeaderCompletesLegacyHeader(t *testing.T) {
+	// Validate header
+	header := &QRHeader{Salt: make([]byte, encrypt.SaltSizeBytes), PageCount: 1}
+	err := validateHeader(header)
+	require.NoError(t, err)
+
+	// Validate result
+	require.Equal(t, compress.AlgorithmXZ, header.Compression)
+	require.Equal(t, encrypt.KDFArgon2id, header.KDFAlgo)
+	require.Equal(t, encrypt.AEADXChaCha20Poly1305, header.AEAD)
+	require.Equal(t, encrypt.DefaultKDFParams, *header.KDF)
+}
+
+func TestValidateHeaderRejectsUnknownVersionAndAlgorithms(t *testing.T) {
+	validHeader := func() *QRHeader {
+		return &QRHeader{
+			Version:     FormatVersion,
+			Compression: compress.AlgorithmXZ,
+			KDFAlgo:     encrypt.KDFArgon2id,
+			AEAD:        encrypt.AEADXChaCha20Poly1305,
+			Salt:        make([]byte, encrypt.SaltSizeBytes),
+			PageCount:   1,
+		}
+	}
+	require.NoError(t, validateHeader(validHeader()))
+
+	header := validHeader()
+	header.Version = FormatVersion + 1
+	require.ErrorContains(t, validateHeader(header), "please use a newer version")
+
+	header = validHeader()
+	header.Compression = 0
+	require.ErrorContains(t, validateHeader(header), "compression algorithm")
+
+	header = validHeader()
+	header.KDFAlgo = 99
+	require.ErrorContains(t, validateHeader(header), "KDF algorithm")
+
+	header = validHeader()
+	header.AEAD = 99
+	require.ErrorContains(t, validateHeader(header), "AEAD algorithm")
+
+	header = validHeader()
+	header.KDF = &encrypt.KDFParams{Time: 1, Memory: math.MaxUint32, Threads: 4, KeyLength: 32}
+	require.ErrorContains(t, validateHeader(header), "invalid KDF parameters")
+
+	header = validHeader()
+	header.KDF = &encrypt.KDFParams{Time: 1, Memory: encrypt.MaxKDFMemory + 1, Threads: 4, KeyLength: 32}
+	require.ErrorContains(t, validateHeader(header), "invalid KDF parameters")
+}
+
+func TestAssociatedDataSurvivesCBORRoundtrip(t *testing.T) {
+	// Build header
+	header := QRHeader{
+		Version:     FormatVersion,
+		Compression: compress.AlgorithmXZ,
+		KDFAlgo:     encrypt.KDFArgon2id,
+		AEAD:        encrypt.AEADXChaCha20Poly1305,
+		Salt:        make([]byte, encrypt.SaltSizeBytes),
+		PageCount:   3,
+		KDF:         &encrypt.DefaultKDFParams,
+	}
+	associatedData, err := header.AssociatedData()
+	require.NoError(t, err)
+	require.NotEmpty(t, associatedData)
+
+	// Roundtrip through CBOR like a printed sheet
+	encoded, err := cbor.Marshal(QRData{Header: &header, PageNumber: 1})
+	require.NoError(t, err)
+	var decoded QRData
+	require.NoError(t, cbor.Unmarshal(encoded, &decoded))
+	decodedAssociatedData, err := decoded.Header.AssociatedData()
+	require.NoError(t, err)
+	require.Equal(t, associatedData, decodedAssociatedData)
+
+	// Tampered header yields different associated data
+	decoded.Header.PageCount = 2
+	tamperedAssociatedData, err := decoded.Header.AssociatedData()
+	require.NoError(t, err)
+	require.NotEqual(t, associatedData, tamperedAssociatedData)
+
+	// Legacy headers have no associated data
+	header.Version = 0
+	legacyAssociatedData, err := header.AssociatedData()
+	require.NoError(t, err)
+	require.Nil(t, legacyAssociatedData)
+}
+
+func buildTestQRData(t *testing.T, dataSize, parityPages uint) ([]byte, []QRData) {
+	t.Helper()
+
+	// Generate data
+	data := make([]byte, dataSize)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	// Build header
+	header := QRHeader{
+		Version:     FormatVersion,
+		Compression: compress.AlgorithmXZ,
+		KDFAlgo:     encrypt.KDFArgon2id,
+		AEAD:        encrypt.AEADXChaCha20Poly1305,
+		Salt:        make([]byte, encrypt.SaltSizeBytes),
+		KDF:         &encrypt.DefaultKDFParams,
+		ParityPages: uint32(parityPages),
+	}
+	pageCount, err := CalcPageCount(header, qrcode.ECCLevelL, dataSize, MaxPageCount)
+	require.NoError(t, err)
+	header.PageCount = uint32(pageCount)
+	if parityPages > 0 {
+		header.DataSize = uint32(dataSize)
+	}
+
+	// Split data
+	qrDatas, err := splitQRData(header, qrcode.ECCLevelL, DocumentID("testdoc1"), data)
+	require.NoError(t, err)
+	require.Len(t, qrDatas, int(pageCount))
+	return data, qrDatas
+}
+
+func TestCombinePayloads(t *testing.T) {
+	data, qrDatas := buildTestQRData(t, 3*MaxQRCodeCapacity, 2)
+	header := *qrDatas[0].Header
+	qrCodes, err := NewQRCodes(header, qrcode.ECCLevelL, DocumentID("testdoc1"), bytes.NewReader(data), uint(len(data)))
+	require.NoError(t, err)
+
+	// Combine without the first 2 data pages
+	var payloads [][]byte
+	for i := 2; i < qrCodes.Len(); i++ {
+		payload, err := qrCodes.Payload(i)
+		require.NoError(t, err)
+		payloads = append(payloads, payload)
+	}
+	combined, err := CombinePayloads(payloads)
+	require.NoError(t, err)
+	require.Equal(t, data, combined.Data)
+	require.Equal(t, DocumentID("testdoc1"), combined.DocumentID)
+
+	// Invalid payload
+	_, err = CombinePayloads(append(payloads, []byte{0xff}))
+	require.ErrorContains(t, err, "failed to decode payload")
+}
+
+func TestCombineQRDataWithoutParity(t *testing.T) {
+	data, qrDatas := buildTestQRData(t, 3*MaxQRCodeCapacity, 0)
+
+	// Combine all pages
+	combined, _, err := combineQRData(qrDatas)
+	require.NoError(t, err)
+	require.Equal(t, data, combined)
+
+	// Combine with missing page
+	_, _, err = combineQRData(slices.Delete(slices.Clone(qrDatas), 1, 2))
+	require.ErrorContains(t, err, "page 2 is missing")
+}
+
+func TestCombineQRDataReconstructsMissingPages(t *testing.T) {
+	data, qrDatas := buildTestQRData(t, 5*MaxQRCodeCapacity+100, 2)
+
+	// Combine all pages
+	combined, header, err := combineQRData(slices.Clone(qrDatas))
+	require.NoError(t, err)
+	require.Equal(t, data, combined)
+	require.Equal(t, uint32(2), header.ParityPages)
+
+	// Combine without first page, which contains the header
+	combined, _, err = combineQRData(slices.Clone(qrDatas[1:]))
+	require.NoError(t, err)
+	require.Equal(t, data, combined)
+
+	// Combine without last data page and one parity page
+	lastDataPage := int(header.DataPageCount()) - 1
+	pages := slices.Delete(slices.Clone(qrDatas), lastDataPage, lastDataPage+2)
+	combined, _, err = combineQRData(pages)
+	require.NoError(t, err)
+	require.Equal(t, data, combined)
+
+	// Combine with too many missing pages
+	_, _, err = combineQRData(slices.Clone(qrDatas[3:]))
+	require.ErrorContains(t, err, "3 pages are missing")
+}
+
+func TestCombineQRDataBindsPageLayoutOfStream(t *testing.T) {
+	// Without parity: add a page without data
+	data, qrDatas := buildTestQRData(t, 3*MaxQRCodeCapacity, 0)
+	qrDatas[0].Header.AEAD = encrypt.AEADXChaCha20Poly1305Stream // Header is shared by all pages
+	combined, _, err := combineQRData(slices.Clone(qrDatas))
+	require.NoError(t, err)
+	require.Equal(t, data, combined)
+	qrDatas[0].Header.PageCount++
+	emptyChecksum := crc32.ChecksumIEEE(nil)
+	_, _, err = combineQRData(append(slices.Clone(qrDatas), QRData{PageNumber: qrDatas[0].Header.PageCount, Data: []byte{}, CRC32: &emptyChecksum}))
+	require.ErrorContains(t, err, "page 4 contains")
+
+	// With parity: reconstruct missing pages, but reject a data size beyond the last data page
+	data, qrDatas = buildTestQRData(t, 5*MaxQRCodeCapacity+100, 2)
+	header := qrDatas[0].Header
+	header.AEAD = encrypt.AEADXChaCha20Poly1305Stream
+	combined, _, err = combineQRData(slices.Clone(qrDatas[2:]))
+	require.NoError(t, err)
+	require.Equal(t, data, combined)
+	header.DataSize += uint32(len(qrDatas[0].Data))
+	_, _, err = combineQRData(slices.Clone(qrDatas))
+	require.ErrorContains(t, err, "doesn't end in the last data page")
+}
+
+func TestCombineQRDataFromMultipleShareSets(t *testing.T) {
+	// Build sets which only differ in key share
+	data, qrDatas := buildTestQRData(t, 3*MaxQRCodeCapacity, 0)
+	shares, err := encrypt.SplitKey(make([]byte, 32), 3, 2)
+	require.NoError(t, err)
+	sets := make([][]QRData, len(shares))
+	for i := range shares {
+		header := *qrDatas[0].Header
+		header.KDFAlgo = encrypt.KDFShamir
+		header.Share = &shares[i]
+		sets[i] = slices.Clone(qrDatas)
+		sets[i][0].Header = &header
+	}
+
+	// Combine first page of set 3 with all pages of set 1
+	pages := append(slices.Clone(sets[0]), sets[2][0])
+	combined, _, err := combineQRData(pages)
+	require.NoError(t, err)
+	require.Equal(t, data, combined)
+	keyShares, err := collectKeyShares(pages)
+	require.NoError(t, err)
+	require.Equal(t, []encrypt.KeyShare{shares[0], shares[2]}, keyShares)
+
+	// Only a single share
+	_, err = collectKeyShares(sets[1])
+	require.ErrorContains(t, err, "2 distinct key shares are required")
+
+	// Duplicate page with different data
+	modifiedPage := sets[1][1]
+	modifiedPage.Data = []byte("modified")
+	modifiedChecksum := crc32.ChecksumIEEE(modifiedPage.Data)
+	modifiedPage.CRC32 = &modifiedChecksum
+	_, _, err = combineQRData(append(slices.Clone(sets[0]), modifiedPage))
+	require.ErrorContains(t, err, "page 2 is provided multiple times with different data")
+}
+
+func TestPageWriterLimitsDataSize(t *testing.T) {
+	// Build header
+	header := QRHeader{
+		Version:     FormatVersion,
+		Compression: compress.AlgorithmXZ,
+		KDFAlgo:     encrypt.KDFArgon2id,
+		AEAD:        encrypt.AEADXChaCha20Poly1305Stream,
+		Salt:        make([]byte, encrypt.SaltSizeBytes),
+		KDF:         &encrypt.DefaultKDFParams,
+	}
+
+	// Fill 2 pages
+	dir := t.TempDir()
+	writer, err := NewPageWriter(dir, header, qrcode.ECCLevelL, 2)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, writer.Close())
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Empty(t, entries, "temporary file should be removed")
+	}()
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "temporary file should be created in provided directory")
+	maxSize, err := MaxDataSize(header, qrcode.ECCLevelL, 2)
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte{7}, int(maxSize))
+	_, err = writer.Write(data)
+	require.NoError(t, err)
+	require.Equal(t, maxSize, writer.Size())
+	dataHash := sha256.Sum256(data)
+	require.Equal(t, dataHash[:], writer.Hash())
+	written, err := io.ReadAll(writer.Data())
+	require.NoError(t, err)
+	require.Equal(t, data, written)
+	pageCount, err := CalcPageCount(header, qrcode.ECCLevelL, maxSize, 2)
+	require.NoError(t, err)
+	require.Equal(t, uint(2), pageCount)
+
+	// Exceed 2 pages
+	_, err = writer.Write([]byte{1})
+	require.ErrorContains(t, err, "data exceeds the maximum")
+}
+
+func TestQRDataDecodesLegacyPageNumbers(t *testing.T) {
+	// Page metadata as written by builds with 8-bit page numbers
+	type legacyQRData struct {
+		PageNumber uint8  `json:"page_number"`
+		Data       []byte `json:"data"`
+	}
+	encoded, err := cbor.Marshal(legacyQRData{PageNumber: 200, Data: []byte("data")})
+	require.NoError(t, err)
+
+	var decoded QRData
+	require.NoError(t, cbor.Unmarshal(encoded, &decoded))
+	require.Equal(t, uint32(200), decoded.PageNumber)
+	require.Equal(t, []byte("data"), decoded.Data)
+}
+
+func TestCombineQRDataReconstructsMoreThan256Pages(t *testing.T) {
+	data, qrDatas := buildTestQRData(t, 300*MaxQRCodeCapacity, 4)
+	require.Greater(t, len(qrDatas), 256)
+
+	// Combine without 4 data pages
+	pages := slices.Delete(slices.Clone(qrDatas), 100, 104)
+	combined, _, err := combineQRData(pages)
+	require.NoError(t, err)
+	require.Equal(t, data, combined)
+}
+
+func TestCalcPageCountLimits(t *testing.T) {
+	header := QRHeader{
+		Version:     FormatVersion,
+		Compression: compress.AlgorithmXZ,
+		KDFAlgo:     encrypt.KDFArgon2id,
+		AEAD:        encrypt.AEADXChaCha20Poly1305Stream,
+		Salt:        make([]byte, encrypt.SaltSizeBytes),
+		KDF:         &encrypt.DefaultKDFParams,
+	}
+
+	// Max output pages of 0 only limits by MaxPageCount
+	pageCount, err := CalcPageCount(header, qrcode.ECCLevelL, 1000*MaxQRCodeCapacity, 0)
+	require.NoError(t, err)
+	require.Greater(t, pageCount, uint(1000))
+
+	// Exceed max output pages
+	_, err = CalcPageCount(header, qrcode.ECCLevelL, 1000*MaxQRCodeCapacity, 10)
+	require.ErrorContains(t, err, "more than configured maximum of 10")
+
+	// Exceed MaxPageCount
+	_, err = CalcPageCount(header, qrcode.ECCLevelL, (MaxPageCount+1)*MaxQRCodeCapacity, 0)
+	require.ErrorContains(t, err, "maximum supported page count")
+}
+
+func TestGenerateQRCodesWithECCLevel(t *testing.T) {
+	data := make([]byte, 5*MaxQRCodeCapacity)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	header := QRHeader{
+		Version:     FormatVersion,
+		Compression: compress.AlgorithmXZ,
+		KDFAlgo:     encrypt.KDFArgon2id,
+		AEAD:        encrypt.AEADXChaCha20Poly1305Stream,
+		Salt:        make([]byte, encrypt.SaltSizeBytes),
+		KDF:         &encrypt.DefaultKDFParams,
+	}
+
+	// Higher levels require more pages
+	pageCountL, err := CalcPageCount(header, qrcode.ECCLevelL, uint(len(data)), 0)
+	require.NoError(t, err)
+	pageCountH, err := CalcPageCount(header, qrcode.ECCLevelH, uint(len(data)), 0)
+	require.NoError(t, err)
+	require.Equal(t, uint(6), pageCountL)
+	require.Equal(t, uint(13), pageCountH)
+
+	// Generate and scan QR codes with level H
+	header.PageCount = uint32(pageCountH)
+	qrCodes, err := GenerateQRCodes(header, qrcode.ECCLevelH, DocumentID("testdoc1"), data)
+	require.NoError(t, err)
+	require.Len(t, qrCodes, int(pageCountH))
+	qrCodesMap := make(map[string][]byte, len(qrCodes))
+	for i, qrCode := range qrCodes {
+		img, err := png.Decode(bytes.NewReader(qrCode.PNG))
+		require.NoError(t, err)
+		result, err := qrcode.Decode(img)
+		require.NoError(t, err)
+		require.Equal(t, qrcode.ECCLevelH, result.Level)
+		qrCodesMap[fmt.Sprintf("page-%d.png", i+1)] = qrCode.PNG
+	}
+	payload, err := ScanAndCombineQRCodes(qrCodesMap, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, data, payload.Data)
+}
+
+func TestCombineQRDataDetectsCorruptedPages(t *testing.T) {
+	data, qrDatas := buildTestQRData(t, 3*MaxQRCodeCapacity, 0)
+	dataHash := sha256.Sum256(data)
+	header := *qrDatas[0].Header
+	header.DataHash = dataHash[:]
+	qrDatas[0].Header = &header
+
+	// Combine all pages
+	combined, _, err := combineQRData(slices.Clone(qrDatas))
+	require.NoError(t, err)
+	require.Equal(t, data, combined)
+
+	// Corrupted page is detected by its checksum
+	corrupted := slices.Clone(qrDatas)
+	corrupted[1].Data = slices.Clone(corrupted[1].Data)
+	corrupted[1].Data[10] ^= 0xff
+	_, _, err = combineQRData(corrupted)
+	require.ErrorContains(t, err, "page 2 data is corrupted")
+
+	// Corrupted page without checksum is detected by the data hash
+	corrupted[1].CRC32 = nil
+	_, _, err = combineQRData(corrupted)
+	require.ErrorContains(t, err, "doesn't match the data hash")
+}
+
+func TestCombineQRDataReconstructsCorruptedPages(t *testing.T) {
+	data, qrDatas := buildTestQRData(t, 5*MaxQRCodeCapacity, 1)
+
+	// Corrupted page is recovered using parity pages
+	qrDatas[2].Data = slices.Clone(qrDatas[2].Data)
+	qrDatas[2].Data[0] ^= 0xff
+	combined, _, err := combineQRData(qrDatas)
+	require.NoError(t, err)
+	require.Equal(t, data, combined)
+}
+
+// combineImages draws the PNG images side by side into a single PNG image, like a scan of multiple sheets
+func combineImages(t *testing.T, pngs ...[]byte) []byte {
+	t.Helper()
+	var images []image.Image
+	width, height := 0, 0
+	for _, data := range pngs {
+		img, err := png.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+		images = append(images, img)
+		width += img.Bounds().Dx()
+		height = max(height, img.Bounds().Dy())
+	}
+	output := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(output, output.Bounds(), image.White, image.Point{}, draw.Src)
+	x := 0
+	for _, img := range images {
+		draw.Draw(output, img.Bounds().Add(image.Pt(x, 0)), img, img.Bounds().Min, draw.Src)
+		x += img.Bounds().Dx()
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, output))
+	return buf.Bytes()
+}
+
+func TestScanAndCombineQRCodesWithMultipleQRCodesPerImage(t *testing.T) {
+	data, qrDatas := buildTestQRData(t, 2*MaxQRCodeCapacity, 0)
+	header := *qrDatas[0].Header
+	documentID := qrDatas[0].DocumentID
+	qrCodes, err := GenerateQRCodes(header, qrcode.ECCLevelL, documentID, data)
+	require.NoError(t, err)
+	require.Len(t, qrCodes, 3)
+
+	// Overlapping scans
+	payload, err := ScanAndCombineQRCodes(map[string][]byte{
+		"scan-1.png": combineImages(t, qrCodes[0].PNG, qrCodes[1].PNG),
+		"scan-2.png": combineImages(t, qrCodes[1].PNG, qrCodes[2].PNG),
+	}, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, data, payload.Data)
+
+	// Duplicate page with different data
+	otherData := slices.Clone(data)
+	otherData[len(otherData)-1] ^= 0xff
+	otherQRCodes, err := GenerateQRCodes(header, qrcode.ECCLevelL, documentID, otherData)
+	require.NoError(t, err)
+	_, err = ScanAndCombineQRCodes(map[string][]byte{
+		"scan-1.png": combineImages(t, qrCodes[0].PNG, qrCodes[1].PNG),
+		"scan-2.png": combineImages(t, qrCodes[2].PNG),
+		"scan-3.png": combineImages(t, otherQRCodes[2].PNG),
+	}, nil, nil)
+	require.ErrorContains(t, err, `page 3 is scanned multiple times with different data, in file "scan-2.png" and in file "scan-3.png"`)
+}
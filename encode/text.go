@@ -0,0 +1,186 @@
+package encode
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Text fallback prints the payload of each QR code as numbered lines of Base32, e.g.
+//
+//	QR code 3 of 12 (148 lines)
+//	001: ABCDEFGH IJKLMNOP QRSTUVWX YZ234567 - K7Q2
+//
+// Each line ends with a checksum over the line number and the data of the line,
+// so typos can be located to a single line.
+const (
+	textLineSize     = 20 // Bytes of payload on each line
+	textGroupSize    = 8  // Characters in each group of Base32
+	textChecksumSize = 4  // Characters of the line checksum
+)
+
+// maxTextLines is the number of lines of the largest QR code. Larger line numbers or counts are typos.
+const maxTextLines = (MaxQRCodeCapacity + textLineSize - 1) / textLineSize
+
+var textEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+var (
+	textHeadingRegexp = regexp.MustCompile(`^QR\s*CODE\s*(\d+)(?:\s*OF\s*\d+)?(?:\s*\(\s*(\d+)\s*LINES?\s*\))?$`)
+	textLineRegexp    = regexp.MustCompile(`^(\d+)\s*[:;.,]\s*([A-Z0-9 ]+?)\s*-\s*([A-Z0-9 ]+)$`)
+)
+
+// TextHeading returns the heading above the text lines of a QR code
+func TextHeading(qrCodeNumber, qrCodeCount, lineCount int) string {
+	return fmt.Sprintf("QR code %d of %d (%d lines)", qrCodeNumber, qrCodeCount, lineCount)
+}
+
+// TextLines returns the payload of a QR code as numbered lines of Base32 with checksum
+func TextLines(payload []byte) []string {
+	lines := make([]string, 0, (len(payload)+textLineSize-1)/textLineSize)
+	for chunk := range slices.Chunk(payload, textLineSize) {
+		lineNumber := len(lines) + 1
+		encoded := textEncoding.EncodeToString(chunk)
+		groups := make([]string, 0, (len(encoded)+textGroupSize-1)/textGroupSize)
+		for group := range slices.Chunk([]byte(encoded), textGroupSize) {
+			groups = append(groups, string(group))
+		}
+		lines = append(lines, fmt.Sprintf("%03d: %s - %s", lineNumber, strings.Join(groups, " "), textChecksum(lineNumber, chunk)))
+	}
+	return lines
+}
+
+// textChecksum returns the checksum of a line. Line number is included to detect swapped or skipped lines.
+func textChecksum(lineNumber int, data []byte) string {
+	hash := crc32.NewIEEE()
+	_, _ = hash.Write(binary.BigEndian.AppendUint32(nil, uint32(lineNumber)))
+	_, _ = hash.Write(data)
+	return textEncoding.EncodeToString(hash.Sum(nil))[:textChecksumSize]
+}
+
+// textPayload is the payload of a single QR code, parsed from text
+type textPayload struct {
+	name    string // Identifies the QR code within the text, e.g. "QR code 3"
+	payload []byte
+}
+
+// textBlock collects the lines of a single QR code while parsing
+type textBlock struct {
+	name      string
+	lineCount int // As stated in the heading. 0 if unknown.
+	lines     map[int][]byte
+	invalid   map[int]bool // Line numbers of invalid lines, which are reported separately
+	errs      []error
+}
+
+// parseText parses the lines of one or more QR codes, as printed by the text fallback.
+// Text might be typed or OCR'd, so case, whitespace and the digits 0, 1 and 8 instead of O, I and B are tolerated.
+// Returns the QR codes which could be parsed, together with an error which points at the invalid lines.
+func parseText(text string) ([]textPayload, error) {
+	// Parse lines
+	var blocks []*textBlock
+	var current *textBlock
+	newBlock := func(name string) {
+		current = &textBlock{name: name, lines: make(map[int][]byte), invalid: make(map[int]bool)}
+		blocks = append(blocks, current)
+	}
+	for i, rawLine := range strings.Split(text, "\n") {
+		textLineNumber := i + 1 // 1 for zero indexed
+		line := strings.ToUpper(strings.TrimSpace(rawLine))
+		if line == "" {
+			continue
+		}
+
+		// QR code heading starts a new block
+		if match := textHeadingRegexp.FindStringSubmatch(line); match != nil {
+			newBlock("QR code " + match[1])
+			current.lineCount, _ = strconv.Atoi(match[2]) // Optional
+			if current.lineCount > maxTextLines {
+				current.errs = append(current.errs, fmt.Errorf("text line %d: heading states %d lines, but a QR code has at most %d lines, please check for typos", textLineNumber, current.lineCount, maxTextLines))
+				current.lineCount = 0 // Fall back to the largest line number
+			}
+			continue
+		}
+
+		// Parse data line
+		match := textLineRegexp.FindStringSubmatch(line)
+		if match == nil {
+			slog.Warn("Ignoring text line which is neither a heading nor a data line", "line", textLineNumber, "text", rawLine)
+			continue
+		}
+		lineNumber, err := strconv.Atoi(match[1])
+		if err != nil {
+			slog.Warn("Ignoring text line with invalid line number", "line", textLineNumber, "text", rawLine)
+			continue
+		}
+		if current == nil || (lineNumber == 1 && len(current.lines)+len(current.invalid) > 0) {
+			// Headings are optional when typing, so the first line also starts a new block
+			newBlock(fmt.Sprintf("text block %d", len(blocks)+1))
+		}
+		location := fmt.Sprintf("text line %d (line %03d of %s)", textLineNumber, lineNumber, current.name)
+		if lineNumber < 1 || lineNumber > maxTextLines {
+			current.errs = append(current.errs, fmt.Errorf("%s: line number must be between 1 and %d, please check for typos", location, maxTextLines))
+			continue
+		}
+		if _, ok := current.lines[lineNumber]; ok || current.invalid[lineNumber] {
+			current.errs = append(current.errs, fmt.Errorf("%s: line number is duplicated", location))
+			continue
+		}
+		data, err := textEncoding.DecodeString(normalizeText(match[2]))
+		if err != nil {
+			current.invalid[lineNumber] = true
+			current.errs = append(current.errs, fmt.Errorf("%s: invalid characters, please check for typos: %w", location, err))
+			continue
+		}
+		if textChecksum(lineNumber, data) != normalizeText(match[3]) {
+			current.invalid[lineNumber] = true
+			current.errs = append(current.errs, fmt.Errorf("%s: checksum mismatch, please check for typos", location))
+			continue
+		}
+		current.lines[lineNumber] = data
+	}
+
+	// Combine lines of each block
+	var payloads []textPayload
+	var errs []error
+	for _, block := range blocks {
+		lineCount := block.lineCount
+		switch {
+		case lineCount == 0 && len(block.lines)+len(block.invalid) == 0:
+			errs = append(errs, fmt.Errorf("%s doesn't contain any lines", block.name))
+			continue
+		case lineCount == 0:
+			lineCount = slices.Max(append(slices.Collect(maps.Keys(block.lines)), slices.Collect(maps.Keys(block.invalid))...))
+		}
+		for lineNumber := 1; lineNumber <= lineCount; lineNumber++ {
+			if _, ok := block.lines[lineNumber]; !ok && !block.invalid[lineNumber] {
+				block.errs = append(block.errs, fmt.Errorf("line %03d of %s is missing", lineNumber, block.name))
+			}
+		}
+		if len(block.errs) > 0 {
+			errs = append(errs, block.errs...)
+			continue
+		}
+		var payload []byte
+		for lineNumber := 1; lineNumber <= lineCount; lineNumber++ {
+			payload = append(payload, block.lines[lineNumber]...)
+		}
+		payloads = append(payloads, textPayload{name: block.name, payload: payload})
+	}
+	if len(errs) > 0 {
+		return payloads, errors.Join(errs...)
+	}
+	return payloads, nil
+}
+
+// normalizeText removes whitespace and replaces digits which are not used by Base32 with similar looking letters
+func normalizeText(text string) string {
+	return strings.NewReplacer(" ", "", "\t", "", "0", "O", "1", "I", "8", "B").Replace(text)
+}
@@ -0,0 +1,86 @@
+package encode
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/JenswBE/encrypted-paper/qrcode"
+)
+
+func TestParseTextRoundtrip(t *testing.T) {
+	payload := make([]byte, 3*textLineSize+7)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	lines := TextLines(payload)
+	require.Len(t, lines, 4)
+	require.Regexp(t, `^001: [A-Z2-7]{8} [A-Z2-7]{8} [A-Z2-7]{8} [A-Z2-7]{8} - [A-Z2-7]{4}$`, lines[0])
+
+	// With heading
+	text := TextHeading(3, 12, len(lines)) + "\n" + strings.Join(lines, "\n")
+	payloads, err := parseText(text)
+	require.NoError(t, err)
+	require.Equal(t, []textPayload{{name: "QR code 3", payload: payload}}, payloads)
+
+	// Typed without headings, in lowercase, with 0 instead of O and other whitespace
+	typed := strings.NewReplacer("O", "0", " ", "  ").Replace(strings.ToLower(strings.Join(append(lines, lines...), "\r\n")))
+	payloads, err = parseText(typed)
+	require.NoError(t, err)
+	require.Equal(t, []textPayload{{name: "text block 1", payload: payload}, {name: "text block 2", payload: payload}}, payloads)
+}
+
+func TestParseTextPointsAtTypos(t *testing.T) {
+	payload := make([]byte, 5*textLineSize)
+	lines := TextLines(payload)
+	lines[2] = strings.Replace(lines[2], "AAAA", "AABA", 1)
+	lines = append(lines[:4], lines[5:]...) // Remove last line
+	text := "Some title\n" + TextHeading(1, 1, 5) + "\n" + strings.Join(lines, "\n")
+
+	_, err := parseText(text)
+	require.ErrorContains(t, err, "text line 5 (line 003 of QR code 1): checksum mismatch")
+	require.ErrorContains(t, err, "line 005 of QR code 1 is missing")
+	require.NotContains(t, err.Error(), "line 003 of QR code 1 is missing")
+
+	// Swapped lines are detected, as the line number is part of the checksum
+	lines = TextLines(payload)
+	lines[0], lines[1] = "001"+lines[1][3:], "002"+lines[0][3:]
+	_, err = parseText(strings.Join(lines, "\n"))
+	require.ErrorContains(t, err, "text line 1 (line 001 of text block 1): checksum mismatch")
+
+	// Typo in the line count or line number is reported once, instead of a missing error for each line
+	lines = TextLines(payload)
+	lines[4] = "99999999" + lines[4][3:]
+	_, err = parseText("QR code 1 (99999999 lines)\n" + strings.Join(lines, "\n"))
+	require.ErrorContains(t, err, "text line 1: heading states 99999999 lines")
+	require.ErrorContains(t, err, "text line 6 (line 99999999 of QR code 1): line number must be between 1 and")
+	require.Len(t, strings.Split(err.Error(), "\n"), 2)
+}
+
+func TestScanAndCombineQRCodesWithText(t *testing.T) {
+	data, qrDatas := buildTestQRData(t, 3*MaxQRCodeCapacity, 0)
+	qrCodes, err := GenerateQRCodes(*qrDatas[0].Header, qrcode.ECCLevelL, qrDatas[0].DocumentID, data)
+	require.NoError(t, err)
+	require.Len(t, qrCodes, 4)
+
+	// Replace a damaged QR code by its text
+	lines := TextLines(qrCodes[2].Payload)
+	text := TextHeading(3, len(qrCodes), len(lines)) + "\n" + strings.Join(lines, "\n")
+	scans := make(map[string][]byte)
+	for i, qrCode := range qrCodes {
+		if i != 2 {
+			scans[fmt.Sprintf("scan-%d.png", i+1)] = qrCode.PNG
+		}
+	}
+	payload, err := ScanAndCombineQRCodes(scans, map[string][]byte{"page-3.txt": []byte(text)}, nil)
+	require.NoError(t, err)
+	require.Equal(t, data, payload.Data)
+
+	// Text with typo
+	text = strings.Replace(text, "\n001: ", "\n001: A", 1)
+	_, err = ScanAndCombineQRCodes(scans, map[string][]byte{"page-3.txt": []byte(text)}, nil)
+	require.ErrorContains(t, err, `failed to parse text file "page-3.txt"`)
+	require.ErrorContains(t, err, "text line 2 (line 001 of QR code 3)")
+}
@@ -0,0 +1,78 @@
+package encode
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/JenswBE/encrypted-paper/qrcode"
+)
+
+// PageWriter collects the data for the pages in a temporary file, so the data doesn't have to fit in memory.
+// Writes fail as soon as the data doesn't fit anymore in the maximum number of pages, to stop processing large inputs early.
+// Close must be called to remove the temporary file.
+type PageWriter struct {
+	file     *os.File
+	hash     hash.Hash
+	size     uint
+	maxSize  uint
+	maxPages uint
+}
+
+// NewPageWriter returns a writer for data which should fit in maxPages pages. Set maxPages to 0 to only limit by MaxPageCount.
+// Header must be complete, except for the page count and data size.
+// The temporary file is created in dir instead of the default temporary directory,
+// as the latter doesn't exist in the container image (built from scratch).
+func NewPageWriter(dir string, header QRHeader, level qrcode.ECCLevel, maxPages uint) (*PageWriter, error) {
+	if maxPages == 0 {
+		maxPages = MaxPageCount
+	}
+	maxSize, err := MaxDataSize(header, level, maxPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate maximum data size: %w", err)
+	}
+	file, err := os.CreateTemp(dir, ".encrypted-paper-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file for pages: %w", err)
+	}
+	return &PageWriter{file: file, hash: sha256.New(), maxSize: maxSize, maxPages: maxPages}, nil
+}
+
+func (w *PageWriter) Write(p []byte) (int, error) {
+	if w.size+uint(len(p)) > w.maxSize {
+		return 0, fmt.Errorf("data exceeds the maximum of %d bytes which fit in %d pages", w.maxSize, w.maxPages)
+	}
+	n, err := w.file.Write(p)
+	w.hash.Write(p[:n])
+	w.size += uint(n)
+	if err != nil {
+		return n, fmt.Errorf("failed to write temporary file for pages: %w", err)
+	}
+	return n, nil
+}
+
+// Size returns the size of the collected data
+func (w *PageWriter) Size() uint {
+	return w.size
+}
+
+// Hash returns the SHA-256 hash of the collected data
+func (w *PageWriter) Hash() []byte {
+	return w.hash.Sum(nil)
+}
+
+// Data returns a reader for the collected data
+func (w *PageWriter) Data() *io.SectionReader {
+	return io.NewSectionReader(w.file, 0, int64(w.size))
+}
+
+// Close removes the temporary file
+func (w *PageWriter) Close() error {
+	closeErr := w.file.Close()
+	if err := os.Remove(w.file.Name()); err != nil {
+		return fmt.Errorf("failed to remove temporary file for pages: %w", err)
+	}
+	return closeErr
+}
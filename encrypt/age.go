@@ -0,0 +1,74 @@
+package encrypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"filippo.io/age"
+)
+
+// NewAgeWriter returns a writer which encrypts to output as a standard age file (https://age-encryption.org/v1),
+// which can be decrypted with any age implementation. Close must be called to encrypt the last chunk.
+// Also returns an identity which unwraps the file key, to validate the output without the identities of the recipients.
+func NewAgeWriter(output io.Writer, recipients []age.Recipient) (io.WriteCloser, age.Identity, error) {
+	// Record file key
+	recorder := &fileKeyRecorder{}
+	wrappedRecipients := make([]age.Recipient, len(recipients))
+	for i, recipient := range recipients {
+		wrappedRecipients[i] = recordingRecipient{recipient: recipient, recorder: recorder}
+	}
+
+	// Create writer
+	writer, err := age.Encrypt(output, wrappedRecipients...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create age writer: %w", err)
+	}
+	return writer, recorder, nil
+}
+
+// NewAgeReader returns a reader which decrypts a standard age file with one of the provided identities
+func NewAgeReader(input io.Reader, identities []age.Identity) (io.Reader, error) {
+	reader, err := age.Decrypt(input, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt age header: %w", err)
+	}
+	return reader, nil
+}
+
+// NewPasswordRecipient returns an age recipient which wraps the file key with the password using scrypt
+func NewPasswordRecipient(password string) (age.Recipient, error) {
+	if len(password) < MinPasswordLength {
+		return nil, fmt.Errorf("password shorter than minimum length of %d", MinPasswordLength)
+	}
+	return age.NewScryptRecipient(password)
+}
+
+// NewPasswordIdentity returns an age identity which unwraps the file key with the password using scrypt
+func NewPasswordIdentity(password string) (age.Identity, error) {
+	return age.NewScryptIdentity(password)
+}
+
+// recordingRecipient records the file key before wrapping it with the actual recipient
+type recordingRecipient struct {
+	recipient age.Recipient
+	recorder  *fileKeyRecorder
+}
+
+func (r recordingRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	r.recorder.fileKey = bytes.Clone(fileKey)
+	return r.recipient.Wrap(fileKey)
+}
+
+// fileKeyRecorder is an identity which returns the recorded file key for any stanza
+type fileKeyRecorder struct {
+	fileKey []byte
+}
+
+func (r *fileKeyRecorder) Unwrap(_ []*age.Stanza) ([]byte, error) {
+	if r.fileKey == nil {
+		return nil, errors.New("file key was not recorded")
+	}
+	return r.fileKey, nil
+}
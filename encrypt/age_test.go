@@ -0,0 +1,48 @@
+package encrypt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptAndDecryptAge(t *testing.T) {
+	msg := []byte("Very important message")
+	encryptAge := func(recipients []age.Recipient) ([]byte, age.Identity) {
+		var encryptedMsg bytes.Buffer
+		writer, fileKeyIdentity, err := NewAgeWriter(&encryptedMsg, recipients)
+		require.NoError(t, err)
+		_, err = writer.Write(msg)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		return encryptedMsg.Bytes(), fileKeyIdentity
+	}
+	decryptAge := func(encryptedMsg []byte, identity age.Identity) []byte {
+		reader, err := NewAgeReader(bytes.NewReader(encryptedMsg), []age.Identity{identity})
+		require.NoError(t, err)
+		decryptedMsg, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		return decryptedMsg
+	}
+
+	// Encrypt to recipient
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	encryptedMsg, fileKeyIdentity := encryptAge([]age.Recipient{identity.Recipient()})
+	require.Contains(t, string(encryptedMsg), "age-encryption.org/v1")
+
+	// Decrypt with identity and recorded file key
+	require.Equal(t, msg, decryptAge(encryptedMsg, identity))
+	require.Equal(t, msg, decryptAge(encryptedMsg, fileKeyIdentity))
+
+	// Encrypt and decrypt with password
+	recipient, err := NewPasswordRecipient("Correct Horse Battery Staple")
+	require.NoError(t, err)
+	encryptedMsg, _ = encryptAge([]age.Recipient{recipient})
+	passwordIdentity, err := NewPasswordIdentity("Correct Horse Battery Staple")
+	require.NoError(t, err)
+	require.Equal(t, msg, decryptAge(encryptedMsg, passwordIdentity))
+}
@@ -3,8 +3,9 @@ package encrypt
 import (
 	"crypto/cipher"
 	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"errors"
 	"fmt"
-	"log/slog"
 	"strings"
 	"syscall"
 
@@ -19,7 +20,122 @@ const SaltSizeBytes = 16
 // Minimum length for password
 const MinPasswordLength = 8
 
+// ErrAuthenticationFailed is returned if the cipher text can't be decrypted and authenticated.
+// Either the key is wrong, or the cipher text or associated data was modified.
+var ErrAuthenticationFailed = errors.New("message authentication failed")
+
+// KDFAlgorithm identifies how the key is derived, e.g. from the password or from key shares.
+// Values are stored on paper, so existing values must never be changed.
+type KDFAlgorithm uint8
+
+const (
+	KDFArgon2id KDFAlgorithm = 1
+	KDFShamir   KDFAlgorithm = 2 // Random key combined from key shares, see CombineKey
+	KDFX25519   KDFAlgorithm = 3 // Random key wrapped for X25519 recipients, see UnwrapKey
+	KDFAge      KDFAlgorithm = 4 // Key is wrapped inside the age file, see AEADAge
+)
+
+func (a KDFAlgorithm) String() string {
+	switch a {
+	case KDFArgon2id:
+		return "Argon2id"
+	case KDFShamir:
+		return "Shamir's Secret Sharing"
+	case KDFX25519:
+		return "X25519 recipients"
+	case KDFAge:
+		return "age"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint8(a))
+	}
+}
+
+// IsSupported returns true if keys can be derived with this algorithm.
+func (a KDFAlgorithm) IsSupported() bool {
+	return a == KDFArgon2id || a == KDFShamir || a == KDFX25519 || a == KDFAge
+}
+
+// AEADAlgorithm identifies the authenticated encryption algorithm used to encrypt the payload.
+// Values are stored on paper, so existing values must never be changed.
+type AEADAlgorithm uint8
+
+const (
+	AEADXChaCha20Poly1305 AEADAlgorithm = 1
+	AEADAge               AEADAlgorithm = 2 // Payload is a standard age file, see NewAgeWriter
+	// Payload is encrypted in chunks of StreamChunkSize with XChaCha20-Poly1305, see NewEncryptWriter
+	AEADXChaCha20Poly1305Stream AEADAlgorithm = 3
+)
+
+func (a AEADAlgorithm) String() string {
+	switch a {
+	case AEADXChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	case AEADAge:
+		return "age"
+	case AEADXChaCha20Poly1305Stream:
+		return "XChaCha20-Poly1305 STREAM"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint8(a))
+	}
+}
+
+// IsSupported returns true if payloads can be decrypted with this algorithm.
+func (a AEADAlgorithm) IsSupported() bool {
+	return a == AEADXChaCha20Poly1305 || a == AEADAge || a == AEADXChaCha20Poly1305Stream
+}
+
+// KDFParams are the Argon2id parameters used to derive the key from the password.
+// Memory is expressed in KiB.
+type KDFParams struct {
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"`
+	Threads   uint8  `json:"threads"`
+	KeyLength uint32 `json:"key_length"`
+}
+
+// DefaultKDFParams are the recommended Argon2id parameters based on https://pkg.go.dev/golang.org/x/crypto/argon2#IDKey.
+// These are also assumed for legacy sheets which don't contain the parameters in their header.
+var DefaultKDFParams = KDFParams{
+	Time:      1,
+	Memory:    64 * 1024,
+	Threads:   4,
+	KeyLength: chacha20poly1305.KeySize,
+}
+
+// Maximum KDF parameters. Parameters are read from the scanned header, so a corrupted or
+// hostile header must not be able to exhaust memory or CPU. Threads are limited by their type.
+const (
+	MaxKDFTime   = 64
+	MaxKDFMemory = 1024 * 1024 // 1 GiB in KiB, to limit the memory a hostile header can claim
+)
+
+func (p KDFParams) Validate() error {
+	if p.Time < 1 {
+		return errors.New("KDF time must be at least 1")
+	}
+	if p.Time > MaxKDFTime {
+		return fmt.Errorf("KDF time must be at most %d", MaxKDFTime)
+	}
+	if p.Threads < 1 {
+		return errors.New("KDF threads must be at least 1")
+	}
+	if p.Memory < 8*uint32(p.Threads) {
+		return fmt.Errorf("KDF memory must be at least 8 KiB per thread (%d KiB for %d threads)", 8*uint32(p.Threads), p.Threads)
+	}
+	if p.Memory > MaxKDFMemory {
+		return fmt.Errorf("KDF memory must be at most %d KiB", MaxKDFMemory)
+	}
+	if p.KeyLength != chacha20poly1305.KeySize {
+		return fmt.Errorf("KDF key length is %d bytes, but XChaCha20 Poly1305 requires a key of %d bytes", p.KeyLength, chacha20poly1305.KeySize)
+	}
+	return nil
+}
+
+// GetPassword interactively requests the password on the terminal
 func GetPassword(withConfirm bool) (string, error) {
+	if !term.IsTerminal(syscall.Stdin) {
+		return "", errors.New("stdin is not a terminal: please provide the password with flag --password-file, --password-env or --password-fd")
+	}
 	for {
 		password, err := getPassword("Enter your password")
 		if err != nil {
@@ -63,20 +179,73 @@ func GenerateSalt() ([]byte, error) {
 	return salt, nil
 }
 
-func AEADFromPassword(password string, salt []byte) (cipher.AEAD, error) {
+// GenerateKey returns a random key for XChaCha20-Poly1305. Used when the key is not derived from a password.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	_, err := cryptorand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// AEADFromPassword derives the key with Argon2id and returns a XChaCha20-Poly1305 AEAD.
+// Keyfile is the content of the keyfile, which is mixed into the key derivation. Nil if no keyfile is used.
+func AEADFromPassword(password string, keyfile, salt []byte, params KDFParams) (cipher.AEAD, error) {
+	return NewAEADFromPassword(KDFArgon2id, AEADXChaCha20Poly1305, password, keyfile, salt, params)
+}
+
+// NewAEADFromPassword derives the key with the provided KDF and returns an AEAD of the provided algorithm.
+func NewAEADFromPassword(kdf KDFAlgorithm, aeadAlgorithm AEADAlgorithm, password string, keyfile, salt []byte, params KDFParams) (cipher.AEAD, error) {
+	key, err := DeriveKey(kdf, password, keyfile, salt, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return NewAEAD(aeadAlgorithm, key)
+}
+
+// DeriveKey derives the key from the password and optional keyfile.
+// If a keyfile is provided, the SHA-256 hash of its content is prepended to the password.
+func DeriveKey(algorithm KDFAlgorithm, password string, keyfile, salt []byte, params KDFParams) ([]byte, error) {
 	if len(password) < MinPasswordLength {
 		return nil, fmt.Errorf("password shorter than minimum length of %d", MinPasswordLength)
 	}
+	if err := params.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid KDF parameters: %w", err)
+	}
+	secret := []byte(password)
+	if keyfile != nil {
+		if len(keyfile) == 0 {
+			return nil, errors.New("keyfile is empty")
+		}
+		keyfileHash := sha256.Sum256(keyfile)
+		secret = append(keyfileHash[:], secret...)
+	}
 
-	key := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
-	aead, err := chacha20poly1305.NewX(key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create new XChaCha20 Poly1305 AEAD: %w", err)
+	switch algorithm {
+	case KDFArgon2id:
+		return argon2.IDKey(secret, salt, params.Time, params.Memory, params.Threads, params.KeyLength), nil
+	default:
+		return nil, fmt.Errorf("unsupported KDF algorithm %s", algorithm)
+	}
+}
+
+func NewAEAD(algorithm AEADAlgorithm, key []byte) (cipher.AEAD, error) {
+	switch algorithm {
+	case AEADXChaCha20Poly1305, AEADXChaCha20Poly1305Stream:
+		aead, err := chacha20poly1305.NewX(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create new XChaCha20 Poly1305 AEAD: %w", err)
+		}
+		return aead, nil
+	default:
+		return nil, fmt.Errorf("unsupported AEAD algorithm %s", algorithm)
 	}
-	return aead, nil
 }
 
-func Encrypt(msg []byte, aead cipher.AEAD) ([]byte, error) {
+// Encrypt encrypts and authenticates the message. Additional data is only authenticated and
+// must be provided unchanged to Decrypt.
+func Encrypt(msg []byte, aead cipher.AEAD, additionalData []byte) ([]byte, error) {
 	// Based on https://pkg.go.dev/golang.org/x/crypto/chacha20poly1305#example-NewX
 	// Select a random nonce, and leave capacity for the ciphertext.
 	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(msg)+aead.Overhead())
@@ -85,11 +254,11 @@ func Encrypt(msg []byte, aead cipher.AEAD) ([]byte, error) {
 	}
 
 	// Encrypt the message and append the ciphertext to the nonce.
-	encryptedMsg := aead.Seal(nonce, nonce, msg, nil)
+	encryptedMsg := aead.Seal(nonce, nonce, msg, additionalData)
 	return encryptedMsg, nil
 }
 
-func Decrypt(encryptedMsg []byte, aead cipher.AEAD) ([]byte, error) {
+func Decrypt(encryptedMsg []byte, aead cipher.AEAD, additionalData []byte) ([]byte, error) {
 	// Based on https://pkg.go.dev/golang.org/x/crypto/chacha20poly1305#example-NewX
 	// Validate length of encrypted message
 	if len(encryptedMsg) < aead.NonceSize() {
@@ -100,10 +269,9 @@ func Decrypt(encryptedMsg []byte, aead cipher.AEAD) ([]byte, error) {
 	nonce, ciphertext := encryptedMsg[:aead.NonceSize()], encryptedMsg[aead.NonceSize():]
 
 	// Decrypt the message and check it wasn't tampered with
-	msg, err := aead.Open(nil, nonce, ciphertext, nil)
+	msg, err := aead.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
-		slog.Error("Decryption failed. Please check you password and retry.")
-		return nil, fmt.Errorf("failed to decrypt and authenticate cipher text: %w", err)
+		return nil, fmt.Errorf("failed to decrypt cipher text: %w", ErrAuthenticationFailed)
 	}
 	return msg, nil
 }
@@ -1,6 +1,8 @@
 package encrypt
 
 import (
+	"math"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -10,27 +12,81 @@ func TestValidateEncryptionRoundtrip(t *testing.T) {
 	// Data
 	password := "MY_VERY_SECURE_PASSWORD" // #nosec G101
 	msg := "Should not be public"
+	additionalData := []byte("Should be authenticated")
 
 	// Generate salt
 	salt, err := GenerateSalt()
 	require.NoError(t, err)
 
 	// Create encryption AEAD
-	aeadEnc, err := AEADFromPassword(password, salt)
+	aeadEnc, err := AEADFromPassword(password, nil, salt, DefaultKDFParams)
 	require.NoError(t, err)
 
 	// Encrypt message
-	encryptedMsg, err := Encrypt([]byte(msg), aeadEnc)
+	encryptedMsg, err := Encrypt([]byte(msg), aeadEnc, additionalData)
 	require.NoError(t, err)
 
 	// Create decryption AEAD => Ensures AEAD is ephemeral
-	aeadDec, err := AEADFromPassword(password, salt)
+	aeadDec, err := AEADFromPassword(password, nil, salt, DefaultKDFParams)
 	require.NoError(t, err)
 
 	// Decrypt message
-	decryptedMsg, err := Decrypt(encryptedMsg, aeadDec)
+	decryptedMsg, err := Decrypt(encryptedMsg, aeadDec, additionalData)
 	require.NoError(t, err)
 
 	// Validate result
 	require.Equal(t, msg, string(decryptedMsg))
+
+	// Decrypt message with tampered additional data
+	_, err = Decrypt(encryptedMsg, aeadDec, []byte("Should be Authenticated"))
+	require.Error(t, err)
+}
+
+func TestAEADFromPasswordRejectsInvalidKDFParams(t *testing.T) {
+	// Generate salt
+	salt, err := GenerateSalt()
+	require.NoError(t, err)
+
+	// Create AEAD with invalid key length
+	params := DefaultKDFParams
+	params.KeyLength = 16
+	_, err = AEADFromPassword("MY_VERY_SECURE_PASSWORD", nil, salt, params)
+	require.Error(t, err)
+
+	// Create AEAD with parameters of a hostile header, which must be rejected before allocating
+	for _, params := range []KDFParams{
+		{Time: 1, Memory: MaxKDFMemory + 1, Threads: 4, KeyLength: 32},
+		{Time: 1, Memory: math.MaxUint32, Threads: 4, KeyLength: 32},
+		{Time: math.MaxUint32, Memory: 64 * 1024, Threads: 4, KeyLength: 32},
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err = AEADFromPassword("MY_VERY_SECURE_PASSWORD", nil, salt, params)
+		runtime.ReadMemStats(&after)
+		require.ErrorContains(t, err, "at most", "params %+v", params)
+		require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1024*1024), "params %+v", params)
+	}
+}
+
+func TestDeriveKeyMixesKeyfile(t *testing.T) {
+	// Generate salt
+	salt, err := GenerateSalt()
+	require.NoError(t, err)
+
+	// Derive keys
+	password := "MY_VERY_SECURE_PASSWORD" // #nosec G101
+	keyWithoutKeyfile, err := DeriveKey(KDFArgon2id, password, nil, salt, DefaultKDFParams)
+	require.NoError(t, err)
+	keyWithKeyfile, err := DeriveKey(KDFArgon2id, password, []byte("keyfile content"), salt, DefaultKDFParams)
+	require.NoError(t, err)
+	keyWithOtherKeyfile, err := DeriveKey(KDFArgon2id, password, []byte("other keyfile content"), salt, DefaultKDFParams)
+	require.NoError(t, err)
+
+	// Validate result
+	require.NotEqual(t, keyWithoutKeyfile, keyWithKeyfile)
+	require.NotEqual(t, keyWithKeyfile, keyWithOtherKeyfile)
+
+	// Empty keyfile
+	_, err = DeriveKey(KDFArgon2id, password, []byte{}, salt, DefaultKDFParams)
+	require.ErrorContains(t, err, "keyfile is empty")
 }
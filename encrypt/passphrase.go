@@ -0,0 +1,44 @@
+package encrypt
+
+import (
+	cryptorand "crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/JenswBE/encrypted-paper/assets"
+)
+
+// MinPassphraseWords is the minimum number of words in a generated passphrase.
+// Each word from the EFF large wordlist adds about 12.9 bits of entropy.
+const MinPassphraseWords = 5
+
+// GeneratePassphrase returns a diceware passphrase of random words from the EFF large wordlist, separated by spaces
+func GeneratePassphrase(wordCount int) (string, error) {
+	// Validate input
+	if wordCount < MinPassphraseWords {
+		return "", fmt.Errorf("passphrase must at least contain %d words", MinPassphraseWords)
+	}
+
+	// Parse wordlist
+	lines := strings.Split(strings.TrimSpace(assets.EFFLargeWordlist), "\n")
+	wordlist := make([]string, len(lines))
+	for i, line := range lines {
+		_, word, ok := strings.Cut(line, "\t")
+		if !ok {
+			return "", fmt.Errorf("invalid line %d in wordlist: %s", i+1, line)
+		}
+		wordlist[i] = word
+	}
+
+	// Pick random words
+	words := make([]string, wordCount)
+	for i := range words {
+		index, err := cryptorand.Int(cryptorand.Reader, big.NewInt(int64(len(wordlist))))
+		if err != nil {
+			return "", fmt.Errorf("failed to pick random word: %w", err)
+		}
+		words[i] = wordlist[index.Int64()]
+	}
+	return strings.Join(words, " "), nil
+}
@@ -0,0 +1,20 @@
+package encrypt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratePassphrase(t *testing.T) {
+	// Generate passphrase
+	passphrase, err := GeneratePassphrase(7)
+	require.NoError(t, err)
+	require.Len(t, strings.Fields(passphrase), 7)
+	require.GreaterOrEqual(t, len(passphrase), MinPasswordLength)
+
+	// Too few words
+	_, err = GeneratePassphrase(MinPassphraseWords - 1)
+	require.Error(t, err)
+}
@@ -0,0 +1,84 @@
+package encrypt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PasswordSource defines where the password is read from, to allow running without terminal.
+// The password is requested interactively if no source is set.
+type PasswordSource struct {
+	File string // Path of a file containing the password
+	Env  string // Name of an environment variable containing the password
+	FD   int    // File descriptor to read the password from. Negative if not set.
+}
+
+// IsSet returns true if a non-interactive source is set
+func (s PasswordSource) IsSet() bool {
+	return s.File != "" || s.Env != "" || s.FD >= 0
+}
+
+// Validate ensures at most one source is set
+func (s PasswordSource) Validate() error {
+	sourceCount := 0
+	for _, isSet := range []bool{s.File != "", s.Env != "", s.FD >= 0} {
+		if isSet {
+			sourceCount++
+		}
+	}
+	if sourceCount > 1 {
+		return errors.New("only one of password file, password environment variable and password file descriptor can be set")
+	}
+	return nil
+}
+
+// GetPassword reads the password from the configured source. Leading and trailing whitespace is removed.
+// If no source is set, the password is requested interactively, optionally with confirmation.
+func (s PasswordSource) GetPassword(withConfirm bool) (string, error) {
+	// Validate source
+	if err := s.Validate(); err != nil {
+		return "", err
+	}
+	if !s.IsSet() {
+		return GetPassword(withConfirm)
+	}
+
+	// Read password
+	var password string
+	switch {
+	case s.File != "":
+		contents, err := os.ReadFile(filepath.Clean(s.File))
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		password = string(contents)
+	case s.Env != "":
+		var ok bool
+		password, ok = os.LookupEnv(s.Env)
+		if !ok {
+			return "", fmt.Errorf("password environment variable %s is not set", s.Env)
+		}
+	default:
+		file := os.NewFile(uintptr(s.FD), "password-fd")
+		if file == nil {
+			return "", fmt.Errorf("password file descriptor %d is invalid", s.FD)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password from file descriptor %d: %w", s.FD, err)
+		}
+		password = string(contents)
+	}
+
+	// Validate password
+	password = strings.TrimSpace(password)
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must at least have a length of %d", MinPasswordLength)
+	}
+	return password, nil
+}
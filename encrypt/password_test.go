@@ -0,0 +1,47 @@
+package encrypt
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordSource(t *testing.T) {
+	// Read from file
+	passwordFile := filepath.Join(t.TempDir(), "password.txt")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("password-from-file\n"), 0o600))
+	password, err := PasswordSource{File: passwordFile, FD: -1}.GetPassword(true)
+	require.NoError(t, err)
+	require.Equal(t, "password-from-file", password)
+
+	// Read from environment variable
+	t.Setenv("TEST_PASSWORD", "password-from-env")
+	password, err = PasswordSource{Env: "TEST_PASSWORD", FD: -1}.GetPassword(false)
+	require.NoError(t, err)
+	require.Equal(t, "password-from-env", password)
+
+	// Read from file descriptor
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	_, err = writer.WriteString("password-from-fd")
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	fd, err := syscall.Dup(int(reader.Fd())) // GetPassword closes the descriptor, reader closes its own
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	password, err = PasswordSource{FD: fd}.GetPassword(false)
+	require.NoError(t, err)
+	require.Equal(t, "password-from-fd", password)
+
+	// Too short
+	t.Setenv("TEST_PASSWORD", "short")
+	_, err = PasswordSource{Env: "TEST_PASSWORD", FD: -1}.GetPassword(false)
+	require.ErrorContains(t, err, "password must at least have a length of 8")
+
+	// Multiple sources
+	_, err = PasswordSource{File: passwordFile, Env: "TEST_PASSWORD", FD: -1}.GetPassword(false)
+	require.ErrorContains(t, err, "only one of")
+}
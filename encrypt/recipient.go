@@ -0,0 +1,119 @@
+package encrypt
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// fileKeySize is the size of the random file key which is wrapped for each recipient.
+// Wrapped keys use the age format, which requires a file key of 16 bytes.
+const fileKeySize = 16
+
+// hkdfInfoRecipients is used to derive the key from the file key
+const hkdfInfoRecipients = "encrypted-paper X25519 recipients"
+
+// Stanza is the file key wrapped for a single recipient. See https://age-encryption.org/v1 for the format.
+type Stanza struct {
+	Type string   `json:"type"`
+	Args []string `json:"args"`
+	Body []byte   `json:"body"`
+}
+
+// ParseRecipients parses X25519 public keys in the age format (age1...)
+func ParseRecipients(publicKeys []string) ([]age.Recipient, error) {
+	recipients := make([]age.Recipient, len(publicKeys))
+	for i, publicKey := range publicKeys {
+		recipient, err := age.ParseX25519Recipient(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf(`failed to parse recipient "%s": %w`, publicKey, err)
+		}
+		recipients[i] = recipient
+	}
+	return recipients, nil
+}
+
+// ReadIdentities reads the identities (private keys) from an identity file as generated by keygen or age-keygen
+func ReadIdentities(path string) ([]age.Identity, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open identity file: %w", err)
+	}
+	defer file.Close()
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity file %s: %w", path, err)
+	}
+	return identities, nil
+}
+
+// WrapKey generates a random key and wraps it for each recipient
+func WrapKey(recipients []age.Recipient) (key []byte, stanzas []Stanza, err error) {
+	// Generate file key
+	if len(recipients) == 0 {
+		return nil, nil, errors.New("at least 1 recipient is required")
+	}
+	fileKey, err := GenerateKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate file key: %w", err)
+	}
+	fileKey = fileKey[:fileKeySize]
+
+	// Wrap file key
+	for _, recipient := range recipients {
+		ageStanzas, err := recipient.Wrap(fileKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to wrap file key for recipient: %w", err)
+		}
+		for _, ageStanza := range ageStanzas {
+			stanzas = append(stanzas, Stanza{Type: ageStanza.Type, Args: ageStanza.Args, Body: ageStanza.Body})
+		}
+	}
+
+	// Derive key
+	key, err = deriveKeyFromFileKey(fileKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, stanzas, nil
+}
+
+// UnwrapKey unwraps the file key with one of the identities and derives the key
+func UnwrapKey(stanzas []Stanza, identities []age.Identity) ([]byte, error) {
+	// Convert stanzas
+	ageStanzas := make([]*age.Stanza, len(stanzas))
+	for i, stanza := range stanzas {
+		ageStanzas[i] = &age.Stanza{Type: stanza.Type, Args: stanza.Args, Body: stanza.Body}
+	}
+
+	// Unwrap file key
+	for _, identity := range identities {
+		fileKey, err := identity.Unwrap(ageStanzas)
+		if errors.Is(err, age.ErrIncorrectIdentity) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap file key: %w", err)
+		}
+		if len(fileKey) != fileKeySize {
+			return nil, fmt.Errorf("unwrapped file key is %d bytes, but expected %d bytes", len(fileKey), fileKeySize)
+		}
+		return deriveKeyFromFileKey(fileKey)
+	}
+	return nil, errors.New("none of the provided identities is a recipient of the sheets")
+}
+
+func deriveKeyFromFileKey(fileKey []byte) ([]byte, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, fileKey, nil, []byte(hkdfInfoRecipients)), key); err != nil {
+		return nil, fmt.Errorf("failed to derive key from file key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,37 @@
+package encrypt
+
+import (
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapAndUnwrapKey(t *testing.T) {
+	// Generate identities
+	identity1, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	identity2, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	otherIdentity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	// Wrap key
+	recipients, err := ParseRecipients([]string{identity1.Recipient().String(), identity2.Recipient().String()})
+	require.NoError(t, err)
+	key, stanzas, err := WrapKey(recipients)
+	require.NoError(t, err)
+	require.Len(t, key, 32)
+	require.Len(t, stanzas, 2)
+
+	// Unwrap with each recipient
+	for _, identity := range []age.Identity{identity1, identity2} {
+		unwrappedKey, err := UnwrapKey(stanzas, []age.Identity{otherIdentity, identity})
+		require.NoError(t, err)
+		require.Equal(t, key, unwrappedKey)
+	}
+
+	// Unwrap with other identity
+	_, err = UnwrapKey(stanzas, []age.Identity{otherIdentity})
+	require.ErrorContains(t, err, "none of the provided identities")
+}
@@ -0,0 +1,125 @@
+package encrypt
+
+import (
+	cryptorand "crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// KeyShare is a share of a key which is split using Shamir's Secret Sharing over GF(2^8).
+// Any Threshold shares out of Count shares are required to recover the key.
+type KeyShare struct {
+	Index     uint8  `json:"index"` // X coordinate of the share, starting at 1
+	Threshold uint8  `json:"threshold"`
+	Count     uint8  `json:"count"`
+	Value     []byte `json:"value"`
+}
+
+// SplitKey splits the key into count shares, of which threshold shares are required to recover the key
+func SplitKey(key []byte, count, threshold int) ([]KeyShare, error) {
+	// Validate input
+	if len(key) == 0 {
+		return nil, errors.New("key cannot be empty")
+	}
+	if threshold < 2 {
+		return nil, errors.New("threshold must be at least 2")
+	}
+	if count < threshold {
+		return nil, fmt.Errorf("share count %d must be at least the threshold %d", count, threshold)
+	}
+	if count > 255 {
+		return nil, errors.New("share count must be at most 255")
+	}
+
+	// Init shares
+	shares := make([]KeyShare, count)
+	for i := range shares {
+		shares[i] = KeyShare{
+			Index:     uint8(i + 1),
+			Threshold: uint8(threshold),
+			Count:     uint8(count),
+			Value:     make([]byte, len(key)),
+		}
+	}
+
+	// Evaluate a random polynomial with the key byte as constant term for each byte of the key
+	coefficients := make([]byte, threshold)
+	for i, keyByte := range key {
+		coefficients[0] = keyByte
+		if _, err := cryptorand.Read(coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("failed to generate random coefficients: %w", err)
+		}
+		for j := range shares {
+			shares[j].Value[i] = evalPolynomial(coefficients, shares[j].Index)
+		}
+	}
+	return shares, nil
+}
+
+// CombineKey recovers the key from at least threshold distinct shares
+func CombineKey(shares []KeyShare) ([]byte, error) {
+	// Validate shares
+	if len(shares) == 0 {
+		return nil, errors.New("no key shares provided")
+	}
+	threshold := int(shares[0].Threshold)
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("%d key shares provided, but %d key shares are required", len(shares), threshold)
+	}
+	shares = shares[:threshold]
+	seen := make(map[uint8]bool, len(shares))
+	for _, share := range shares {
+		if share.Index == 0 || seen[share.Index] {
+			return nil, fmt.Errorf("key share index %d is invalid or duplicate", share.Index)
+		}
+		if len(share.Value) != len(shares[0].Value) {
+			return nil, errors.New("key shares have different lengths")
+		}
+		seen[share.Index] = true
+	}
+
+	// Interpolate the polynomials at x = 0 using Lagrange interpolation
+	key := make([]byte, len(shares[0].Value))
+	for i, share := range shares {
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = gf256Mul(basis, gf256Div(other.Index, other.Index^share.Index))
+			}
+		}
+		for k := range key {
+			key[k] ^= gf256Mul(share.Value[k], basis)
+		}
+	}
+	return key, nil
+}
+
+// evalPolynomial evaluates the polynomial with coefficients ordered lowest degree first
+func evalPolynomial(coefficients []byte, x byte) byte {
+	var result byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = gf256Mul(result, x) ^ coefficients[i]
+	}
+	return result
+}
+
+// gf256Mul multiplies in GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1.
+// Implemented without lookup tables to avoid timing side channels.
+func gf256Mul(a, b byte) byte {
+	var result byte
+	for range 8 {
+		result ^= -(b & 1) & a
+		a = a<<1 ^ -(a>>7)&0x1B
+		b >>= 1
+	}
+	return result
+}
+
+// gf256Div divides in GF(2^8) by multiplying with the inverse b^254
+func gf256Div(a, b byte) byte {
+	inverse := byte(1)
+	for range 254 {
+		inverse = gf256Mul(inverse, b)
+	}
+	return gf256Mul(a, inverse)
+}
@@ -0,0 +1,29 @@
+package encrypt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateShamirRoundtrip(t *testing.T) {
+	// Generate key
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	// Split key
+	shares, err := SplitKey(key, 5, 3)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+
+	// Combine any 3 shares
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}} {
+		combinedKey, err := CombineKey([]KeyShare{shares[subset[0]], shares[subset[1]], shares[subset[2]]})
+		require.NoError(t, err)
+		require.Equal(t, key, combinedKey)
+	}
+
+	// Combine with too few shares
+	_, err = CombineKey(shares[:2])
+	require.Error(t, err)
+}
@@ -0,0 +1,178 @@
+package encrypt
+
+import (
+	"bufio"
+	"crypto/cipher"
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StreamChunkSize is the size of the plaintext chunks which are encrypted and authenticated independently
+const StreamChunkSize = 64 * 1024
+
+// streamCounterSize is the number of bytes of the nonce used for the chunk counter and last chunk flag.
+// Remaining bytes of the nonce are a random prefix, which is written before the first chunk.
+const streamCounterSize = 8
+
+// streamMaxChunks is the maximum number of chunks, as the counter is 7 bytes
+const streamMaxChunks = 1 << 56
+
+// EncryptWriter encrypts the written data in chunks using the STREAM construction
+// (https://eprint.iacr.org/2015/189.pdf). Each chunk is authenticated independently,
+// while the nonce ensures chunks can't be reordered, removed or truncated.
+// Close must be called to encrypt the last chunk.
+type EncryptWriter struct {
+	output         io.Writer
+	aead           cipher.AEAD
+	additionalData []byte
+	noncePrefix    []byte
+	counter        uint64
+	buf            []byte
+	sealed         []byte
+}
+
+// NewEncryptWriter returns a writer which encrypts to output. Additional data is authenticated with each chunk.
+func NewEncryptWriter(output io.Writer, aead cipher.AEAD, additionalData []byte) (*EncryptWriter, error) {
+	// Generate nonce prefix
+	if aead.NonceSize() <= streamCounterSize {
+		return nil, fmt.Errorf("nonce size %d is too small for streaming encryption", aead.NonceSize())
+	}
+	noncePrefix := make([]byte, aead.NonceSize()-streamCounterSize)
+	if _, err := cryptorand.Read(noncePrefix); err != nil {
+		return nil, fmt.Errorf("failed to select a random nonce prefix: %w", err)
+	}
+
+	// Write nonce prefix
+	if _, err := output.Write(noncePrefix); err != nil {
+		return nil, fmt.Errorf("failed to write nonce prefix: %w", err)
+	}
+	return &EncryptWriter{
+		output:         output,
+		aead:           aead,
+		additionalData: additionalData,
+		noncePrefix:    noncePrefix,
+		buf:            make([]byte, 0, StreamChunkSize),
+		sealed:         make([]byte, 0, StreamChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (w *EncryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// Only seal a full chunk when more data follows, as the last chunk is sealed differently
+		if len(w.buf) == StreamChunkSize {
+			if err := w.sealChunk(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):StreamChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close encrypts the last chunk. It doesn't close the output.
+func (w *EncryptWriter) Close() error {
+	return w.sealChunk(true)
+}
+
+func (w *EncryptWriter) sealChunk(last bool) error {
+	if w.counter >= streamMaxChunks {
+		return errors.New("maximum number of chunks exceeded")
+	}
+	nonce := streamNonce(w.noncePrefix, w.counter, last)
+	w.sealed = w.aead.Seal(w.sealed[:0], nonce, w.buf, w.additionalData)
+	if _, err := w.output.Write(w.sealed); err != nil {
+		return fmt.Errorf("failed to write encrypted chunk: %w", err)
+	}
+	w.buf = w.buf[:0]
+	w.counter++
+	return nil
+}
+
+// DecryptReader decrypts data encrypted by EncryptWriter. Each chunk is authenticated before it's returned.
+// An error is returned if the data is truncated or tampered with.
+type DecryptReader struct {
+	input          *bufio.Reader
+	aead           cipher.AEAD
+	additionalData []byte
+	noncePrefix    []byte
+	counter        uint64
+	sealed         []byte
+	buf            []byte
+	done           bool
+}
+
+// NewDecryptReader returns a reader which decrypts from input. Additional data must match the data provided during encryption.
+func NewDecryptReader(input io.Reader, aead cipher.AEAD, additionalData []byte) (*DecryptReader, error) {
+	// Read nonce prefix
+	noncePrefix := make([]byte, aead.NonceSize()-streamCounterSize)
+	if _, err := io.ReadFull(input, noncePrefix); err != nil {
+		return nil, fmt.Errorf("failed to read nonce prefix: %w", err)
+	}
+	return &DecryptReader{
+		input:          bufio.NewReaderSize(input, StreamChunkSize+aead.Overhead()+1),
+		aead:           aead,
+		additionalData: additionalData,
+		noncePrefix:    noncePrefix,
+		sealed:         make([]byte, StreamChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (r *DecryptReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.openChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *DecryptReader) openChunk() error {
+	// Read chunk
+	n, err := io.ReadFull(r.input, r.sealed)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read encrypted chunk: %w", err)
+	}
+	last := n < len(r.sealed)
+	if !last {
+		// Full chunk is only the last chunk if no data follows
+		if _, err = r.input.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		}
+	}
+
+	// Decrypt chunk
+	if r.counter >= streamMaxChunks {
+		return errors.New("maximum number of chunks exceeded")
+	}
+	nonce := streamNonce(r.noncePrefix, r.counter, last)
+	r.buf, err = r.aead.Open(r.buf[:0], nonce, r.sealed[:n], r.additionalData)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt and authenticate chunk %d: %w", r.counter, ErrAuthenticationFailed)
+	}
+	r.counter++
+	r.done = last
+	return nil
+}
+
+// streamNonce returns the nonce for the chunk: prefix || 7 byte big endian counter || last chunk flag
+func streamNonce(prefix []byte, counter uint64, last bool) []byte {
+	nonce := make([]byte, len(prefix)+streamCounterSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint64(nonce[len(prefix):], counter<<8)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
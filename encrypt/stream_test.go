@@ -0,0 +1,51 @@
+package encrypt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateStreamRoundtrip(t *testing.T) {
+	// Create AEAD
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	aead, err := NewAEAD(AEADXChaCha20Poly1305Stream, key)
+	require.NoError(t, err)
+	additionalData := []byte("Should be authenticated")
+
+	for _, size := range []int{0, 1, StreamChunkSize - 1, StreamChunkSize, StreamChunkSize + 1, 3*StreamChunkSize + 123} {
+		// Encrypt message
+		msg := bytes.Repeat([]byte{byte(size)}, size)
+		var encrypted bytes.Buffer
+		writer, err := NewEncryptWriter(&encrypted, aead, additionalData)
+		require.NoError(t, err)
+		_, err = writer.Write(msg)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		// Decrypt message
+		reader, err := NewDecryptReader(bytes.NewReader(encrypted.Bytes()), aead, additionalData)
+		require.NoError(t, err)
+		decrypted, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, msg, decrypted, "size %d", size)
+
+		// Decrypt truncated message
+		if size > StreamChunkSize {
+			truncated := encrypted.Bytes()[:aead.NonceSize()-streamCounterSize+StreamChunkSize+aead.Overhead()]
+			reader, err = NewDecryptReader(bytes.NewReader(truncated), aead, additionalData)
+			require.NoError(t, err)
+			_, err = io.ReadAll(reader)
+			require.Error(t, err, "size %d", size)
+		}
+
+		// Decrypt with tampered additional data
+		reader, err = NewDecryptReader(bytes.NewReader(encrypted.Bytes()), aead, []byte("Should be Authenticated"))
+		require.NoError(t, err)
+		_, err = io.ReadAll(reader)
+		require.Error(t, err)
+	}
+}
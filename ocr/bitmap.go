@@ -0,0 +1,270 @@
+package ocr
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"slices"
+)
+
+// bitmap is a binarized image
+type bitmap struct {
+	bounds image.Rectangle
+	ink    []bool // Row-major
+}
+
+func newBitmap(bounds image.Rectangle, isInk func(x, y int) bool) *bitmap {
+	b := &bitmap{bounds: bounds, ink: make([]bool, bounds.Dx()*bounds.Dy())}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			b.ink[b.index(x, y)] = isInk(x, y)
+		}
+	}
+	return b
+}
+
+func (b *bitmap) index(x, y int) int {
+	return (y-b.bounds.Min.Y)*b.bounds.Dx() + x - b.bounds.Min.X
+}
+
+// get returns true if the pixel is ink. Pixels out of bounds are never ink.
+func (b *bitmap) get(x, y int) bool {
+	return image.Pt(x, y).In(b.bounds) && b.ink[b.index(x, y)]
+}
+
+// count returns the number of ink pixels in the rectangle
+func (b *bitmap) count(r image.Rectangle) int {
+	r = r.Intersect(b.bounds)
+	count := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if b.ink[b.index(x, y)] {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// inkBounds returns the smallest rectangle within r which contains all ink in r
+func (b *bitmap) inkBounds(r image.Rectangle) image.Rectangle {
+	r = r.Intersect(b.bounds)
+	result := image.Rectangle{}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if b.ink[b.index(x, y)] {
+				result = result.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return result
+}
+
+// rows returns the horizontal bands which contain ink, separated by rows without ink
+func (b *bitmap) rows() []image.Rectangle {
+	var rows []image.Rectangle
+	start := -1
+	for y := b.bounds.Min.Y; y <= b.bounds.Max.Y; y++ {
+		hasInk := y < b.bounds.Max.Y && b.count(image.Rect(b.bounds.Min.X, y, b.bounds.Max.X, y+1)) > 0
+		switch {
+		case hasInk && start == -1:
+			start = y
+		case !hasInk && start != -1:
+			rows = append(rows, image.Rect(b.bounds.Min.X, start, b.bounds.Max.X, y))
+			start = -1
+		}
+	}
+	return rows
+}
+
+// segments returns the horizontal ranges within r which contain ink, with exclusive end
+func (b *bitmap) segments(r image.Rectangle) [][2]int {
+	var segments [][2]int
+	start := -1
+	for x := r.Min.X; x <= r.Max.X; x++ {
+		hasInk := x < r.Max.X && b.count(image.Rect(x, r.Min.Y, x+1, r.Max.Y)) > 0
+		switch {
+		case hasInk && start == -1:
+			start = x
+		case !hasInk && start != -1:
+			segments = append(segments, [2]int{start, x})
+			start = -1
+		}
+	}
+	return segments
+}
+
+// samplesPerCell is the number of samples in each direction for each cell of the grid
+const samplesPerCell = 4
+
+// Area above the cap height and below the baseline which is included in the features, relative to the cap height.
+// Includes overshoots of round characters and the tail of characters like Q.
+const (
+	featuresAboveCapHeight = 0.1
+	featuresBelowBaseline  = 0.25
+)
+
+// features samples the glyph between x0 and x1 (exclusive) on the grid. Grid is a character cell of pitch wide,
+// centered on the glyph, and spans the cap height with some margin. Returns the fraction of ink of each cell.
+func (b *bitmap) features(x0, x1 int, capTop, baseline, pitch float64) []float64 {
+	features := make([]float64, gridWidth*gridHeight)
+	left := float64(x0+x1)/2 - pitch/2
+	capHeight := baseline - capTop
+	top := capTop - featuresAboveCapHeight*capHeight
+	cellWidth, cellHeight := pitch/gridWidth, (1+featuresAboveCapHeight+featuresBelowBaseline)*capHeight/gridHeight
+	for gy := range gridHeight {
+		for gx := range gridWidth {
+			ink := 0
+			for sy := range samplesPerCell {
+				y := int(math.Floor(top + (float64(gy)+(float64(sy)+0.5)/samplesPerCell)*cellHeight))
+				for sx := range samplesPerCell {
+					x := int(math.Floor(left + (float64(gx)+(float64(sx)+0.5)/samplesPerCell)*cellWidth))
+					if x >= x0 && x < x1 && b.get(x, y) {
+						ink++
+					}
+				}
+			}
+			features[gy*gridWidth+gx] = float64(ink) / (samplesPerCell * samplesPerCell)
+		}
+	}
+	return features
+}
+
+// capBandFraction is the minimum ink in a row between cap height and baseline, relative to the row with most ink
+const capBandFraction = 0.25
+
+// capBand estimates the top of capitals and the baseline of the text in r. As most characters span
+// the cap height, rows with overshoots or tails contain much less ink than rows within the cap height.
+func (b *bitmap) capBand(r image.Rectangle) (capTop, baseline float64) {
+	profile := make([]int, r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		profile[y-r.Min.Y] = b.count(image.Rect(r.Min.X, y, r.Max.X, y+1))
+	}
+	threshold := float64(slices.Max(append(profile, 0))) * capBandFraction
+	first, last := -1, -1
+	for i, count := range profile {
+		if float64(count) >= threshold && count > 0 {
+			if first == -1 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first == -1 {
+		return 0, 0
+	}
+	return float64(r.Min.Y + first), float64(r.Min.Y + last + 1)
+}
+
+// minContrast is the minimum difference in luminance between ink and paper
+const minContrast = 32
+
+// binarize converts the image into a bitmap, using Otsu's method to find the threshold between ink and paper
+func binarize(img image.Image) *bitmap {
+	// Calculate luminance and histogram
+	bounds := img.Bounds()
+	lum := make([]uint8, bounds.Dx()*bounds.Dy())
+	var histogram [256]int
+	gray, isGray := img.(*image.Gray)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var l uint8
+			if isGray {
+				l = gray.GrayAt(x, y).Y
+			} else {
+				l = color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+			}
+			lum[(y-bounds.Min.Y)*bounds.Dx()+x-bounds.Min.X] = l
+			histogram[l]++
+		}
+	}
+
+	// Find threshold which maximizes the variance between both classes
+	total, sum := len(lum), 0
+	minLum, maxLum := 255, 0
+	for l, count := range histogram {
+		sum += l * count
+		if count > 0 {
+			minLum, maxLum = min(minLum, l), max(maxLum, l)
+		}
+	}
+	threshold := -1 // No ink if contrast is too low
+	if maxLum-minLum >= minContrast {
+		var bestVariance float64
+		countBelow, sumBelow := 0, 0
+		for l := range 256 {
+			countBelow += histogram[l]
+			sumBelow += l * histogram[l]
+			countAbove := total - countBelow
+			if countBelow == 0 || countAbove == 0 {
+				continue
+			}
+			meanBelow := float64(sumBelow) / float64(countBelow)
+			meanAbove := float64(sum-sumBelow) / float64(countAbove)
+			variance := float64(countBelow) * float64(countAbove) * (meanBelow - meanAbove) * (meanBelow - meanAbove)
+			if variance > bestVariance {
+				bestVariance, threshold = variance, l
+			}
+		}
+	}
+	return newBitmap(bounds, func(x, y int) bool {
+		return int(lum[(y-bounds.Min.Y)*bounds.Dx()+x-bounds.Min.X]) <= threshold
+	})
+}
+
+// Range and step of the slope of text lines which is corrected by deskew
+const (
+	maxSkewSlope  = 0.05 // About 3 degrees
+	skewSlopeStep = 0.0025
+)
+
+// deskew corrects a slight rotation of the text by shearing the columns vertically.
+// Slope is chosen which results in the sharpest horizontal projection, as rows of text are separated by empty rows.
+func deskew(b *bitmap) *bitmap {
+	// Collect ink pixels
+	var points []image.Point
+	for y := b.bounds.Min.Y; y < b.bounds.Max.Y; y++ {
+		for x := b.bounds.Min.X; x < b.bounds.Max.X; x++ {
+			if b.ink[b.index(x, y)] {
+				points = append(points, image.Pt(x-b.bounds.Min.X, y-b.bounds.Min.Y))
+			}
+		}
+	}
+	if len(points) == 0 {
+		return b
+	}
+
+	// Find slope with sharpest projection
+	offset := int(math.Ceil(maxSkewSlope * float64(b.bounds.Dx())))
+	shift := func(x int, slope float64) int { return offset - int(math.Round(float64(x)*slope)) }
+	profile := make([]int, b.bounds.Dy()+2*offset+1)
+	bestSlope, bestScore := 0.0, -1.0
+	steps := int(math.Round(maxSkewSlope / skewSlopeStep))
+	for step := -steps; step <= steps; step++ {
+		slope := float64(step) * skewSlopeStep
+		clear(profile)
+		for _, p := range points {
+			profile[p.Y+shift(p.X, slope)]++
+		}
+		score := 0.0
+		for _, count := range profile {
+			score += float64(count) * float64(count)
+		}
+		if score > bestScore || (score == bestScore && math.Abs(slope) < math.Abs(bestSlope)) {
+			bestSlope, bestScore = slope, score
+		}
+	}
+	if bestSlope == 0 {
+		return b
+	}
+
+	// Shear columns
+	sheared := &bitmap{
+		bounds: image.Rect(b.bounds.Min.X, b.bounds.Min.Y, b.bounds.Max.X, b.bounds.Max.Y+2*offset),
+		ink:    make([]bool, b.bounds.Dx()*(b.bounds.Dy()+2*offset)),
+	}
+	for _, p := range points {
+		sheared.ink[sheared.index(b.bounds.Min.X+p.X, b.bounds.Min.Y+p.Y+shift(p.X, bestSlope))] = true
+	}
+	return sheared
+}
@@ -0,0 +1,304 @@
+// Package ocr recognizes lines of text printed in a monospace font, like the text fallback of the sheets.
+// Characters are recognized by comparing them with templates rendered from the same font,
+// so only text printed in that font is supported.
+package ocr
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Size of the grid on which characters are compared with the templates
+const (
+	gridWidth  = 12
+	gridHeight = 16
+)
+
+// templateFontSize is the size in pixels at which the templates are rendered
+const templateFontSize = 64
+
+// Candidate is a possible character for a glyph in the image
+type Candidate struct {
+	Char     rune
+	Distance float64 // Lower is more similar to the template. 0 for a perfect match.
+}
+
+// Glyph is a recognized character, with the candidates ordered from most to least likely.
+// Spaces have a single candidate.
+type Glyph []Candidate
+
+// Line is a recognized line of text
+type Line []Glyph
+
+// String returns the line with the most likely candidate of each glyph
+func (l Line) String() string {
+	var output strings.Builder
+	for _, glyph := range l {
+		output.WriteRune(glyph[0].Char)
+	}
+	return output.String()
+}
+
+// template is the rendered character to compare glyphs with
+type template struct {
+	char     rune
+	features []float64
+}
+
+// Recognizer recognizes the characters of an alphabet in a single font
+type Recognizer struct {
+	templates []template
+
+	// pitchRatio is the advance of a character relative to the cap height, which is the distance from the baseline to the top of capitals
+	pitchRatio float64
+}
+
+// NewRecognizer renders the characters of the alphabet in the monospace TrueType font as templates
+func NewRecognizer(ttf []byte, alphabet string) (*Recognizer, error) {
+	// Load font
+	parsedFont, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font: %w", err)
+	}
+	face, err := opentype.NewFace(parsedFont, &opentype.FaceOptions{Size: templateFontSize, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create font face: %w", err)
+	}
+	defer face.Close()
+
+	// Render characters
+	type rendered struct {
+		char rune
+		ink  *bitmap
+		box  image.Rectangle // Bounds of the ink
+	}
+	var glyphs []rendered
+	var pitch fixed.Int26_6
+	for _, char := range alphabet {
+		dr, mask, maskp, advance, ok := face.Glyph(fixed.Point26_6{}, char)
+		if !ok {
+			return nil, fmt.Errorf("font doesn't contain character %q", char)
+		}
+		if pitch != 0 && advance != pitch {
+			return nil, errors.New("font is not monospace")
+		}
+		pitch = advance
+		alpha := image.NewAlpha(dr)
+		draw.Draw(alpha, dr, mask, maskp, draw.Src)
+		ink := newBitmap(dr, func(x, y int) bool { return alpha.AlphaAt(x, y).A >= 0x80 })
+		box := ink.inkBounds(ink.bounds)
+		if box.Empty() {
+			return nil, fmt.Errorf("character %q has no ink", char)
+		}
+		glyphs = append(glyphs, rendered{char: char, ink: ink, box: box})
+	}
+	if len(glyphs) == 0 {
+		return nil, errors.New("alphabet is empty")
+	}
+
+	// Extract features of the templates in the same way as glyphs in an image. Baseline is at y = 0.
+	pitchPixels := float64(pitch) / 64
+	capHeight := float64(face.Metrics().CapHeight) / 64
+	r := &Recognizer{pitchRatio: pitchPixels / capHeight}
+	for _, glyph := range glyphs {
+		r.templates = append(r.templates, template{
+			char:     glyph.char,
+			features: glyph.ink.features(glyph.box.Min.X, glyph.box.Max.X, -capHeight, 0, pitchPixels),
+		})
+	}
+	return r, nil
+}
+
+// Recognize returns the lines of text in the image. Lines of multiple columns are returned column by column.
+// Image should be a straight scan of printed text. Slight rotation is corrected.
+func (r *Recognizer) Recognize(img image.Image) []Line {
+	ink := deskew(binarize(img))
+	pieces := r.findPieces(ink)
+	lines := make([]Line, 0, len(pieces))
+	for _, piece := range pieces {
+		if line := r.recognizePiece(ink, piece); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// piece is a part of a row of text, separated from other pieces by a large gap, e.g. a line in a column
+type piece struct {
+	box      image.Rectangle
+	segments [][2]int // Horizontal ranges of ink, with exclusive end
+	capTop   float64  // Top of capitals
+	baseline float64
+	pitch    float64
+}
+
+// Minimum height in pixels of a line of text
+const minLineHeight = 6
+
+// columnGapRatio is the minimum gap between pieces of the same row, relative to the pitch
+const columnGapRatio = 2.5
+
+// findPieces finds the lines of text, ordered column by column and from top to bottom
+func (r *Recognizer) findPieces(ink *bitmap) []piece {
+	var pieces []piece
+	for _, row := range splitRows(ink, ink.rows()) {
+		if row.Dy() < minLineHeight {
+			continue // Noise
+		}
+
+		// Split row in pieces at large gaps
+		segments := ink.segments(row)
+		pitch := float64(row.Dy()) * r.pitchRatio
+		for start := 0; start < len(segments); {
+			end := start + 1
+			for end < len(segments) && float64(segments[end][0]-segments[end-1][1]) < columnGapRatio*pitch {
+				end++
+			}
+			box := ink.inkBounds(image.Rect(segments[start][0], row.Min.Y, segments[end-1][1], row.Max.Y))
+			capTop, baseline := ink.capBand(box)
+			if baseline-capTop >= minLineHeight {
+				pieces = append(pieces, piece{
+					box:      box,
+					segments: segments[start:end],
+					capTop:   capTop,
+					baseline: baseline,
+					pitch:    (baseline - capTop) * r.pitchRatio,
+				})
+			}
+			start = end
+		}
+	}
+
+	// Group pieces in columns by their left edge
+	lefts := make([]int, len(pieces))
+	for i, p := range pieces {
+		lefts[i] = p.box.Min.X
+	}
+	slices.Sort(lefts)
+	column := func(p piece) int {
+		index, _ := slices.BinarySearch(lefts, p.box.Min.X)
+		for index > 0 && float64(lefts[index]-lefts[index-1]) < columnGapRatio*p.pitch {
+			index--
+		}
+		return lefts[index]
+	}
+	slices.SortStableFunc(pieces, func(a, b piece) int {
+		if columnA, columnB := column(a), column(b); columnA != columnB {
+			return columnA - columnB
+		}
+		return a.box.Min.Y - b.box.Min.Y
+	})
+	return pieces
+}
+
+// tallRowRatio is the height of a row relative to the typical row above which it's assumed to contain multiple lines
+const tallRowRatio = 1.5
+
+// splitRows splits rows which contain multiple lines, e.g. because a smudge connects the lines.
+// Rows are split at the rows with least ink near the expected gaps between the lines.
+func splitRows(ink *bitmap, rows []image.Rectangle) []image.Rectangle {
+	if len(rows) < 3 {
+		return rows
+	}
+
+	// Determine typical height of and spacing between rows
+	heights, spacings := make([]int, 0, len(rows)), make([]int, 0, len(rows)-1)
+	for i, row := range rows {
+		heights = append(heights, row.Dy())
+		if i > 0 {
+			spacings = append(spacings, row.Min.Y-rows[i-1].Min.Y)
+		}
+	}
+	slices.Sort(heights)
+	slices.Sort(spacings)
+	height, spacing := heights[len(heights)/2], spacings[len(spacings)/2]
+	if spacing <= height {
+		return rows
+	}
+
+	// Split tall rows
+	var output []image.Rectangle
+	for _, row := range rows {
+		if float64(row.Dy()) <= tallRowRatio*float64(height) {
+			output = append(output, row)
+			continue
+		}
+		lineCount := int(math.Round(float64(row.Dy()-height)/float64(spacing))) + 1
+		top := row.Min.Y
+		for line := 1; line < lineCount; line++ {
+			expected := row.Min.Y + line*spacing - (spacing-height)/2
+			split, least := expected, -1
+			for y := max(top+1, expected-spacing/4); y <= min(row.Max.Y-1, expected+spacing/4); y++ {
+				if count := ink.count(image.Rect(row.Min.X, y, row.Max.X, y+1)); least == -1 || count < least {
+					split, least = y, count
+				}
+			}
+			output = append(output, image.Rect(row.Min.X, top, row.Max.X, split))
+			top = split
+		}
+		output = append(output, image.Rect(row.Min.X, top, row.Max.X, row.Max.Y))
+	}
+	return output
+}
+
+// minGlyphInk is the minimum ink of a glyph relative to the area of a character. Smaller segments are ignored as noise.
+const minGlyphInk = 0.01
+
+// recognizePiece splits the piece in glyphs and recognizes them
+func (r *Recognizer) recognizePiece(ink *bitmap, p piece) Line {
+	// Split segments in glyphs. Touching characters form a single segment, so wide segments are split.
+	var glyphs [][2]int
+	for _, segment := range p.segments {
+		box := ink.inkBounds(image.Rect(segment[0], p.box.Min.Y, segment[1], p.box.Max.Y))
+		if float64(ink.count(box)) < minGlyphInk*p.pitch*(p.baseline-p.capTop) {
+			continue
+		}
+		width := float64(segment[1] - segment[0])
+		count := max(1, int(width/p.pitch+0.5))
+		if width < 1.5*p.pitch {
+			count = 1
+		}
+		for i := range count {
+			glyphs = append(glyphs, [2]int{segment[0] + int(float64(i)*width/float64(count)), segment[0] + int(float64(i+1)*width/float64(count))})
+		}
+	}
+
+	// Recognize glyphs and add spaces based on the distance between glyphs
+	var line Line
+	for i, glyph := range glyphs {
+		if i > 0 {
+			distance := float64(glyph[0]+glyph[1]-glyphs[i-1][0]-glyphs[i-1][1]) / 2
+			for range int(distance/p.pitch+0.5) - 1 {
+				line = append(line, Glyph{{Char: ' '}})
+			}
+		}
+		box := ink.inkBounds(image.Rect(glyph[0], p.box.Min.Y, glyph[1], p.box.Max.Y))
+		line = append(line, r.classify(ink.features(box.Min.X, box.Max.X, p.capTop, p.baseline, p.pitch)))
+	}
+	return line
+}
+
+// classify returns the templates ordered by distance to the features
+func (r *Recognizer) classify(features []float64) Glyph {
+	glyph := make(Glyph, len(r.templates))
+	for i, t := range r.templates {
+		var distance float64
+		for j, value := range features {
+			diff := value - t.features[j]
+			distance += diff * diff
+		}
+		glyph[i] = Candidate{Char: t.char, Distance: distance}
+	}
+	slices.SortFunc(glyph, func(a, b Candidate) int { return cmp.Compare(a.Distance, b.Distance) })
+	return glyph
+}
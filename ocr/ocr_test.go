@@ -0,0 +1,113 @@
+package ocr
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/JenswBE/encrypted-paper/assets"
+)
+
+const testAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ:-"
+
+func TestRecognize(t *testing.T) {
+	r, err := NewRecognizer(assets.GoMonoTTF, testAlphabet)
+	require.NoError(t, err)
+
+	// 8 points at 300 DPI
+	lines := []string{
+		"001: ABCDEFGH IJKLMNOP QRSTUVWX YZ234567 - K7Q2",
+		"002: 0123456789 - WXYZ",
+	}
+	img := renderText(t, 33, [][]string{lines})
+	require.Equal(t, lines, linesToStrings(r.Recognize(img)))
+}
+
+func TestRecognizeColumns(t *testing.T) {
+	r, err := NewRecognizer(assets.GoMonoTTF, testAlphabet)
+	require.NoError(t, err)
+
+	columns := [][]string{{"001: ABCD - EFGH", "002: IJKL - MNOP"}, {"003: QRST - UVWX"}}
+	img := renderText(t, 33, columns)
+	require.Equal(t, []string{"001: ABCD - EFGH", "002: IJKL - MNOP", "003: QRST - UVWX"}, linesToStrings(r.Recognize(img)))
+}
+
+func TestRecognizeRotated(t *testing.T) {
+	r, err := NewRecognizer(assets.GoMonoTTF, testAlphabet)
+	require.NoError(t, err)
+
+	lines := []string{
+		"001: ABCDEFGH IJKLMNOP QRSTUVWX YZ234567 - K7Q2",
+		"002: ABCDEFGH IJKLMNOP QRSTUVWX YZ234567 - K7Q2",
+		"003: ABCDEFGH IJKLMNOP QRSTUVWX YZ234567 - K7Q2",
+	}
+	img := rotate(renderText(t, 33, [][]string{lines}), 1.5)
+	require.Equal(t, lines, linesToStrings(r.Recognize(img)))
+}
+
+func TestRecognizeBlank(t *testing.T) {
+	r, err := NewRecognizer(assets.GoMonoTTF, testAlphabet)
+	require.NoError(t, err)
+	require.Empty(t, r.Recognize(image.NewGray(image.Rect(0, 0, 100, 100))))
+}
+
+func linesToStrings(lines []Line) []string {
+	output := make([]string, len(lines))
+	for i, line := range lines {
+		output[i] = line.String()
+	}
+	return output
+}
+
+// renderText renders the columns of lines in black on white, with a line height of 1.2 times the font size
+func renderText(t *testing.T, fontSize float64, columns [][]string) *image.Gray {
+	t.Helper()
+	parsedFont, err := opentype.Parse(assets.GoMonoTTF)
+	require.NoError(t, err)
+	face, err := opentype.NewFace(parsedFont, &opentype.FaceOptions{Size: fontSize, DPI: 72, Hinting: font.HintingNone})
+	require.NoError(t, err)
+	defer face.Close()
+
+	margin, lineHeight, columnWidth := int(2*fontSize), int(1.2*fontSize), int(30*fontSize)
+	rows := 0
+	for _, column := range columns {
+		rows = max(rows, len(column))
+	}
+	img := image.NewGray(image.Rect(0, 0, 2*margin+len(columns)*columnWidth, 2*margin+rows*lineHeight))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	drawer := &font.Drawer{Dst: img, Src: image.Black, Face: face}
+	for i, column := range columns {
+		for j, line := range column {
+			drawer.Dot = fixed.P(margin+i*columnWidth, margin+(j+1)*lineHeight)
+			drawer.DrawString(line)
+		}
+	}
+	return img
+}
+
+// rotate rotates the image clockwise around its center with nearest neighbour sampling
+func rotate(img *image.Gray, degrees float64) *image.Gray {
+	bounds := img.Bounds()
+	output := image.NewGray(bounds)
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	cx, cy := float64(bounds.Dx())/2, float64(bounds.Dy())/2
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			dx, dy := float64(x)-cx, float64(y)-cy
+			sx, sy := int(math.Round(cx+dx*cos+dy*sin)), int(math.Round(cy-dx*sin+dy*cos))
+			if image.Pt(sx, sy).In(bounds) {
+				output.SetGray(x, y, img.GrayAt(sx, sy))
+			} else {
+				output.SetGray(x, y, color.Gray{Y: 0xff})
+			}
+		}
+	}
+	return output
+}
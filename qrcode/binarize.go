@@ -0,0 +1,115 @@
+package qrcode
+
+import (
+	"image"
+	"image/color"
+)
+
+// bitmap is a binarized image
+type bitmap struct {
+	width, height int
+	dark          []bool // Row-major
+}
+
+func (b *bitmap) get(x, y int) bool {
+	return b.dark[y*b.width+x]
+}
+
+func (b *bitmap) inBounds(x, y int) bool {
+	return x >= 0 && x < b.width && y >= 0 && y < b.height
+}
+
+// luminance converts an image into a row-major slice of luminance values
+func luminance(img image.Image) (width, height int, lum []byte) {
+	bounds := img.Bounds()
+	width, height = bounds.Dx(), bounds.Dy()
+	lum = make([]byte, width*height)
+	switch src := img.(type) {
+	case *image.Gray:
+		for y := range height {
+			copy(lum[y*width:(y+1)*width], src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y+y):])
+		}
+	case *image.Paletted:
+		palette := make([]byte, len(src.Palette))
+		for i, c := range src.Palette {
+			palette[i] = color.GrayModel.Convert(c).(color.Gray).Y
+		}
+		for y := range height {
+			offset := src.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			for x := range width {
+				lum[y*width+x] = palette[src.Pix[offset+x]]
+			}
+		}
+	default:
+		for y := range height {
+			for x := range width {
+				lum[y*width+x] = color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			}
+		}
+	}
+	return width, height, lum
+}
+
+// Parameters for binarization, based on the HybridBinarizer of ZXing
+const (
+	binarizeBlockSize  = 8
+	binarizeMinDynamic = 24
+)
+
+// binarize converts the image into a bitmap, using a local threshold per block of pixels
+// to cope with uneven lighting on scans and photos.
+func binarize(img image.Image) *bitmap {
+	width, height, lum := luminance(img)
+	blocksX := (width + binarizeBlockSize - 1) / binarizeBlockSize
+	blocksY := (height + binarizeBlockSize - 1) / binarizeBlockSize
+
+	// Calculate black point of each block
+	blackPoints := make([]int, blocksX*blocksY)
+	for by := range blocksY {
+		for bx := range blocksX {
+			sum, count, minLum, maxLum := 0, 0, 255, 0
+			for y := by * binarizeBlockSize; y < min((by+1)*binarizeBlockSize, height); y++ {
+				for x := bx * binarizeBlockSize; x < min((bx+1)*binarizeBlockSize, width); x++ {
+					l := int(lum[y*width+x])
+					sum += l
+					count++
+					minLum = min(minLum, l)
+					maxLum = max(maxLum, l)
+				}
+			}
+			average := sum / count
+			if maxLum-minLum <= binarizeMinDynamic {
+				// Low contrast block is assumed to be light, unless neighbouring blocks indicate otherwise
+				average = minLum / 2
+				if by > 0 && bx > 0 {
+					neighbourAverage := (blackPoints[(by-1)*blocksX+bx] + 2*blackPoints[by*blocksX+bx-1] + blackPoints[(by-1)*blocksX+bx-1]) / 4
+					if minLum < neighbourAverage {
+						average = neighbourAverage
+					}
+				}
+			}
+			blackPoints[by*blocksX+bx] = average
+		}
+	}
+
+	// Threshold each block with the average black point of the surrounding 5x5 blocks
+	result := &bitmap{width: width, height: height, dark: make([]bool, width*height)}
+	for by := range blocksY {
+		for bx := range blocksX {
+			sum, count := 0, 0
+			for ny := max(by-2, 0); ny <= min(by+2, blocksY-1); ny++ {
+				for nx := max(bx-2, 0); nx <= min(bx+2, blocksX-1); nx++ {
+					sum += blackPoints[ny*blocksX+nx]
+					count++
+				}
+			}
+			threshold := sum / count
+			for y := by * binarizeBlockSize; y < min((by+1)*binarizeBlockSize, height); y++ {
+				for x := bx * binarizeBlockSize; x < min((bx+1)*binarizeBlockSize, width); x++ {
+					result.dark[y*width+x] = int(lum[y*width+x]) <= threshold
+				}
+			}
+		}
+	}
+	return result
+}
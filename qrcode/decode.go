@@ -0,0 +1,422 @@
+package qrcode
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// ErrNotFound is returned when no QR code could be located in the image
+var ErrNotFound = errors.New("no QR code found in image")
+
+// maxTriplesToTry limits the decoding attempts on noisy images with many finder pattern candidates
+const maxTriplesToTry = 50
+
+// Result is a decoded QR code
+type Result struct {
+	Data    []byte
+	Version int
+	Level   ECCLevel
+
+	// CorrectedCodewords contains the indexes of the codewords which were corrected by Reed-Solomon
+	// error correction. Indexes are in order of placement in the symbol.
+	CorrectedCodewords []int
+}
+
+// Decode locates and decodes a single QR code in byte mode in the image
+func Decode(img image.Image) (*Result, error) {
+	b := binarize(img)
+	candidates := findFinderPatterns(b)
+	if len(candidates) < 3 {
+		return nil, ErrNotFound
+	}
+	triples := groupFinderPatterns(candidates)
+	if len(triples) == 0 {
+		return nil, ErrNotFound
+	}
+
+	// Try all plausible triples
+	var errs []error
+	for _, triple := range triples[:min(len(triples), maxTriplesToTry)] {
+		result, err := decodeTriple(b, triple)
+		if err == nil {
+			return result, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("failed to decode QR code: %w", errs[0])
+}
+
+// DecodeAll locates and decodes all QR codes in byte mode in the image, e.g. a page with multiple QR codes.
+// Results are ordered from top to bottom and left to right. Failures contains an error for each located
+// QR code which couldn't be decoded, while other QR codes in the image could.
+func DecodeAll(img image.Image) (results []*Result, failures []error, err error) {
+	b := binarize(img)
+	candidates := findFinderPatterns(b)
+	if len(candidates) < 3 {
+		return nil, nil, ErrNotFound
+	}
+	triples := groupFinderPatterns(candidates)
+	if len(triples) == 0 {
+		return nil, nil, ErrNotFound
+	}
+
+	// Try all plausible triples, skipping finder patterns which are part of a decoded QR code.
+	// Finder patterns of adjacent QR codes might also form a plausible triple, so failures are expected.
+	type located struct {
+		result *Result
+		triple finderTriple
+	}
+	type failed struct {
+		err    error
+		triple finderTriple
+	}
+	var found []located
+	var failedTriples []failed
+	used := make(map[*finderPattern]bool)
+	for _, triple := range triples {
+		if used[triple.topLeft] || used[triple.topRight] || used[triple.bottomLeft] {
+			continue
+		}
+		if len(failedTriples) >= maxTriplesToTry {
+			break
+		}
+		result, err := decodeTriple(b, triple)
+		if err != nil {
+			failedTriples = append(failedTriples, failed{err: err, triple: triple})
+			continue
+		}
+		used[triple.topLeft], used[triple.topRight], used[triple.bottomLeft] = true, true, true
+		found = append(found, located{result: result, triple: triple})
+	}
+	if len(found) == 0 {
+		return nil, nil, fmt.Errorf("failed to decode QR code: %w", failedTriples[0].err)
+	}
+
+	// Failed triples with finder patterns outside of decoded QR codes are QR codes which couldn't be decoded.
+	// Failed triples with a finder pattern within a decoded QR code or a reported failure are combinations of
+	// finder patterns of adjacent QR codes or false positives in the data of a QR code.
+	areas := make([]finderTriple, 0, len(found)+len(failedTriples))
+	for _, f := range found {
+		areas = append(areas, f.triple)
+	}
+	for _, f := range failedTriples {
+		patterns := []*finderPattern{f.triple.topLeft, f.triple.topRight, f.triple.bottomLeft}
+		if slices.ContainsFunc(patterns, func(p *finderPattern) bool {
+			return used[p] || slices.ContainsFunc(areas, func(t finderTriple) bool { return t.contains(p.center) })
+		}) {
+			continue
+		}
+		areas = append(areas, f.triple)
+		failures = append(failures, fmt.Errorf("failed to decode QR code: %w", f.err))
+	}
+
+	// Order by position. QR codes are on the same row if their top left finder patterns overlap vertically.
+	slices.SortFunc(found, func(a, b located) int {
+		topLeftA, topLeftB := a.triple.topLeft, b.triple.topLeft
+		if math.Abs(topLeftA.center.y-topLeftB.center.y) > 7*max(topLeftA.moduleSize, topLeftB.moduleSize) {
+			return cmp.Compare(topLeftA.center.y, topLeftB.center.y)
+		}
+		return cmp.Compare(topLeftA.center.x, topLeftB.center.x)
+	})
+	results = make([]*Result, len(found))
+	for i, f := range found {
+		results[i] = f.result
+	}
+	return results, failures, nil
+}
+
+// decodeTriple samples and decodes the QR code located by the finder patterns
+func decodeTriple(b *bitmap, triple finderTriple) (*Result, error) {
+	dimension, moduleSize := triple.estimateDimension(b)
+	if dimension < sizeForVersion(MinVersion) || dimension > sizeForVersion(MaxVersion) {
+		return nil, fmt.Errorf("estimated dimension %d is invalid", dimension)
+	}
+
+	// Sample grid and decode. If decoding fails with the alignment pattern,
+	// retry with only the finder patterns in case a wrong alignment pattern was found.
+	var err error
+	for _, useAlignment := range []bool{true, false} {
+		var result *Result
+		result, err = sampleAndDecodeGrid(b, triple, dimension, moduleSize, useAlignment)
+		var mismatch versionMismatchError
+		if errors.As(err, &mismatch) {
+			// Version information is more reliable than the estimated dimension
+			dimension = sizeForVersion(mismatch.version)
+			result, err = sampleAndDecodeGrid(b, triple, dimension, moduleSize, useAlignment)
+		}
+		if err == nil {
+			return result, nil
+		}
+	}
+	return nil, err
+}
+
+func sampleAndDecodeGrid(b *bitmap, triple finderTriple, dimension int, moduleSize float64, useAlignment bool) (*Result, error) {
+	grid, err := sampleGrid(b, triple, dimension, moduleSize, useAlignment)
+	if err != nil {
+		return nil, err
+	}
+	return decodeGrid(grid, dimension)
+}
+
+// sampleGrid samples the center of each module of the QR code, correcting for perspective
+func sampleGrid(b *bitmap, triple finderTriple, dimension int, moduleSize float64, useAlignment bool) ([]bool, error) {
+	tl, tr, bl := triple.topLeft.center, triple.topRight.center, triple.bottomLeft.center
+	dim := float64(dimension)
+
+	// Locate bottom right alignment pattern, if any
+	bottomRight := point{x: tr.x - tl.x + bl.x, y: tr.y - tl.y + bl.y}
+	bottomRightModule := point{x: dim - 3.5, y: dim - 3.5}
+	if useAlignment && dimension > sizeForVersion(MinVersion) {
+		correction := 1 - 3/(dim-7)
+		estimate := point{x: tl.x + correction*(bottomRight.x-tl.x), y: tl.y + correction*(bottomRight.y-tl.y)}
+		for allowance := 4; allowance <= 16; allowance *= 2 {
+			if alignment, ok := findAlignmentPattern(b, estimate, moduleSize, allowance); ok {
+				bottomRight = alignment
+				bottomRightModule = point{x: dim - 6.5, y: dim - 6.5}
+				break
+			}
+		}
+	}
+
+	// Sample grid
+	transform := newQuadrilateralToQuadrilateral(
+		[4]point{{3.5, 3.5}, {dim - 3.5, 3.5}, bottomRightModule, {3.5, dim - 3.5}},
+		[4]point{tl, tr, bottomRight, bl},
+	)
+	grid := make([]bool, dimension*dimension)
+	for y := range dimension {
+		for x := range dimension {
+			p := transform.apply(point{x: float64(x) + 0.5, y: float64(y) + 0.5})
+			px, py := int(math.Floor(p.x)), int(math.Floor(p.y))
+			if !b.inBounds(px, py) {
+				// Allow small overshoot at the image border
+				px, py = min(max(px, 0), b.width-1), min(max(py, 0), b.height-1)
+				if math.Abs(float64(px)-p.x) > moduleSize || math.Abs(float64(py)-p.y) > moduleSize {
+					return nil, errors.New("QR code is partially outside of image")
+				}
+			}
+			grid[y*dimension+x] = b.get(px, py)
+		}
+	}
+	return grid, nil
+}
+
+// versionMismatchError indicates the version information doesn't match the estimated dimension
+type versionMismatchError struct {
+	version int
+}
+
+func (e versionMismatchError) Error() string {
+	return fmt.Sprintf("version information indicates version %d", e.version)
+}
+
+// Maximum number of bit errors in format and version information which can be corrected
+const maxInfoBitErrors = 3
+
+// decodeGrid decodes the sampled modules of a QR code
+func decodeGrid(grid []bool, dimension int) (*Result, error) {
+	get := func(x, y int) bool { return grid[y*dimension+x] }
+	version := (dimension - 17) / 4
+
+	// Read format information
+	var formatCopy1, formatCopy2 uint
+	for i := range 15 {
+		x1, y1, x2, y2 := formatBitPositions(dimension, i)
+		if get(x1, y1) {
+			formatCopy1 |= 1 << i
+		}
+		if get(x2, y2) {
+			formatCopy2 |= 1 << i
+		}
+	}
+	level, mask, err := decodeFormatBits(formatCopy1, formatCopy2)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read version information
+	if version >= 7 {
+		var versionCopy1, versionCopy2 uint
+		for i := range 18 {
+			a, b := dimension-11+i%3, i/3
+			if get(a, b) {
+				versionCopy1 |= 1 << i
+			}
+			if get(b, a) {
+				versionCopy2 |= 1 << i
+			}
+		}
+		if readVersion, ok := decodeVersionBits(versionCopy1, versionCopy2); ok && readVersion != version {
+			return nil, versionMismatchError{version: readVersion}
+		}
+	}
+
+	// Read codewords
+	m := newFunctionMatrix(version)
+	copy(m.modules, grid)
+	m.applyMask(mask)
+	codewords := make([]byte, numRawDataModules(version)/8)
+	for i, pos := range m.dataModulePositions()[:len(codewords)*8] {
+		if m.get(pos[0], pos[1]) {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+	}
+
+	// Correct errors
+	data, corrected, err := correctCodewords(codewords, version, level)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse segments
+	payload, err := parseSegments(data, version)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		Data:               payload,
+		Version:            version,
+		Level:              level,
+		CorrectedCodewords: corrected,
+	}, nil
+}
+
+// decodeFormatBits returns the error correction level and mask for the closest valid format information
+func decodeFormatBits(copy1, copy2 uint) (ECCLevel, int, error) {
+	bestDistance, bestLevel, bestMask := math.MaxInt, ECCLevelL, 0
+	for level := ECCLevelL; level <= ECCLevelH; level++ {
+		for mask := range 8 {
+			expected := formatBits(level, mask)
+			d := min(bits.OnesCount(expected^copy1), bits.OnesCount(expected^copy2))
+			if d < bestDistance {
+				bestDistance, bestLevel, bestMask = d, level, mask
+			}
+		}
+	}
+	if bestDistance > maxInfoBitErrors {
+		return 0, 0, errors.New("failed to read format information")
+	}
+	return bestLevel, bestMask, nil
+}
+
+// decodeVersionBits returns the closest valid version for the version information
+func decodeVersionBits(copy1, copy2 uint) (int, bool) {
+	bestDistance, bestVersion := math.MaxInt, 0
+	for version := 7; version <= MaxVersion; version++ {
+		expected := versionBits(version)
+		d := min(bits.OnesCount(expected^copy1), bits.OnesCount(expected^copy2))
+		if d < bestDistance {
+			bestDistance, bestVersion = d, version
+		}
+	}
+	return bestVersion, bestDistance <= maxInfoBitErrors
+}
+
+// correctCodewords deinterleaves the blocks, corrects errors and returns the data codewords
+// together with the indexes of the corrected codewords.
+func correctCodewords(codewords []byte, version int, level ECCLevel) ([]byte, []int, error) {
+	// Deinterleave blocks, keeping track of the original index of each codeword
+	dataLens := splitBlocks(version, level)
+	eccLen := eccCodewordsPerBlock[level][version]
+	blocks := make([][]byte, len(dataLens))
+	indexes := make([][]int, len(dataLens))
+	cursor := 0
+	for i := range dataLens[len(dataLens)-1] {
+		for j, dataLen := range dataLens {
+			if i < dataLen {
+				blocks[j] = append(blocks[j], codewords[cursor])
+				indexes[j] = append(indexes[j], cursor)
+				cursor++
+			}
+		}
+	}
+	for range eccLen {
+		for j := range blocks {
+			blocks[j] = append(blocks[j], codewords[cursor])
+			indexes[j] = append(indexes[j], cursor)
+			cursor++
+		}
+	}
+
+	// Correct each block
+	var data []byte
+	var corrected []int
+	for j, block := range blocks {
+		positions, err := rsDecode(block, eccLen)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to correct block %d: %w", j+1, err)
+		}
+		for _, pos := range positions {
+			corrected = append(corrected, indexes[j][pos])
+		}
+		data = append(data, block[:dataLens[j]]...)
+	}
+	slices.Sort(corrected)
+	return data, corrected, nil
+}
+
+// parseSegments extracts the payload from the data codewords. Only byte mode is supported.
+func parseSegments(data []byte, version int) ([]byte, error) {
+	br := &bitReader{data: data}
+	var payload []byte
+	for br.remaining() >= 4 {
+		mode := br.read(4)
+		switch mode {
+		case 0b0000:
+			// Terminator
+			return payload, nil
+		case 0b0100:
+			// Byte mode
+			count := int(br.read(charCountBits(version)))
+			if br.remaining() < count*8 {
+				return nil, fmt.Errorf("byte segment of %d bytes exceeds available data", count)
+			}
+			for range count {
+				payload = append(payload, byte(br.read(8)))
+			}
+		case 0b0111:
+			// Extended Channel Interpretation is ignored, as payload is treated as binary
+			designator := br.read(8)
+			switch {
+			case designator&0x80 == 0:
+			case designator&0xC0 == 0x80:
+				br.read(8)
+			case designator&0xE0 == 0xC0:
+				br.read(16)
+			default:
+				return nil, errors.New("invalid ECI designator")
+			}
+		default:
+			return nil, fmt.Errorf("unsupported segment mode %04b: only byte mode is supported", mode)
+		}
+	}
+	return payload, nil
+}
+
+type bitReader struct {
+	data   []byte
+	offset int
+}
+
+func (br *bitReader) remaining() int {
+	return len(br.data)*8 - br.offset
+}
+
+// read returns the next count bits, most significant bit first. Missing bits are read as zero.
+func (br *bitReader) read(count int) uint {
+	var result uint
+	for range count {
+		result <<= 1
+		if br.offset < len(br.data)*8 && br.data[br.offset/8]>>(7-br.offset%8)&1 != 0 {
+			result |= 1
+		}
+		br.offset++
+	}
+	return result
+}
@@ -0,0 +1,197 @@
+package qrcode
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func randomData(t *testing.T, seed int64, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	_, err := rand.New(rand.NewSource(seed)).Read(data)
+	require.NoError(t, err)
+	return data
+}
+
+// transformImage rotates the image by angle degrees around its center and scales it,
+// simulating a scan or photo. Output has a white border.
+func transformImage(src image.Image, angle, scale float64) *image.Gray {
+	bounds := src.Bounds()
+	srcW, srcH := float64(bounds.Dx()), float64(bounds.Dy())
+	dstSize := int(math.Hypot(srcW, srcH)*scale) + 40
+	dst := image.NewGray(image.Rect(0, 0, dstSize, dstSize))
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	for y := range dstSize {
+		for x := range dstSize {
+			// Inverse mapping from destination to source
+			dx, dy := (float64(x)-float64(dstSize)/2)/scale, (float64(y)-float64(dstSize)/2)/scale
+			sx, sy := cos*dx+sin*dy+srcW/2, -sin*dx+cos*dy+srcH/2
+			value := color.Gray{Y: 255}
+			if sx >= 0 && sy >= 0 && sx < srcW && sy < srcH {
+				value = color.GrayModel.Convert(src.At(bounds.Min.X+int(sx), bounds.Min.Y+int(sy))).(color.Gray)
+			}
+			dst.SetGray(x, y, value)
+		}
+	}
+	return dst
+}
+
+func TestDecodeRoundtrip(t *testing.T) {
+	for _, level := range []ECCLevel{ECCLevelL, ECCLevelM, ECCLevelQ, ECCLevelH} {
+		for _, version := range []int{1, 2, 6, 7, 10, 22, 40} {
+			// Encode
+			data := randomData(t, int64(version), Capacity(version, level))
+			code, err := Encode(data, level)
+			require.NoError(t, err)
+
+			// Decode
+			result, err := Decode(code.Image(3))
+			require.NoError(t, err, "version %d, level %s", version, level)
+
+			// Validate result
+			require.Equal(t, data, result.Data)
+			require.Equal(t, version, result.Version)
+			require.Equal(t, level, result.Level)
+			require.Empty(t, result.CorrectedCodewords)
+		}
+	}
+}
+
+func TestDecodeReferenceSymbols(t *testing.T) {
+	for _, symbol := range referenceSymbols {
+		result, err := Decode(readReferenceSymbol(t, symbol.file))
+		require.NoError(t, err, symbol.file)
+		require.Equal(t, referenceData(symbol.length), result.Data, symbol.file)
+		require.Equal(t, symbol.version, result.Version, symbol.file)
+		require.Equal(t, symbol.level, result.Level, symbol.file)
+	}
+}
+
+func TestDecodeRotatedAndScaled(t *testing.T) {
+	for _, tc := range []struct {
+		angle, scale float64
+	}{
+		{angle: 90, scale: 1},
+		{angle: 180, scale: 1.3},
+		{angle: 3, scale: 1},
+		{angle: -7, scale: 0.8},
+		{angle: 45, scale: 1.5},
+	} {
+		// Encode
+		data := randomData(t, 1, 500)
+		code, err := Encode(data, ECCLevelM)
+		require.NoError(t, err)
+
+		// Decode
+		result, err := Decode(transformImage(code.Image(4), tc.angle, tc.scale))
+		require.NoError(t, err, "angle %f, scale %f", tc.angle, tc.scale)
+		require.Equal(t, data, result.Data)
+	}
+}
+
+func TestDecodeReportsCorrectedCodewords(t *testing.T) {
+	// Encode
+	data := randomData(t, 1, 100)
+	code, err := Encode(data, ECCLevelH)
+	require.NoError(t, err)
+
+	// Corrupt the first data codewords
+	positions := code.matrix.dataModulePositions()
+	for i := range 3 * 8 {
+		x, y := positions[i][0], positions[i][1]
+		code.matrix.set(x, y, !code.matrix.get(x, y))
+	}
+
+	// Decode
+	result, err := Decode(code.Image(3))
+	require.NoError(t, err)
+	require.Equal(t, data, result.Data)
+	require.Equal(t, []int{0, 1, 2}, result.CorrectedCodewords)
+}
+
+func TestDecodeWithoutQRCode(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 200, 200))
+	_, err := Decode(img)
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRSDecodeCorrectsRandomErrors(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for range 500 {
+		// Encode block
+		eccLen := 2 + rng.Intn(29)
+		data := randomData(t, rng.Int63(), 1+rng.Intn(120))
+		block := append(append([]byte{}, data...), rsRemainder(data, rsGenerator(eccLen))...)
+		original := append([]byte{}, block...)
+
+		// Add errors
+		numErrors := rng.Intn(eccLen/2 + 1)
+		for _, pos := range rng.Perm(len(block))[:numErrors] {
+			block[pos] ^= byte(1 + rng.Intn(255))
+		}
+
+		// Correct errors
+		positions, err := rsDecode(block, eccLen)
+		require.NoError(t, err)
+		require.Equal(t, original, block)
+		require.Len(t, positions, numErrors)
+	}
+}
+
+func TestDecodeAllFindsMultipleQRCodes(t *testing.T) {
+	// Draw 2x2 grid of QR codes of different versions
+	var codes []*Code
+	var datas [][]byte
+	for i, size := range []int{100, 500, 1000, 50} {
+		datas = append(datas, randomData(t, int64(i), size))
+		code, err := Encode(datas[i], ECCLevelM)
+		require.NoError(t, err)
+		codes = append(codes, code)
+	}
+	const moduleSize, cellSize = 3, 400
+	img := image.NewGray(image.Rect(0, 0, 2*cellSize, 2*cellSize))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	for i, code := range codes {
+		codeImg := code.Image(moduleSize)
+		offset := image.Pt(i%2*cellSize, i/2*cellSize)
+		for y := range codeImg.Bounds().Dy() {
+			for x := range codeImg.Bounds().Dx() {
+				img.Set(offset.X+x, offset.Y+y, codeImg.At(x, y))
+			}
+		}
+	}
+
+	// Decode
+	results, failures, err := DecodeAll(transformImage(img, 3, 1))
+	require.NoError(t, err)
+	require.Empty(t, failures)
+	require.Len(t, results, len(codes))
+	for i, result := range results {
+		require.Equal(t, datas[i], result.Data)
+	}
+
+	// Damage center of second QR code, keeping its finder patterns intact
+	for y := 60; y < 160; y++ {
+		for x := cellSize + 60; x < cellSize+160; x++ {
+			img.SetGray(x, y, color.Gray{Y: 0})
+		}
+	}
+	results, failures, err = DecodeAll(img)
+	require.NoError(t, err)
+	require.Len(t, failures, 1)
+	require.Len(t, results, len(codes)-1)
+	for i, result := range results {
+		require.Equal(t, datas[[]int{0, 2, 3}[i]], result.Data)
+	}
+
+	// Without QR code
+	_, _, err = DecodeAll(image.NewGray(image.Rect(0, 0, 200, 200)))
+	require.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,440 @@
+package qrcode
+
+import (
+	"math"
+	"slices"
+)
+
+// finderPattern is a candidate center of a finder pattern
+type finderPattern struct {
+	center     point
+	moduleSize float64
+	count      int // Number of times the pattern was confirmed
+}
+
+// Parameters for finder pattern detection, based on the FinderPatternFinder of ZXing
+const (
+	minFinderConfirmations = 2
+	maxFinderCandidates    = 30
+)
+
+// findFinderPatterns scans all rows of the bitmap for the 1:1:3:1:1 ratio of finder patterns
+// and confirms them by cross checking vertically and horizontally.
+func findFinderPatterns(b *bitmap) []*finderPattern {
+	var candidates []*finderPattern
+	for y := range b.height {
+		var counts [5]int
+		state := 0 // Even states are dark, odd states are light
+		for x := 0; x <= b.width; x++ {
+			dark := x < b.width && b.get(x, y)
+			switch {
+			case dark && state%2 == 1:
+				// Light to dark
+				state++
+				counts[state]++
+			case dark || state%2 == 1:
+				// Same color
+				counts[state]++
+			case state == 0 && counts[0] == 0:
+				// Waiting for first dark module
+			case state < 4:
+				// Dark to light
+				state++
+				counts[state]++
+			default:
+				// Dark to light after 5 runs
+				if foundPatternCross(counts) {
+					if fp := confirmFinderPattern(b, counts, x, y); fp != nil {
+						candidates = addFinderCandidate(candidates, fp)
+					}
+				}
+				counts = [5]int{counts[2], counts[3], counts[4], 1, 0}
+				state = 3
+			}
+		}
+	}
+
+	// Filter and sort candidates
+	candidates = slices.DeleteFunc(candidates, func(fp *finderPattern) bool { return fp.count < minFinderConfirmations })
+	slices.SortStableFunc(candidates, func(a, b *finderPattern) int { return b.count - a.count })
+	if len(candidates) > maxFinderCandidates {
+		candidates = candidates[:maxFinderCandidates]
+	}
+	return candidates
+}
+
+// foundPatternCross returns true if the counts match the 1:1:3:1:1 ratio of a finder pattern
+func foundPatternCross(counts [5]int) bool {
+	total := 0
+	for _, c := range counts {
+		if c == 0 {
+			return false
+		}
+		total += c
+	}
+	if total < 7 {
+		return false
+	}
+	moduleSize := float64(total) / 7
+	maxVariance := moduleSize / 2
+	return math.Abs(moduleSize-float64(counts[0])) < maxVariance &&
+		math.Abs(moduleSize-float64(counts[1])) < maxVariance &&
+		math.Abs(3*moduleSize-float64(counts[2])) < 3*maxVariance &&
+		math.Abs(moduleSize-float64(counts[3])) < maxVariance &&
+		math.Abs(moduleSize-float64(counts[4])) < maxVariance
+}
+
+// confirmFinderPattern cross checks a horizontal match, which ended just before x, in the other directions
+func confirmFinderPattern(b *bitmap, counts [5]int, x, y int) *finderPattern {
+	total := counts[0] + counts[1] + counts[2] + counts[3] + counts[4]
+	centerX := float64(x-counts[4]-counts[3]) - float64(counts[2])/2
+	centerY, ok := crossCheck(b, centerX, float64(y), 0, 1, counts[2], total)
+	if !ok {
+		return nil
+	}
+	centerX, ok = crossCheck(b, centerX, centerY, 1, 0, counts[2], total)
+	if !ok {
+		return nil
+	}
+	centerY, ok = crossCheck(b, centerX, centerY, 0, 1, counts[2], total)
+	if !ok {
+		return nil
+	}
+	return &finderPattern{
+		center:     point{x: centerX, y: centerY},
+		moduleSize: float64(total) / 7,
+		count:      1,
+	}
+}
+
+// crossCheck walks from the center in direction (dx, dy) and the opposite direction to validate the
+// 1:1:3:1:1 ratio. Returns the corrected center coordinate along the walked axis.
+func crossCheck(b *bitmap, centerX, centerY float64, dx, dy, maxCount, originalTotal int) (float64, bool) {
+	startX, startY := int(centerX), int(centerY)
+	if !b.inBounds(startX, startY) || !b.get(startX, startY) {
+		return 0, false
+	}
+	var counts [5]int
+
+	// Walk backwards
+	x, y := startX, startY
+	for state := 2; state >= 0; state-- {
+		for b.inBounds(x, y) && b.get(x, y) == (state%2 == 0) && counts[state] <= 3*maxCount {
+			counts[state]++
+			x, y = x-dx, y-dy
+		}
+		if counts[state] == 0 || counts[state] > 3*maxCount {
+			return 0, false
+		}
+	}
+
+	// Walk forwards
+	x, y = startX+dx, startY+dy
+	for state := 2; state <= 4; state++ {
+		for b.inBounds(x, y) && b.get(x, y) == (state%2 == 0) && counts[state] <= 3*maxCount {
+			counts[state]++
+			x, y = x+dx, y+dy
+		}
+		if counts[state] == 0 || counts[state] > 3*maxCount {
+			return 0, false
+		}
+	}
+
+	// Validate ratio and total size
+	total := counts[0] + counts[1] + counts[2] + counts[3] + counts[4]
+	if 5*abs(total-originalTotal) >= 2*originalTotal || !foundPatternCross(counts) {
+		return 0, false
+	}
+	end := x*dx + y*dy // Coordinate along walked axis
+	return float64(end-counts[4]-counts[3]) - float64(counts[2])/2, true
+}
+
+// addFinderCandidate merges the pattern into an existing candidate at the same location or adds it as a new candidate
+func addFinderCandidate(candidates []*finderPattern, fp *finderPattern) []*finderPattern {
+	for _, c := range candidates {
+		if math.Abs(c.center.x-fp.center.x) <= c.moduleSize && math.Abs(c.center.y-fp.center.y) <= c.moduleSize {
+			sizeDiff := math.Abs(c.moduleSize - fp.moduleSize)
+			if sizeDiff <= 1 || sizeDiff <= c.moduleSize {
+				n := float64(c.count)
+				c.center = point{x: (c.center.x*n + fp.center.x) / (n + 1), y: (c.center.y*n + fp.center.y) / (n + 1)}
+				c.moduleSize = (c.moduleSize*n + fp.moduleSize) / (n + 1)
+				c.count++
+				return candidates
+			}
+		}
+	}
+	return append(candidates, fp)
+}
+
+// finderTriple contains the finder patterns of a single QR code
+type finderTriple struct {
+	topLeft, topRight, bottomLeft *finderPattern
+	score                         float64 // Lower is better
+}
+
+// Parameters for grouping finder patterns
+const (
+	maxModuleSizeRatio = 1.5
+	maxSideDifference  = 0.25
+	maxHypotenuseError = 0.15
+)
+
+// groupFinderPatterns returns all combinations of 3 finder patterns which could form a QR code, best first
+func groupFinderPatterns(candidates []*finderPattern) []finderTriple {
+	var triples []finderTriple
+	for i := range candidates {
+		for j := i + 1; j < len(candidates); j++ {
+			for k := j + 1; k < len(candidates); k++ {
+				if triple, ok := orderFinderPatterns(candidates[i], candidates[j], candidates[k]); ok {
+					triples = append(triples, triple)
+				}
+			}
+		}
+	}
+	slices.SortStableFunc(triples, func(a, b finderTriple) int {
+		switch {
+		case a.score < b.score:
+			return -1
+		case a.score > b.score:
+			return 1
+		default:
+			return 0
+		}
+	})
+	return triples
+}
+
+// orderFinderPatterns identifies the top left, top right and bottom left pattern.
+// Returns false if the patterns don't form a plausible right isosceles triangle.
+func orderFinderPatterns(a, b, c *finderPattern) (finderTriple, bool) {
+	// Validate module sizes
+	minSize := min(a.moduleSize, b.moduleSize, c.moduleSize)
+	maxSize := max(a.moduleSize, b.moduleSize, c.moduleSize)
+	if maxSize > minSize*maxModuleSizeRatio {
+		return finderTriple{}, false
+	}
+
+	// Top left pattern is opposite to the longest side
+	ab, bc, ac := distance(a.center, b.center), distance(b.center, c.center), distance(a.center, c.center)
+	var topLeft, p1, p2 *finderPattern
+	var side1, side2, hypotenuse float64
+	switch {
+	case bc >= ab && bc >= ac:
+		topLeft, p1, p2, side1, side2, hypotenuse = a, b, c, ab, ac, bc
+	case ac >= ab && ac >= bc:
+		topLeft, p1, p2, side1, side2, hypotenuse = b, a, c, ab, bc, ac
+	default:
+		topLeft, p1, p2, side1, side2, hypotenuse = c, a, b, ac, bc, ab
+	}
+
+	// Validate shape
+	moduleSize := (a.moduleSize + b.moduleSize + c.moduleSize) / 3
+	if min(side1, side2) < 10*moduleSize || max(side1, side2) > float64(sizeForVersion(MaxVersion))*moduleSize {
+		return finderTriple{}, false
+	}
+	sideDifference := math.Abs(side1-side2) / max(side1, side2)
+	hypotenuseError := math.Abs(hypotenuse-math.Hypot(side1, side2)) / hypotenuse
+	if sideDifference > maxSideDifference || hypotenuseError > maxHypotenuseError {
+		return finderTriple{}, false
+	}
+
+	// Top right pattern is clockwise from bottom left pattern (y axis points down)
+	cross := (p1.center.x-topLeft.center.x)*(p2.center.y-topLeft.center.y) - (p1.center.y-topLeft.center.y)*(p2.center.x-topLeft.center.x)
+	if cross < 0 {
+		p1, p2 = p2, p1
+	}
+	return finderTriple{
+		topLeft:    topLeft,
+		topRight:   p1,
+		bottomLeft: p2,
+		score:      sideDifference + hypotenuseError + (maxSize-minSize)/maxSize,
+	}, true
+}
+
+// contains returns true if the point lies within the QR code located by the finder patterns
+func (t finderTriple) contains(p point) bool {
+	// Express point in the axes from the top left to the top right and bottom left pattern.
+	// The centers of the finder patterns are 3.5 modules from the edges of the QR code.
+	ux, uy := t.topRight.center.x-t.topLeft.center.x, t.topRight.center.y-t.topLeft.center.y
+	vx, vy := t.bottomLeft.center.x-t.topLeft.center.x, t.bottomLeft.center.y-t.topLeft.center.y
+	det := ux*vy - uy*vx
+	if det == 0 {
+		return false
+	}
+	px, py := p.x-t.topLeft.center.x, p.y-t.topLeft.center.y
+	u, v := (px*vy-py*vx)/det, (ux*py-uy*px)/det
+	moduleSize := (t.topLeft.moduleSize + t.topRight.moduleSize + t.bottomLeft.moduleSize) / 3
+	marginU, marginV := 3.5*moduleSize/math.Hypot(ux, uy), 3.5*moduleSize/math.Hypot(vx, vy)
+	return u >= -marginU && u <= 1+marginU && v >= -marginV && v <= 1+marginV
+}
+
+func distance(a, b point) float64 {
+	return math.Hypot(a.x-b.x, a.y-b.y)
+}
+
+// estimateDimension returns the number of modules on each side of the QR code, based on the finder patterns
+func (t finderTriple) estimateDimension(b *bitmap) (int, float64) {
+	moduleSize := t.estimateModuleSize(b)
+	modulesTop := distance(t.topLeft.center, t.topRight.center) / moduleSize
+	modulesLeft := distance(t.topLeft.center, t.bottomLeft.center) / moduleSize
+	dimension := int(math.Round((modulesTop+modulesLeft)/2)) + 7
+	switch dimension % 4 {
+	case 0:
+		dimension++
+	case 2:
+		dimension--
+	case 3:
+		dimension -= 2
+	}
+	return dimension, moduleSize
+}
+
+// estimateModuleSize measures the finder patterns along the lines between their centers.
+// This is more accurate than the horizontal runs used for detection in case the QR code is rotated.
+func (t finderTriple) estimateModuleSize(b *bitmap) float64 {
+	sizes := []float64{
+		finderWidthAlong(b, t.topLeft.center, t.topRight.center),
+		finderWidthAlong(b, t.topRight.center, t.topLeft.center),
+		finderWidthAlong(b, t.topLeft.center, t.bottomLeft.center),
+		finderWidthAlong(b, t.bottomLeft.center, t.topLeft.center),
+	}
+	sum, count := 0.0, 0
+	for _, size := range sizes {
+		if size > 0 {
+			sum += size
+			count++
+		}
+	}
+	if count == 0 {
+		return (t.topLeft.moduleSize + t.topRight.moduleSize + t.bottomLeft.moduleSize) / 3
+	}
+	return sum / float64(count) / 7
+}
+
+// finderWidthStep is the step size in pixels used to measure the finder patterns
+const finderWidthStep = 0.25
+
+// finderWidthAlong returns the width of the finder pattern centered at from, measured along the line
+// towards to. Returns 0 if the width can't be measured.
+func finderWidthAlong(b *bitmap, from, to point) float64 {
+	length := distance(from, to)
+	if length == 0 {
+		return 0
+	}
+	dx, dy := (to.x-from.x)/length, (to.y-from.y)/length
+
+	// Walk in both directions until passing the dark center, light ring and dark ring
+	width := 0.0
+	for _, direction := range []float64{1, -1} {
+		transitions := 0
+		prevDark := true
+		for step := 0.0; step < length; step += finderWidthStep {
+			x, y := int(from.x+direction*dx*step), int(from.y+direction*dy*step)
+			if !b.inBounds(x, y) {
+				return 0
+			}
+			if dark := b.get(x, y); dark != prevDark {
+				transitions++
+				prevDark = dark
+			}
+			if transitions == 3 {
+				width += step - finderWidthStep/2 // Transition is between previous and current step
+				break
+			}
+		}
+		if transitions != 3 {
+			return 0
+		}
+	}
+	return width
+}
+
+// findAlignmentPattern searches for the 1:1:1 pattern of an alignment pattern around the estimated center
+func findAlignmentPattern(b *bitmap, estimate point, moduleSize float64, allowance int) (point, bool) {
+	radius := int(float64(allowance) * moduleSize)
+	minX, maxX := max(int(estimate.x)-radius, 0), min(int(estimate.x)+radius, b.width-1)
+	minY, maxY := max(int(estimate.y)-radius, 0), min(int(estimate.y)+radius, b.height-1)
+	if maxX-minX < int(3*moduleSize) || maxY-minY < int(3*moduleSize) {
+		return point{}, false
+	}
+
+	var best point
+	bestDistance := math.Inf(1)
+	for y := minY; y <= maxY; y++ {
+		// Find light-dark-light runs in row
+		runStart := -1
+		for x := minX; x <= maxX; x++ {
+			dark := b.get(x, y)
+			if dark && runStart < 0 && x > minX && !b.get(x-1, y) {
+				runStart = x
+			} else if !dark && runStart >= 0 {
+				runLen := x - runStart
+				runStart = -1
+				if !plausibleAlignmentRun(float64(runLen), moduleSize) {
+					continue
+				}
+				center, ok := confirmAlignmentPattern(b, float64(x)-float64(runLen)/2, float64(y), moduleSize)
+				if !ok {
+					continue
+				}
+				if d := distance(center, estimate); d < bestDistance {
+					best, bestDistance = center, d
+				}
+			}
+		}
+	}
+	return best, !math.IsInf(bestDistance, 1)
+}
+
+// plausibleAlignmentRun returns true if the run could be a single module of an alignment pattern.
+// Runs up to sqrt(2) times the module size are expected when the QR code is rotated.
+func plausibleAlignmentRun(runLen, moduleSize float64) bool {
+	return runLen >= moduleSize/2 && runLen <= moduleSize*2
+}
+
+// confirmAlignmentPattern validates the center of an alignment pattern is surrounded by a light and dark ring
+func confirmAlignmentPattern(b *bitmap, centerX, centerY, moduleSize float64) (point, bool) {
+	maxCount := int(2 * moduleSize)
+	checkAxis := func(cx, cy float64, dx, dy int) (float64, bool) {
+		x, y := int(cx), int(cy)
+		if !b.inBounds(x, y) || !b.get(x, y) {
+			return 0, false
+		}
+		var counts [3]int // Light before, dark center, light after
+		for x, y = int(cx), int(cy); b.inBounds(x, y) && b.get(x, y) && counts[1] <= maxCount; x, y = x-dx, y-dy {
+			counts[1]++
+		}
+		for ; b.inBounds(x, y) && !b.get(x, y) && counts[0] <= maxCount; x, y = x-dx, y-dy {
+			counts[0]++
+		}
+		if !b.inBounds(x, y) || !b.get(x, y) {
+			return 0, false // Outer dark ring missing
+		}
+		for x, y = int(cx)+dx, int(cy)+dy; b.inBounds(x, y) && b.get(x, y) && counts[1] <= maxCount; x, y = x+dx, y+dy {
+			counts[1]++
+		}
+		end := x*dx + y*dy
+		for ; b.inBounds(x, y) && !b.get(x, y) && counts[2] <= maxCount; x, y = x+dx, y+dy {
+			counts[2]++
+		}
+		if !b.inBounds(x, y) || !b.get(x, y) {
+			return 0, false // Outer dark ring missing
+		}
+		mean := float64(counts[0]+counts[1]+counts[2]) / 3
+		for _, c := range counts {
+			if !plausibleAlignmentRun(float64(c), moduleSize) || math.Abs(float64(c)-mean) >= mean/2 {
+				return 0, false
+			}
+		}
+		return float64(end) - float64(counts[1])/2, true
+	}
+	newY, ok := checkAxis(centerX, centerY, 0, 1)
+	if !ok {
+		return point{}, false
+	}
+	newX, ok := checkAxis(centerX, newY, 1, 0)
+	if !ok {
+		return point{}, false
+	}
+	return point{x: newX, y: newY}, true
+}
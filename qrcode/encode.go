@@ -0,0 +1,260 @@
+package qrcode
+
+import (
+	"fmt"
+	"math"
+)
+
+// Code is a QR code symbol
+type Code struct {
+	Version int
+	Level   ECCLevel
+	Mask    int
+	matrix  *matrix
+}
+
+// Size returns the number of modules on each side of the QR code, excluding quiet zone
+func (c *Code) Size() int {
+	return c.matrix.size
+}
+
+// Dark returns true if the module at (x, y) is dark
+func (c *Code) Dark(x, y int) bool {
+	return c.matrix.get(x, y)
+}
+
+// Encode encodes the data in byte mode into the smallest QR code version which fits the data.
+// Mask is selected automatically based on the lowest penalty score.
+func Encode(data []byte, level ECCLevel) (*Code, error) {
+	if level > ECCLevelH {
+		return nil, fmt.Errorf("invalid error correction level %d", level)
+	}
+
+	// Select smallest version
+	version := MinVersion
+	for ; version <= MaxVersion; version++ {
+		if len(data) <= Capacity(version, level) {
+			break
+		}
+	}
+	if version > MaxVersion {
+		return nil, fmt.Errorf("data of %d bytes is too long for a QR code with error correction level %s (max %d bytes)", len(data), level, Capacity(MaxVersion, level))
+	}
+
+	// Build codewords
+	dataCodewords := encodeByteMode(data, version, level)
+	codewords := addECCAndInterleave(dataCodewords, version, level)
+
+	// Draw codewords
+	m := newFunctionMatrix(version)
+	positions := m.dataModulePositions()
+	for i, pos := range positions {
+		// Remainder bits are left light
+		if i/8 < len(codewords) {
+			m.set(pos[0], pos[1], codewords[i/8]>>(7-i%8)&1 != 0)
+		}
+	}
+
+	// Select mask with lowest penalty
+	bestMask, bestPenalty := 0, math.MaxInt
+	for mask := range 8 {
+		m.applyMask(mask)
+		m.drawFormatBits(formatBits(level, mask))
+		if penalty := m.penaltyScore(); penalty < bestPenalty {
+			bestMask, bestPenalty = mask, penalty
+		}
+		m.applyMask(mask)
+	}
+	m.applyMask(bestMask)
+	m.drawFormatBits(formatBits(level, bestMask))
+
+	return &Code{
+		Version: version,
+		Level:   level,
+		Mask:    bestMask,
+		matrix:  m,
+	}, nil
+}
+
+// encodeByteMode returns the data codewords for data in byte mode, including terminator and padding
+func encodeByteMode(data []byte, version int, level ECCLevel) []byte {
+	capacityBits := numDataCodewords(version, level) * 8
+	bb := &bitBuffer{}
+	bb.append(0b0100, 4) // Byte mode
+	bb.append(uint(len(data)), charCountBits(version))
+	for _, b := range data {
+		bb.append(uint(b), 8)
+	}
+
+	// Add terminator and pad to byte boundary
+	bb.append(0, min(4, capacityBits-bb.len()))
+	bb.append(0, (8-bb.len()%8)%8)
+
+	// Add pad bytes
+	for padByte := uint(0xEC); bb.len() < capacityBits; padByte ^= 0xEC ^ 0x11 {
+		bb.append(padByte, 8)
+	}
+	return bb.bytes()
+}
+
+// addECCAndInterleave splits the data codewords in blocks, adds error correction codewords
+// to each block and interleaves the blocks.
+func addECCAndInterleave(data []byte, version int, level ECCLevel) []byte {
+	blocks := splitBlocks(version, level)
+	eccLen := eccCodewordsPerBlock[level][version]
+	generator := rsGenerator(eccLen)
+	dataBlocks := make([][]byte, len(blocks))
+	eccBlocks := make([][]byte, len(blocks))
+	var cursor int
+	for i, dataLen := range blocks {
+		dataBlocks[i] = data[cursor : cursor+dataLen]
+		eccBlocks[i] = rsRemainder(dataBlocks[i], generator)
+		cursor += dataLen
+	}
+
+	// Interleave data and error correction codewords
+	result := make([]byte, 0, numRawDataModules(version)/8)
+	for i := range blocks[len(blocks)-1] {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := range eccLen {
+		for _, block := range eccBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// splitBlocks returns the number of data codewords in each block.
+// Short blocks come first and have exactly one data codeword less than the long blocks.
+func splitBlocks(version int, level ECCLevel) []int {
+	numBlocks := numErrorCorrectionBlocks[level][version]
+	rawCodewords := numRawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockDataLen := rawCodewords/numBlocks - eccCodewordsPerBlock[level][version]
+	result := make([]int, numBlocks)
+	for i := range result {
+		result[i] = shortBlockDataLen
+		if i >= numShortBlocks {
+			result[i]++
+		}
+	}
+	return result
+}
+
+// Penalty weights, see ISO/IEC 18004 section 7.8.3
+const (
+	penaltyN1 = 3
+	penaltyN2 = 3
+	penaltyN3 = 40
+	penaltyN4 = 10
+)
+
+// penaltyScore calculates the penalty score used to select the mask
+func (m *matrix) penaltyScore() int {
+	result := 0
+	size := m.size
+
+	// Adjacent modules in row/column of same color and finder-like patterns
+	for _, horizontal := range []bool{true, false} {
+		for i := range size {
+			get := func(j int) bool {
+				if horizontal {
+					return m.get(j, i)
+				}
+				return m.get(i, j)
+			}
+			runLen := 0
+			for j := range size {
+				if j > 0 && get(j) == get(j-1) {
+					runLen++
+				} else {
+					runLen = 1
+				}
+				if runLen == 5 {
+					result += penaltyN1
+				} else if runLen > 5 {
+					result++
+				}
+
+				// Pattern 1:1:3:1:1 preceded or followed by 4 light modules
+				if j >= 6 && matchesFinderLike(get, j-6, size) {
+					result += penaltyN3
+				}
+			}
+		}
+	}
+
+	// 2x2 blocks of same color
+	for y := range size - 1 {
+		for x := range size - 1 {
+			c := m.get(x, y)
+			if c == m.get(x+1, y) && c == m.get(x, y+1) && c == m.get(x+1, y+1) {
+				result += penaltyN2
+			}
+		}
+	}
+
+	// Balance of dark and light modules
+	dark := 0
+	for _, module := range m.modules {
+		if module {
+			dark++
+		}
+	}
+	total := size * size
+	k := abs(dark*20-total*10) / total // Deviation from 50% in steps of 5%
+	result += k * penaltyN4
+	return result
+}
+
+var finderLikePattern = [7]bool{true, false, true, true, true, false, true}
+
+// matchesFinderLike returns true if the 7 modules starting at start match 1011101,
+// preceded or followed by 4 light modules. Modules outside the symbol are light, as they're part of the quiet zone.
+func matchesFinderLike(get func(int) bool, start, size int) bool {
+	for i, dark := range finderLikePattern {
+		if get(start+i) != dark {
+			return false
+		}
+	}
+	isLight := func(from, to int) bool {
+		for i := max(from, 0); i < min(to, size); i++ {
+			if get(i) {
+				return false
+			}
+		}
+		return true
+	}
+	return isLight(start-4, start) || isLight(start+7, start+11)
+}
+
+type bitBuffer struct {
+	data   []byte
+	length int
+}
+
+func (bb *bitBuffer) len() int {
+	return bb.length
+}
+
+// append adds the lowest count bits of value, most significant bit first
+func (bb *bitBuffer) append(value uint, count int) {
+	for i := count - 1; i >= 0; i-- {
+		if bb.length%8 == 0 {
+			bb.data = append(bb.data, 0)
+		}
+		if value>>i&1 != 0 {
+			bb.data[len(bb.data)-1] |= 1 << (7 - bb.length%8)
+		}
+		bb.length++
+	}
+}
+
+func (bb *bitBuffer) bytes() []byte {
+	return bb.data
+}
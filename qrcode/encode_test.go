@@ -0,0 +1,114 @@
+package qrcode
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// referenceSymbols are generated with ZXing (Go port github.com/makiuchi-d/gozxing v0.1.1) in byte mode,
+// with 4 pixels per module and a quiet zone of 4 modules. Data is a prefix of repeated referenceText.
+var referenceSymbols = []struct {
+	file    string
+	length  int
+	level   ECCLevel
+	version int
+	mask    int
+}{
+	{file: "zxing-v1-L.png", length: 5, level: ECCLevelL, version: 1, mask: 7},
+	{file: "zxing-v2-M.png", length: 20, level: ECCLevelM, version: 2, mask: 6},
+	{file: "zxing-v8-Q.png", length: 90, level: ECCLevelQ, version: 8, mask: 4},
+	{file: "zxing-v12-H.png", length: 150, level: ECCLevelH, version: 12, mask: 2},
+	{file: "zxing-v22-L.png", length: 1000, level: ECCLevelL, version: 22, mask: 2},
+	{file: "zxing-v40-M.png", length: 2300, level: ECCLevelM, version: 40, mask: 2},
+}
+
+const referenceText = "the quick brown fox jumps over the lazy dog. "
+
+func referenceData(length int) []byte {
+	return []byte(strings.Repeat(referenceText, length/len(referenceText)+1)[:length])
+}
+
+func readReferenceSymbol(t *testing.T, file string) image.Image {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", file))
+	require.NoError(t, err)
+	defer f.Close()
+	img, err := png.Decode(f)
+	require.NoError(t, err)
+	return img
+}
+
+func TestCapacity(t *testing.T) {
+	// See https://www.thonky.com/qr-code-tutorial/character-capacities
+	require.Equal(t, 17, Capacity(1, ECCLevelL))
+	require.Equal(t, 14, Capacity(1, ECCLevelM))
+	require.Equal(t, 11, Capacity(1, ECCLevelQ))
+	require.Equal(t, 7, Capacity(1, ECCLevelH))
+	require.Equal(t, 2953, Capacity(40, ECCLevelL))
+	require.Equal(t, 2331, Capacity(40, ECCLevelM))
+	require.Equal(t, 1663, Capacity(40, ECCLevelQ))
+	require.Equal(t, 1273, Capacity(40, ECCLevelH))
+}
+
+func TestParseECCLevel(t *testing.T) {
+	for input, expected := range map[string]ECCLevel{"L": ECCLevelL, "m": ECCLevelM, "Q": ECCLevelQ, " h ": ECCLevelH} {
+		level, err := ParseECCLevel(input)
+		require.NoError(t, err)
+		require.Equal(t, expected, level)
+	}
+	_, err := ParseECCLevel("X")
+	require.Error(t, err)
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	// See https://www.thonky.com/qr-code-tutorial/format-version-tables
+	require.Equal(t, uint(0b111011111000100), formatBits(ECCLevelL, 0))
+	require.Equal(t, uint(0b000100000111011), formatBits(ECCLevelH, 7))
+	require.Equal(t, uint(0b000111110010010100), versionBits(7))
+	require.Equal(t, uint(0b101000110001101001), versionBits(40))
+}
+
+func TestEncodeSelectsSmallestVersion(t *testing.T) {
+	for _, level := range []ECCLevel{ECCLevelL, ECCLevelM, ECCLevelQ, ECCLevelH} {
+		for _, version := range []int{1, 9, 10, 27, 40} {
+			code, err := Encode(make([]byte, Capacity(version, level)), level)
+			require.NoError(t, err)
+			require.Equal(t, version, code.Version)
+			require.Equal(t, sizeForVersion(version), code.Size())
+		}
+	}
+
+	_, err := Encode(make([]byte, Capacity(MaxVersion, ECCLevelL)+1), ECCLevelL)
+	require.Error(t, err)
+}
+
+func TestEncodeMatchesReferenceSymbols(t *testing.T) {
+	for _, symbol := range referenceSymbols {
+		code, err := Encode(referenceData(symbol.length), symbol.level)
+		require.NoError(t, err, symbol.file)
+		require.Equal(t, symbol.version, code.Version, symbol.file)
+		require.Equal(t, symbol.mask, code.Mask, symbol.file)
+
+		// Compare pixels
+		expected := readReferenceSymbol(t, symbol.file)
+		actual := code.Image(4)
+		require.Equal(t, expected.Bounds(), actual.Bounds(), symbol.file)
+		mismatches := 0
+		for y := range actual.Bounds().Dy() {
+			for x := range actual.Bounds().Dx() {
+				expectedGray, _, _, _ := expected.At(x, y).RGBA()
+				actualGray, _, _, _ := actual.At(x, y).RGBA()
+				if (expectedGray < 0x8000) != (actualGray < 0x8000) {
+					mismatches++
+				}
+			}
+		}
+		require.Zero(t, mismatches, "%s: pixels differ from reference", symbol.file)
+	}
+}
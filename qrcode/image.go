@@ -0,0 +1,41 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// QuietZone is the number of light modules required around a QR code
+const QuietZone = 4
+
+// Image renders the QR code including quiet zone, with each module being moduleSize x moduleSize pixels
+func (c *Code) Image(moduleSize int) *image.Paletted {
+	size := (c.Size() + 2*QuietZone) * moduleSize
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := range c.Size() {
+		for x := range c.Size() {
+			if !c.Dark(x, y) {
+				continue
+			}
+			for dy := range moduleSize {
+				offset := img.PixOffset((x+QuietZone)*moduleSize, (y+QuietZone)*moduleSize+dy)
+				for dx := range moduleSize {
+					img.Pix[offset+dx] = 1
+				}
+			}
+		}
+	}
+	return img
+}
+
+// PNG renders the QR code as PNG image, with each module being moduleSize x moduleSize pixels
+func (c *Code) PNG(moduleSize int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.Image(moduleSize)); err != nil {
+		return nil, fmt.Errorf("failed to encode QR code as PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,210 @@
+package qrcode
+
+// matrix contains the modules of a QR code, together with the positions of the function patterns
+type matrix struct {
+	size       int
+	modules    []bool // Row-major, true is dark
+	isFunction []bool // Row-major, true if module is part of a function pattern or format/version information
+}
+
+// newFunctionMatrix returns a matrix with all function patterns drawn and the format information reserved
+func newFunctionMatrix(version int) *matrix {
+	size := sizeForVersion(version)
+	m := &matrix{
+		size:       size,
+		modules:    make([]bool, size*size),
+		isFunction: make([]bool, size*size),
+	}
+
+	// Draw timing patterns
+	for i := range size {
+		m.setFunction(6, i, i%2 == 0)
+		m.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Draw finder patterns
+	m.drawFinderPattern(3, 3)
+	m.drawFinderPattern(size-4, 3)
+	m.drawFinderPattern(3, size-4)
+
+	// Draw alignment patterns, except the ones overlapping with the finder patterns
+	positions := alignmentPatternPositions(version)
+	for i, x := range positions {
+		for j, y := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == len(positions)-1) || (i == len(positions)-1 && j == 0) {
+				continue
+			}
+			m.drawAlignmentPattern(x, y)
+		}
+	}
+
+	// Reserve format information and draw version information
+	m.drawFormatBits(0)
+	m.drawVersionBits(version)
+	return m
+}
+
+func (m *matrix) get(x, y int) bool {
+	return m.modules[y*m.size+x]
+}
+
+func (m *matrix) set(x, y int, dark bool) {
+	m.modules[y*m.size+x] = dark
+}
+
+func (m *matrix) setFunction(x, y int, dark bool) {
+	m.modules[y*m.size+x] = dark
+	m.isFunction[y*m.size+x] = true
+}
+
+// drawFinderPattern draws a finder pattern including separator, centered at (x, y)
+func (m *matrix) drawFinderPattern(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= m.size || yy < 0 || yy >= m.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy)) // Chebyshev distance
+			m.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawAlignmentPattern draws an alignment pattern centered at (x, y)
+func (m *matrix) drawAlignmentPattern(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			m.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// formatBits returns the 15 bits of format information, including BCH error correction and mask
+func formatBits(level ECCLevel, mask int) uint {
+	data := level.formatBits()<<3 | uint(mask)
+	rem := data
+	for range 10 {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// formatBitPositions returns the positions of bit i of the format information for both copies
+func formatBitPositions(size, i int) (x1, y1, x2, y2 int) {
+	// First copy around the top left finder pattern
+	switch {
+	case i < 6:
+		x1, y1 = 8, i
+	case i < 8:
+		x1, y1 = 8, i+1
+	case i == 8:
+		x1, y1 = 7, 8
+	default:
+		x1, y1 = 14-i, 8
+	}
+
+	// Second copy split between the other finder patterns
+	if i < 8 {
+		x2, y2 = size-1-i, 8
+	} else {
+		x2, y2 = 8, size-15+i
+	}
+	return x1, y1, x2, y2
+}
+
+func (m *matrix) drawFormatBits(bits uint) {
+	for i := range 15 {
+		x1, y1, x2, y2 := formatBitPositions(m.size, i)
+		m.setFunction(x1, y1, bits>>i&1 != 0)
+		m.setFunction(x2, y2, bits>>i&1 != 0)
+	}
+	m.setFunction(8, m.size-8, true) // Always dark module
+}
+
+// versionBits returns the 18 bits of version information, including BCH error correction
+func versionBits(version int) uint {
+	rem := uint(version)
+	for range 12 {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	return uint(version)<<12 | rem
+}
+
+func (m *matrix) drawVersionBits(version int) {
+	if version < 7 {
+		return
+	}
+	bits := versionBits(version)
+	for i := range 18 {
+		a, b := m.size-11+i%3, i/3
+		m.setFunction(a, b, bits>>i&1 != 0)
+		m.setFunction(b, a, bits>>i&1 != 0)
+	}
+}
+
+// dataModulePositions returns the positions of the data modules in the order of the zigzag placement
+func (m *matrix) dataModulePositions() [][2]int {
+	positions := make([][2]int, 0, len(m.modules))
+	for right := m.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // Skip vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := range m.size {
+			y := vert
+			if upward {
+				y = m.size - 1 - vert
+			}
+			for j := range 2 {
+				x := right - j
+				if !m.isFunction[y*m.size+x] {
+					positions = append(positions, [2]int{x, y})
+				}
+			}
+		}
+	}
+	return positions
+}
+
+// applyMask inverts the data modules which match the provided mask pattern.
+// Applying the same mask twice reverts the operation.
+func (m *matrix) applyMask(mask int) {
+	for y := range m.size {
+		for x := range m.size {
+			if !m.isFunction[y*m.size+x] && maskApplies(mask, x, y) {
+				m.modules[y*m.size+x] = !m.modules[y*m.size+x]
+			}
+		}
+	}
+}
+
+func maskApplies(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	case 7:
+		return ((x+y)%2+x*y%3)%2 == 0
+	default:
+		panic("invalid mask")
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
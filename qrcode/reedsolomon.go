@@ -0,0 +1,204 @@
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Arithmetic in GF(2^8) with the primitive polynomial x^8 + x^4 + x^3 + x^2 + 1 used by QR codes
+const gfPrimitive = 0x11D
+
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := range 255 {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPrimitive
+		}
+	}
+	// Duplicate table to avoid modulo operations on multiplication
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// rsGenerator returns the coefficients of the generator polynomial (x - α^0)(x - α^1)...(x - α^(degree-1)),
+// highest degree first and omitting the leading 1.
+func rsGenerator(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1 // Start with monomial x^0
+	root := byte(1)
+	for range degree {
+		// Multiply the current product by (x - root)
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords for the data, based on the generator from rsGenerator
+func rsRemainder(data, generator []byte) []byte {
+	result := make([]byte, len(generator))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range generator {
+			result[i] ^= gfMul(coef, factor)
+		}
+	}
+	return result
+}
+
+func gfDiv(a, b byte) byte {
+	if b == 0 {
+		panic("division by zero")
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// gfPow returns α^exponent
+func gfPow(exponent int) byte {
+	exponent %= 255
+	if exponent < 0 {
+		exponent += 255
+	}
+	return gfExp[exponent]
+}
+
+// polyEval evaluates the polynomial with coefficients ordered lowest degree first
+func polyEval(poly []byte, x byte) byte {
+	var result byte
+	for i := len(poly) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ poly[i]
+	}
+	return result
+}
+
+// rsDecode corrects the errors in place in the block, which consists of data codewords followed by eccLen error
+// correction codewords. Returns the indexes in the block of the corrected codewords. Based on the Berlekamp-Massey
+// algorithm, Chien search and Forney algorithm.
+func rsDecode(block []byte, eccLen int) ([]int, error) {
+	// Calculate syndromes
+	syndromes := make([]byte, eccLen)
+	hasErrors := false
+	for i := range syndromes {
+		x := gfPow(i)
+		for _, c := range block {
+			syndromes[i] = gfMul(syndromes[i], x) ^ c
+		}
+		hasErrors = hasErrors || syndromes[i] != 0
+	}
+	if !hasErrors {
+		return nil, nil
+	}
+
+	// Calculate error locator polynomial with Berlekamp-Massey
+	locator := []byte{1}
+	prevLocator := []byte{1}
+	prevDiscrepancy := byte(1)
+	numErrors, shift := 0, 1
+	for r := range eccLen {
+		discrepancy := syndromes[r]
+		for i := 1; i <= numErrors && i < len(locator); i++ {
+			discrepancy ^= gfMul(locator[i], syndromes[r-i])
+		}
+		if discrepancy == 0 {
+			shift++
+			continue
+		}
+		coef := gfDiv(discrepancy, prevDiscrepancy)
+		newLocator := make([]byte, max(len(locator), len(prevLocator)+shift))
+		copy(newLocator, locator)
+		for i, c := range prevLocator {
+			newLocator[i+shift] ^= gfMul(coef, c)
+		}
+		if 2*numErrors <= r {
+			prevLocator, prevDiscrepancy = locator, discrepancy
+			numErrors = r + 1 - numErrors
+			shift = 1
+		} else {
+			shift++
+		}
+		locator = newLocator
+	}
+	if 2*numErrors > eccLen {
+		return nil, fmt.Errorf("too many errors to correct (at least %d, max %d)", numErrors, eccLen/2)
+	}
+	locator = locator[:numErrors+1]
+
+	// Find error positions with Chien search.
+	// Codeword at index k has exponent n-1-k, which means its error locator is α^(n-1-k).
+	n := len(block)
+	positions := make([]int, 0, numErrors)
+	for k := range n {
+		if polyEval(locator, gfPow(-(n-1-k))) == 0 {
+			positions = append(positions, k)
+		}
+	}
+	if len(positions) != numErrors {
+		return nil, fmt.Errorf("failed to locate errors (found %d, expected %d)", len(positions), numErrors)
+	}
+
+	// Calculate error evaluator polynomial: Ω(x) = S(x)Λ(x) mod x^eccLen
+	evaluator := make([]byte, eccLen)
+	for i, s := range syndromes {
+		for j, l := range locator {
+			if i+j < eccLen {
+				evaluator[i+j] ^= gfMul(s, l)
+			}
+		}
+	}
+
+	// Calculate formal derivative of error locator polynomial
+	derivative := make([]byte, len(locator)-1)
+	for i := 1; i < len(locator); i += 2 {
+		derivative[i-1] = locator[i]
+	}
+
+	// Correct errors with Forney algorithm: e = X * Ω(X^-1) / Λ'(X^-1)
+	for _, k := range positions {
+		x := gfPow(n - 1 - k)
+		xInv := gfPow(-(n - 1 - k))
+		denominator := polyEval(derivative, xInv)
+		if denominator == 0 {
+			return nil, errors.New("failed to calculate error magnitude")
+		}
+		block[k] ^= gfMul(x, gfDiv(polyEval(evaluator, xInv), denominator))
+	}
+
+	// Validate correction
+	for i := range eccLen {
+		var syndrome byte
+		x := gfPow(i)
+		for _, c := range block {
+			syndrome = gfMul(syndrome, x) ^ c
+		}
+		if syndrome != 0 {
+			return nil, errors.New("correction failed validation")
+		}
+	}
+	return positions, nil
+}
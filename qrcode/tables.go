@@ -0,0 +1,130 @@
+package qrcode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ECCLevel is the error correction level of a QR code
+type ECCLevel uint8
+
+const (
+	// ECCLevelL recovers up to 7% of the codewords
+	ECCLevelL ECCLevel = iota
+	// ECCLevelM recovers up to 15% of the codewords
+	ECCLevelM
+	// ECCLevelQ recovers up to 25% of the codewords
+	ECCLevelQ
+	// ECCLevelH recovers up to 30% of the codewords
+	ECCLevelH
+)
+
+const (
+	MinVersion = 1
+	MaxVersion = 40
+)
+
+func (l ECCLevel) String() string {
+	switch l {
+	case ECCLevelL:
+		return "L"
+	case ECCLevelM:
+		return "M"
+	case ECCLevelQ:
+		return "Q"
+	case ECCLevelH:
+		return "H"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint8(l))
+	}
+}
+
+// ParseECCLevel parses the level from its letter, e.g. "Q"
+func ParseECCLevel(input string) (ECCLevel, error) {
+	for level := ECCLevelL; level <= ECCLevelH; level++ {
+		if strings.EqualFold(strings.TrimSpace(input), level.String()) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf(`error correction level must be one of L, M, Q or H, but got "%s"`, input)
+}
+
+// formatBits returns the 2 bits which represent the level in the format information
+func (l ECCLevel) formatBits() uint {
+	return [...]uint{1, 0, 3, 2}[l]
+}
+
+// eccLevelFromFormatBits is the inverse of ECCLevel.formatBits
+func eccLevelFromFormatBits(bits uint) ECCLevel {
+	return [...]ECCLevel{ECCLevelM, ECCLevelL, ECCLevelH, ECCLevelQ}[bits&3]
+}
+
+// See https://www.thonky.com/qr-code-tutorial/error-correction-table.
+// Index 0 is unused, so the tables can be indexed by version.
+var (
+	eccCodewordsPerBlock = [4][MaxVersion + 1]int{
+		{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+		{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	}
+	numErrorCorrectionBlocks = [4][MaxVersion + 1]int{
+		{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+		{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+		{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+		{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+	}
+)
+
+// sizeForVersion returns the number of modules on each side of a QR code
+func sizeForVersion(version int) int {
+	return version*4 + 17
+}
+
+// numRawDataModules returns the number of modules available for data and error correction codewords.
+// Based on https://www.nayuki.io/page/qr-code-generator-library.
+func numRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// numDataCodewords returns the number of 8-bit data codewords (excluding error correction)
+func numDataCodewords(version int, level ECCLevel) int {
+	return numRawDataModules(version)/8 - eccCodewordsPerBlock[level][version]*numErrorCorrectionBlocks[level][version]
+}
+
+// charCountBits returns the length of the character count indicator in byte mode
+func charCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// Capacity returns the maximum number of bytes which can be stored in byte mode
+func Capacity(version int, level ECCLevel) int {
+	return (numDataCodewords(version, level)*8 - 4 - charCountBits(version)) / 8
+}
+
+// alignmentPatternPositions returns the center coordinates of the alignment patterns on both axes.
+// Based on https://www.nayuki.io/page/qr-code-generator-library.
+func alignmentPatternPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, sizeForVersion(version)-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
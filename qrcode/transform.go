@@ -0,0 +1,70 @@
+package qrcode
+
+// perspectiveTransform maps points between two quadrilaterals.
+// Based on the PerspectiveTransform of ZXing.
+type perspectiveTransform struct {
+	a11, a12, a13, a21, a22, a23, a31, a32, a33 float64
+}
+
+type point struct {
+	x, y float64
+}
+
+// newQuadrilateralToQuadrilateral returns the transform which maps the source points onto the destination points
+func newQuadrilateralToQuadrilateral(src, dst [4]point) perspectiveTransform {
+	return squareToQuadrilateral(dst).times(quadrilateralToSquare(src))
+}
+
+func squareToQuadrilateral(q [4]point) perspectiveTransform {
+	dx3 := q[0].x - q[1].x + q[2].x - q[3].x
+	dy3 := q[0].y - q[1].y + q[2].y - q[3].y
+	if dx3 == 0 && dy3 == 0 {
+		// Affine
+		return perspectiveTransform{
+			q[1].x - q[0].x, q[2].x - q[1].x, q[0].x,
+			q[1].y - q[0].y, q[2].y - q[1].y, q[0].y,
+			0, 0, 1,
+		}
+	}
+	dx1 := q[1].x - q[2].x
+	dx2 := q[3].x - q[2].x
+	dy1 := q[1].y - q[2].y
+	dy2 := q[3].y - q[2].y
+	denominator := dx1*dy2 - dx2*dy1
+	a13 := (dx3*dy2 - dx2*dy3) / denominator
+	a23 := (dx1*dy3 - dx3*dy1) / denominator
+	return perspectiveTransform{
+		q[1].x - q[0].x + a13*q[1].x, q[3].x - q[0].x + a23*q[3].x, q[0].x,
+		q[1].y - q[0].y + a13*q[1].y, q[3].y - q[0].y + a23*q[3].y, q[0].y,
+		a13, a23, 1,
+	}
+}
+
+func quadrilateralToSquare(q [4]point) perspectiveTransform {
+	// Adjoint is the inverse up to a scale factor, which is irrelevant for projective transforms
+	return squareToQuadrilateral(q).adjoint()
+}
+
+func (t perspectiveTransform) adjoint() perspectiveTransform {
+	return perspectiveTransform{
+		t.a22*t.a33 - t.a23*t.a32, t.a13*t.a32 - t.a12*t.a33, t.a12*t.a23 - t.a13*t.a22,
+		t.a23*t.a31 - t.a21*t.a33, t.a11*t.a33 - t.a13*t.a31, t.a13*t.a21 - t.a11*t.a23,
+		t.a21*t.a32 - t.a22*t.a31, t.a12*t.a31 - t.a11*t.a32, t.a11*t.a22 - t.a12*t.a21,
+	}
+}
+
+func (t perspectiveTransform) times(o perspectiveTransform) perspectiveTransform {
+	return perspectiveTransform{
+		t.a11*o.a11 + t.a12*o.a21 + t.a13*o.a31, t.a11*o.a12 + t.a12*o.a22 + t.a13*o.a32, t.a11*o.a13 + t.a12*o.a23 + t.a13*o.a33,
+		t.a21*o.a11 + t.a22*o.a21 + t.a23*o.a31, t.a21*o.a12 + t.a22*o.a22 + t.a23*o.a32, t.a21*o.a13 + t.a22*o.a23 + t.a23*o.a33,
+		t.a31*o.a11 + t.a32*o.a21 + t.a33*o.a31, t.a31*o.a12 + t.a32*o.a22 + t.a33*o.a32, t.a31*o.a13 + t.a32*o.a23 + t.a33*o.a33,
+	}
+}
+
+func (t perspectiveTransform) apply(p point) point {
+	denominator := t.a31*p.x + t.a32*p.y + t.a33
+	return point{
+		x: (t.a11*p.x + t.a12*p.y + t.a13) / denominator,
+		y: (t.a21*p.x + t.a22*p.y + t.a23) / denominator,
+	}
+}
@@ -0,0 +1,783 @@
+package scan
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"golang.org/x/image/ccitt"
+)
+
+const pdfHeader = "%PDF-"
+
+// maxImagePixels limits the size of images, so a corrupted or hostile PDF file can't exhaust memory
+const maxImagePixels = 1 << 28
+
+// maxLookupTableSize is the size of the largest lookup table of an indexed color space: 256 colors of 4 components
+const maxLookupTableSize = 256 * 4
+
+// decodePDF returns the images embedded in a PDF file, e.g. as produced by a scanner.
+// PDF is not rendered, so only images drawn as image XObject are found. Images are returned in order of appearance in the file.
+func decodePDF(data []byte) ([]Page, error) {
+	// Find images
+	doc := newPDFDocument(data)
+	if doc.encrypted() {
+		return nil, errors.New("encrypted PDF files are not supported")
+	}
+	var images []pdfStream
+	masks := make(map[int]bool)
+	for _, num := range doc.objectNumbers() {
+		stream, ok := doc.object(num).(pdfStream)
+		if !ok || stream.dict["Subtype"] != pdfName("Image") {
+			continue
+		}
+		stream.num = num
+		images = append(images, stream)
+
+		// Masks define the transparency of other images
+		for _, key := range []string{"SMask", "Mask"} {
+			if ref, ok := stream.dict[key].(pdfRef); ok {
+				masks[ref.num] = true
+			}
+		}
+	}
+	images = slices.DeleteFunc(images, func(s pdfStream) bool { return masks[s.num] })
+	if len(images) == 0 {
+		return nil, errors.New("PDF file doesn't contain any images")
+	}
+
+	// Decode images
+	pages := make([]Page, 0, len(images))
+	for i, stream := range images {
+		img, err := doc.decodeImage(stream)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image %d (object %d) in PDF file: %w", i+1, stream.num, err)
+		}
+		pages = append(pages, Page{Name: fmt.Sprintf("image %d", i+1), Image: img})
+	}
+	if len(pages) == 1 {
+		pages[0].Name = ""
+	}
+	return pages, nil
+}
+
+// Objects in a PDF file. Integers are int64, real numbers are float64.
+type (
+	pdfName   string
+	pdfString []byte
+	pdfDict   map[string]any
+	pdfArray  []any
+	pdfRef    struct{ num, gen int }
+	pdfStream struct {
+		num  int // Object number
+		dict pdfDict
+		data []byte // Raw, still encoded data
+	}
+)
+
+var pdfObjectRegexp = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// pdfDocument provides access to the objects in a PDF file.
+// Cross-reference table is ignored, objects are located by searching for their definitions instead.
+// This supports damaged files and files with incremental updates, as later definitions override earlier ones.
+type pdfDocument struct {
+	data    []byte
+	offsets map[int]int // Offset of the object content by object number
+	order   []int       // Object numbers in order of appearance
+
+	parsing map[int]bool // Objects currently being parsed, to detect reference cycles
+}
+
+func newPDFDocument(data []byte) *pdfDocument {
+	doc := &pdfDocument{data: data, offsets: make(map[int]int), parsing: make(map[int]bool)}
+	for _, match := range pdfObjectRegexp.FindAllSubmatchIndex(data, -1) {
+		num, err := strconv.Atoi(string(data[match[2]:match[3]]))
+		if err != nil {
+			continue
+		}
+		if _, ok := doc.offsets[num]; !ok {
+			doc.order = append(doc.order, num)
+		}
+		doc.offsets[num] = match[1]
+	}
+	return doc
+}
+
+// objectNumbers returns the numbers of all objects in order of appearance
+func (d *pdfDocument) objectNumbers() []int {
+	return d.order
+}
+
+// object returns the parsed object or nil if the object doesn't exist, is malformed or refers to itself
+func (d *pdfDocument) object(num int) any {
+	offset, ok := d.offsets[num]
+	if !ok || d.parsing[num] {
+		return nil
+	}
+	d.parsing[num] = true
+	defer delete(d.parsing, num)
+	p := &pdfParser{data: d.data, pos: offset}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil
+	}
+
+	// Check for stream
+	dict, ok := value.(pdfDict)
+	if !ok || p.nextKeyword() != "stream" {
+		return value
+	}
+	if bytes.HasPrefix(d.data[p.pos:], []byte("\r\n")) {
+		p.pos += 2
+	} else if p.pos < len(d.data) && (d.data[p.pos] == '\n' || d.data[p.pos] == '\r') {
+		p.pos++
+	}
+	start := p.pos
+
+	// Prefer stated length, but fall back to searching for the end of the stream
+	end := -1
+	if length, ok := d.resolve(dict["Length"]).(int64); ok && length >= 0 && start+int(length) <= len(d.data) {
+		end = start + int(length)
+		after := bytes.TrimLeft(d.data[end:min(end+32, len(d.data))], "\r\n\t ")
+		if !bytes.HasPrefix(after, []byte("endstream")) {
+			end = -1
+		}
+	}
+	if end == -1 {
+		index := bytes.Index(d.data[start:], []byte("endstream"))
+		if index == -1 {
+			return nil
+		}
+		end = start + index
+		for end > start && (d.data[end-1] == '\n' || d.data[end-1] == '\r') {
+			end--
+		}
+	}
+	return pdfStream{num: num, dict: dict, data: d.data[start:end]}
+}
+
+// resolve returns the referenced object if value is a reference
+func (d *pdfDocument) resolve(value any) any {
+	for range 32 { // Limit to prevent endless loops
+		ref, ok := value.(pdfRef)
+		if !ok {
+			return value
+		}
+		value = d.object(ref.num)
+	}
+	return nil
+}
+
+// encrypted returns true if a trailer references an encryption dictionary
+func (d *pdfDocument) encrypted() bool {
+	for _, match := range regexp.MustCompile(`trailer\s*<<`).FindAllIndex(d.data, -1) {
+		p := &pdfParser{data: d.data, pos: match[0] + len("trailer")}
+		if trailer, err := p.parseValue(); err == nil {
+			if _, ok := trailer.(pdfDict)["Encrypt"]; ok {
+				return true
+			}
+		}
+	}
+	for _, num := range d.order {
+		if stream, ok := d.object(num).(pdfStream); ok && stream.dict["Type"] == pdfName("XRef") {
+			if _, ok := stream.dict["Encrypt"]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeStream applies the filters of the stream. Decoding stops at the first image filter, which is returned together with its parameters.
+// Decompressed data is limited to maxSize bytes.
+func (d *pdfDocument) decodeStream(stream pdfStream, maxSize int) (data []byte, imageFilter pdfName, imageParams pdfDict, err error) {
+	filters := d.resolveArray(stream.dict["Filter"])
+	params := d.resolveArray(stream.dict["DecodeParms"])
+	data = stream.data
+	for i, filter := range filters {
+		var filterParams pdfDict
+		if i < len(params) {
+			filterParams, _ = d.resolve(params[i]).(pdfDict)
+		}
+		switch name, _ := filter.(pdfName); name {
+		case "FlateDecode", "Fl":
+			data, err = inflate(data, maxSize)
+			if err == nil {
+				data, err = d.applyPredictor(data, filterParams)
+			}
+		case "ASCIIHexDecode", "AHx":
+			data, err = decodeASCIIHex(data)
+		case "ASCII85Decode", "A85":
+			data, err = decodeASCII85(data)
+		case "DCTDecode", "DCT", "CCITTFaxDecode", "CCF":
+			if i != len(filters)-1 {
+				return nil, "", nil, fmt.Errorf("image filter %s must be the last filter", name)
+			}
+			return data, name, filterParams, nil
+		default:
+			return nil, "", nil, fmt.Errorf("filter %v is not supported", filter)
+		}
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failed to apply filter %v: %w", filter, err)
+		}
+	}
+	return data, "", nil, nil
+}
+
+// resolveArray returns the value as array. A single value is returned as array with a single element.
+func (d *pdfDocument) resolveArray(value any) pdfArray {
+	switch value := d.resolve(value).(type) {
+	case nil:
+		return nil
+	case pdfArray:
+		return value
+	default:
+		return pdfArray{value}
+	}
+}
+
+// resolveInt returns the value as integer or the default value if not set
+func (d *pdfDocument) resolveInt(value any, defaultValue int) int {
+	switch value := d.resolve(value).(type) {
+	case int64:
+		return int(value)
+	case float64:
+		return int(value)
+	default:
+		return defaultValue
+	}
+}
+
+// applyPredictor reverses the PNG predictors which might be applied before compression
+func (d *pdfDocument) applyPredictor(data []byte, params pdfDict) ([]byte, error) {
+	predictor := d.resolveInt(params["Predictor"], 1)
+	switch {
+	case predictor == 1:
+		return data, nil
+	case predictor < 10:
+		return nil, fmt.Errorf("predictor %d is not supported", predictor)
+	}
+	colors := d.resolveInt(params["Colors"], 1)
+	bitsPerComponent := d.resolveInt(params["BitsPerComponent"], 8)
+	columns := d.resolveInt(params["Columns"], 1)
+	if colors < 1 || bitsPerComponent < 1 || columns < 1 {
+		return nil, errors.New("invalid predictor parameters")
+	}
+	bytesPerPixel := max(1, colors*bitsPerComponent/8)
+	rowSize := (colors*bitsPerComponent*columns + 7) / 8
+
+	// Each row is prefixed with the PNG filter type
+	output := make([]byte, 0, len(data)/(rowSize+1)*rowSize)
+	previous := make([]byte, rowSize)
+	for start := 0; start+1+rowSize <= len(data); start += 1 + rowSize {
+		filter, row := data[start], slices.Clone(data[start+1:start+1+rowSize])
+		for i := range row {
+			var left, upperLeft byte
+			if i >= bytesPerPixel {
+				left, upperLeft = row[i-bytesPerPixel], previous[i-bytesPerPixel]
+			}
+			up := previous[i]
+			switch filter {
+			case 0: // None
+			case 1: // Sub
+				row[i] += left
+			case 2: // Up
+				row[i] += up
+			case 3: // Average
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4: // Paeth
+				row[i] += paeth(left, up, upperLeft)
+			default:
+				return nil, fmt.Errorf("PNG filter type %d is invalid", filter)
+			}
+		}
+		output = append(output, row...)
+		previous = row
+	}
+	return output, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	default:
+		return c
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// inflate decompresses the zlib data. Decompressed data larger than maxSize is rejected, which prevents compression bombs.
+func inflate(data []byte, maxSize int) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	output, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if len(output) > maxSize {
+		return nil, fmt.Errorf("decompressed data exceeds the expected maximum of %d bytes", maxSize)
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) && len(output) > 0 {
+		// Some writers omit the checksum at the end of the stream
+		return output, nil
+	}
+	return output, err
+}
+
+func decodeASCIIHex(data []byte) ([]byte, error) {
+	var digits []byte
+	for _, c := range data {
+		if c == '>' {
+			break
+		}
+		if !isPDFWhitespace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	return hex.DecodeString(string(digits))
+}
+
+func decodeASCII85(data []byte) ([]byte, error) {
+	if index := bytes.Index(data, []byte("~>")); index != -1 {
+		data = data[:index]
+	}
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+	output := make([]byte, len(data))
+	n, _, err := ascii85.Decode(output, data, true)
+	return output[:n], err
+}
+
+// decodeImage converts the image XObject to an image
+func (d *pdfDocument) decodeImage(stream pdfStream) (image.Image, error) {
+	width := d.resolveInt(stream.dict["Width"], 0)
+	height := d.resolveInt(stream.dict["Height"], 0)
+	if width <= 0 || height <= 0 || width*height > maxImagePixels {
+		return nil, fmt.Errorf("image size %dx%d is invalid", width, height)
+	}
+	// Samples have at most 4 components of 8 bits, and each row might be prefixed by a PNG predictor
+	data, imageFilter, imageParams, err := d.decodeStream(stream, height*(4*width+1))
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode array inverts the image if the first range is descending, e.g. [1 0]
+	inverted := false
+	if decode := d.resolveArray(stream.dict["Decode"]); len(decode) >= 2 {
+		inverted = d.resolveInt(decode[0], 0) > d.resolveInt(decode[1], 0)
+	}
+
+	switch imageFilter {
+	case "DCTDecode", "DCT":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode JPEG image: %w", err)
+		}
+		return img, nil
+	case "CCITTFaxDecode", "CCF":
+		subFormat := ccitt.Group3
+		if d.resolveInt(imageParams["K"], 0) < 0 {
+			subFormat = ccitt.Group4
+		}
+		// If BlackIs1, the decoded white runs are 0 bits which are rendered as black
+		blackIs1 := d.resolve(imageParams["BlackIs1"]) == true
+		if columns := d.resolveInt(imageParams["Columns"], width); columns != width {
+			return nil, fmt.Errorf("CCITT image has %d columns, but image width is %d", columns, width)
+		}
+		img := image.NewGray(image.Rect(0, 0, width, height))
+		opts := &ccitt.Options{Align: d.resolve(imageParams["EncodedByteAlign"]) == true, Invert: blackIs1 != inverted}
+		if err = ccitt.DecodeIntoGray(img, bytes.NewReader(data), ccitt.MSB, subFormat, opts); err != nil {
+			return nil, fmt.Errorf("failed to decode CCITT image: %w", err)
+		}
+		return img, nil
+	}
+	return d.decodeRawImage(stream.dict, data, width, height, inverted)
+}
+
+// decodeRawImage converts raw samples to a grayscale image, which is sufficient to scan QR codes
+func (d *pdfDocument) decodeRawImage(dict pdfDict, data []byte, width, height int, inverted bool) (image.Image, error) {
+	// Determine color space
+	bitsPerComponent := d.resolveInt(dict["BitsPerComponent"], 1)
+	switch bitsPerComponent {
+	case 1, 2, 4, 8:
+	default:
+		return nil, fmt.Errorf("%d bits per component is not supported", bitsPerComponent)
+	}
+	var toGray func(samples []int) uint8
+	components := 1
+	maxSample := (1 << bitsPerComponent) - 1
+	scale := func(sample int) uint8 { return uint8(sample * 255 / maxSample) }
+	if d.resolve(dict["ImageMask"]) == true {
+		// Samples of 0 are painted black, samples of 1 are transparent
+		toGray = func(samples []int) uint8 { return scale(samples[0]) }
+	} else {
+		var err error
+		components, toGray, err = d.colorSpace(dict["ColorSpace"], scale)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Convert samples
+	rowSize := (width*components*bitsPerComponent + 7) / 8
+	if len(data) < rowSize*height {
+		return nil, fmt.Errorf("image data is %d bytes, but %d bytes are expected", len(data), rowSize*height)
+	}
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	samples := make([]int, components)
+	for y := range height {
+		row := data[y*rowSize : (y+1)*rowSize]
+		for x := range width {
+			for c := range components {
+				bitOffset := (x*components + c) * bitsPerComponent
+				sample := int(row[bitOffset/8]>>(8-bitsPerComponent-bitOffset%8)) & maxSample
+				if inverted {
+					sample = maxSample - sample
+				}
+				samples[c] = sample
+			}
+			img.Pix[y*img.Stride+x] = toGray(samples)
+		}
+	}
+	return img, nil
+}
+
+// colorSpace returns the number of components and a function to convert samples to gray
+func (d *pdfDocument) colorSpace(value any, scale func(int) uint8) (int, func([]int) uint8, error) {
+	space := d.resolve(value)
+	var family pdfName
+	var args pdfArray
+	switch space := space.(type) {
+	case pdfName:
+		family = space
+	case pdfArray:
+		if len(space) == 0 {
+			return 0, nil, errors.New("color space is empty")
+		}
+		family, _ = space[0].(pdfName)
+		args = space[1:]
+	}
+
+	switch family {
+	case "DeviceGray", "G", "CalGray":
+		return 1, func(s []int) uint8 { return scale(s[0]) }, nil
+	case "DeviceRGB", "RGB", "CalRGB":
+		return 3, func(s []int) uint8 { return rgbToGray(scale(s[0]), scale(s[1]), scale(s[2])) }, nil
+	case "DeviceCMYK", "CMYK":
+		return 4, func(s []int) uint8 {
+			r, g, b := color.CMYKToRGB(scale(s[0]), scale(s[1]), scale(s[2]), scale(s[3]))
+			return rgbToGray(r, g, b)
+		}, nil
+	case "ICCBased":
+		if len(args) > 0 {
+			if profile, ok := d.resolve(args[0]).(pdfStream); ok {
+				switch d.resolveInt(profile.dict["N"], 0) {
+				case 1:
+					return d.colorSpace(pdfName("DeviceGray"), scale)
+				case 3:
+					return d.colorSpace(pdfName("DeviceRGB"), scale)
+				case 4:
+					return d.colorSpace(pdfName("DeviceCMYK"), scale)
+				}
+			}
+		}
+		return 0, nil, errors.New("ICC based color space has invalid number of components")
+	case "Indexed", "I":
+		if len(args) < 3 {
+			return 0, nil, errors.New("indexed color space is incomplete")
+		}
+		baseComponents, baseToGray, err := d.colorSpace(args[0], func(sample int) uint8 { return uint8(sample) })
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid base of indexed color space: %w", err)
+		}
+		var lookup []byte
+		switch table := d.resolve(args[2]).(type) {
+		case pdfString:
+			lookup = table
+		case pdfStream:
+			lookup, _, _, err = d.decodeStream(table, maxLookupTableSize)
+			if err != nil {
+				return 0, nil, fmt.Errorf("failed to decode lookup table of indexed color space: %w", err)
+			}
+		}
+		return 1, func(s []int) uint8 {
+			offset := s[0] * baseComponents
+			if offset+baseComponents > len(lookup) {
+				return 0
+			}
+			samples := make([]int, baseComponents)
+			for i := range samples {
+				samples[i] = int(lookup[offset+i])
+			}
+			return baseToGray(samples)
+		}, nil
+	default:
+		return 0, nil, fmt.Errorf("color space %v is not supported", space)
+	}
+}
+
+func rgbToGray(r, g, b uint8) uint8 {
+	return color.GrayModel.Convert(color.RGBA{R: r, G: g, B: b, A: 0xff}).(color.Gray).Y
+}
+
+// pdfParser parses objects from the PDF data
+type pdfParser struct {
+	data []byte
+	pos  int
+}
+
+// maxPDFNesting limits the depth of nested arrays and dictionaries
+const maxPDFNesting = 64
+
+func (p *pdfParser) parseValue() (any, error) {
+	return p.parseNestedValue(0)
+}
+
+func (p *pdfParser) parseNestedValue(depth int) (any, error) {
+	if depth > maxPDFNesting {
+		return nil, errors.New("objects are nested too deep")
+	}
+	p.skipWhitespace()
+	if p.pos >= len(p.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	switch c := p.data[p.pos]; {
+	case bytes.HasPrefix(p.data[p.pos:], []byte("<<")):
+		p.pos += 2
+		dict := make(pdfDict)
+		for {
+			p.skipWhitespace()
+			if bytes.HasPrefix(p.data[p.pos:], []byte(">>")) {
+				p.pos += 2
+				return dict, nil
+			}
+			key, err := p.parseNestedValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(pdfName)
+			if !ok {
+				return nil, fmt.Errorf("dictionary key at offset %d is not a name", p.pos)
+			}
+			dict[string(name)], err = p.parseNestedValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case c == '[':
+		p.pos++
+		var array pdfArray
+		for {
+			p.skipWhitespace()
+			if p.pos < len(p.data) && p.data[p.pos] == ']' {
+				p.pos++
+				return array, nil
+			}
+			value, err := p.parseNestedValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+	case c == '/':
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.data) && !isPDFWhitespace(p.data[p.pos]) && !isPDFDelimiter(p.data[p.pos]) {
+			p.pos++
+		}
+		return pdfName(decodeNameEscapes(p.data[start:p.pos])), nil
+	case c == '(':
+		return p.parseLiteralString()
+	case c == '<':
+		p.pos++
+		end := bytes.IndexByte(p.data[p.pos:], '>')
+		if end == -1 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		value, err := decodeASCIIHex(p.data[p.pos : p.pos+end])
+		p.pos += end + 1
+		return pdfString(value), err
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return p.parseNumberOrRef()
+	default:
+		switch keyword := p.nextKeyword(); keyword {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("unexpected token %q at offset %d", keyword, p.pos)
+		}
+	}
+}
+
+// parseNumberOrRef parses a number or a reference like "12 0 R"
+func (p *pdfParser) parseNumberOrRef() (any, error) {
+	number, err := p.parseNumber()
+	if err != nil {
+		return nil, err
+	}
+	num, ok := number.(int64)
+	if !ok {
+		return number, nil
+	}
+
+	// Look ahead for generation number and R
+	start := p.pos
+	p.skipWhitespace()
+	if gen, err := p.parseNumber(); err == nil {
+		if gen, ok := gen.(int64); ok {
+			p.skipWhitespace()
+			if p.nextKeyword() == "R" {
+				return pdfRef{num: int(num), gen: int(gen)}, nil
+			}
+		}
+	}
+	p.pos = start
+	return num, nil
+}
+
+func (p *pdfParser) parseNumber() (any, error) {
+	start := p.pos
+	for p.pos < len(p.data) && bytes.IndexByte([]byte("+-.0123456789"), p.data[p.pos]) != -1 {
+		p.pos++
+	}
+	token := string(p.data[start:p.pos])
+	if value, err := strconv.ParseInt(token, 10, 64); err == nil {
+		return value, nil
+	}
+	value, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		p.pos = start
+		return nil, fmt.Errorf("invalid number %q at offset %d", token, start)
+	}
+	return value, nil
+}
+
+func (p *pdfParser) parseLiteralString() (any, error) {
+	p.pos++ // Skip opening parenthesis
+	var output []byte
+	for depth := 1; p.pos < len(p.data); p.pos++ {
+		switch c := p.data[p.pos]; c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return pdfString(output), nil
+			}
+		case '\\':
+			p.pos++
+			if p.pos >= len(p.data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			escaped := p.data[p.pos]
+			switch escaped {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				continue // Line continuation
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				value := 0
+				for i := 0; i < 3 && p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+					value = value*8 + int(p.data[p.pos]-'0')
+					p.pos++
+				}
+				p.pos--
+				c = byte(value)
+			default:
+				c = escaped
+			}
+			output = append(output, c)
+			continue
+		}
+		output = append(output, p.data[p.pos])
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+// nextKeyword consumes and returns the next regular token
+func (p *pdfParser) nextKeyword() string {
+	p.skipWhitespace()
+	start := p.pos
+	for p.pos < len(p.data) && !isPDFWhitespace(p.data[p.pos]) && !isPDFDelimiter(p.data[p.pos]) {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+// skipWhitespace skips whitespace and comments
+func (p *pdfParser) skipWhitespace() {
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case isPDFWhitespace(c):
+			p.pos++
+		case c == '%':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func isPDFWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) != -1
+}
+
+// decodeNameEscapes replaces escapes like #20 in names
+func decodeNameEscapes(name []byte) string {
+	if bytes.IndexByte(name, '#') == -1 {
+		return string(name)
+	}
+	var output []byte
+	for i := 0; i < len(name); i++ {
+		if name[i] == '#' && i+2 < len(name) {
+			if value, err := strconv.ParseUint(string(name[i+1:i+3]), 16, 8); err == nil {
+				output = append(output, byte(value))
+				i += 2
+				continue
+			}
+		}
+		output = append(output, name[i])
+	}
+	return string(output)
+}
@@ -0,0 +1,92 @@
+package scan
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodePagesPDF(t *testing.T) {
+	// Indexed image of 1 bit per pixel, compressed with PNG predictor "Up"
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	_, err := writer.Write([]byte{2, 0b1010_0000, 2, 0b1100_0000})
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	pdf := buildPDF(
+		// Gray image with soft mask
+		"<< /Type /XObject /Subtype /Image /Width 4 /Height 2 /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /ASCIIHexDecode /SMask 2 0 R /Length 18 >>\nstream\n00FF80 40 10203040>\nendstream",
+		"<< /Type /XObject /Subtype /Image /Width 4 /Height 2 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length 8 >>\nstream\n\xff\xff\xff\xff\xff\xff\xff\xff\nendstream",
+		// Indexed image with indirect length
+		fmt.Sprintf("<< /Subtype /Image /Width 4 /Height 2 /ColorSpace [/Indexed /DeviceRGB 1 <000000FFFFFF>] /BitsPerComponent 1 /Filter [/FlateDecode] /DecodeParms [<< /Predictor 15 /Colors 1 /BitsPerComponent 1 /Columns 4 >>] /Length 4 0 R >>\nstream\n%s\nendstream", compressed.Bytes()),
+		fmt.Sprint(compressed.Len()),
+	)
+	pages, err := DecodePages(pdf)
+	require.NoError(t, err)
+	require.Len(t, pages, 2)
+
+	require.Equal(t, "image 1", pages[0].Name)
+	require.Equal(t, image.Rect(0, 0, 4, 2), pages[0].Image.Bounds())
+	require.Equal(t, []uint8{0x00, 0xff, 0x80, 0x40, 0x10, 0x20, 0x30, 0x40}, pages[0].Image.(*image.Gray).Pix)
+
+	// Second row is stored as difference with the first row
+	require.Equal(t, "image 2", pages[1].Name)
+	require.Equal(t, []uint8{0xff, 0x00, 0xff, 0x00, 0x00, 0xff, 0xff, 0x00}, pages[1].Image.(*image.Gray).Pix)
+}
+
+func TestDecodePagesPDFWithoutImages(t *testing.T) {
+	_, err := DecodePages(buildPDF("<< /Type /Catalog >>"))
+	require.ErrorContains(t, err, "doesn't contain any images")
+}
+
+func TestDecodePagesEncryptedPDF(t *testing.T) {
+	pdf := append(buildPDF("<< /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length 1 >>\nstream\n\x00\nendstream"),
+		[]byte("trailer\n<< /Root 1 0 R /Encrypt 2 0 R >>\n%%EOF\n")...)
+	_, err := DecodePages(pdf)
+	require.ErrorContains(t, err, "encrypted")
+}
+
+func TestDecodePagesPDFRejectsOversizedImages(t *testing.T) {
+	// Compression bomb: stream decompresses to more than the image size
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	_, err := writer.Write(make([]byte, 1<<20))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	_, err = DecodePages(buildPDF(fmt.Sprintf("<< /Subtype /Image /Width 4 /Height 2 /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", compressed.Len(), compressed.Bytes())))
+	require.ErrorContains(t, err, "exceeds the expected maximum")
+
+	// CCITT image with columns different from the width
+	_, err = DecodePages(buildPDF("<< /Subtype /Image /Width 4 /Height 2 /BitsPerComponent 1 /Filter /CCITTFaxDecode /DecodeParms << /K -1 /Columns 1000000000 >> /Length 1 >>\nstream\n\x00\nendstream"))
+	require.ErrorContains(t, err, "columns")
+}
+
+func TestDecodePagesMalformedPDF(t *testing.T) {
+	// Stream length refers to the stream itself
+	pages, err := DecodePages(buildPDF("<< /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length 1 0 R >>\nstream\n\x00\nendstream"))
+	require.NoError(t, err)
+	require.Len(t, pages, 1)
+
+	// Unsupported bits per component
+	for _, bitsPerComponent := range []string{"-1", "3", "16", "64"} {
+		_, err = DecodePages(buildPDF(fmt.Sprintf("<< /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent %s /Length 1 >>\nstream\n\x00\nendstream", bitsPerComponent)))
+		require.ErrorContains(t, err, "bits per component is not supported", bitsPerComponent)
+		_, err = DecodePages(buildPDF(fmt.Sprintf("<< /Subtype /Image /Width 1 /Height 1 /ImageMask true /BitsPerComponent %s /Length 1 >>\nstream\n\x00\nendstream", bitsPerComponent)))
+		require.ErrorContains(t, err, "bits per component is not supported", bitsPerComponent)
+	}
+}
+
+// buildPDF returns a minimal PDF file with the provided objects, numbered from 1
+func buildPDF(objects ...string) []byte {
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	for i, object := range objects {
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	return pdf.Bytes()
+}
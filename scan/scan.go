@@ -0,0 +1,35 @@
+// Package scan reads the images of scanned sheets. Besides single images, multi-page TIFF and PDF files are supported.
+package scan
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"  // Register GIF decoder
+	_ "image/jpeg" // Register JPEG decoder
+	_ "image/png"  // Register PNG decoder
+)
+
+// Page is a single image in a scanned file
+type Page struct {
+	// Name identifies the image within the file, e.g. "page 2". Empty for files with a single image.
+	Name  string
+	Image image.Image
+}
+
+// DecodePages returns all images in the file. Supports multi-page TIFF files, images embedded in PDF files
+// and single images in any format registered with the image package.
+func DecodePages(data []byte) ([]Page, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte(pdfHeader)):
+		return decodePDF(data)
+	case bytes.HasPrefix(data, []byte(tiffLittleEndianHeader)), bytes.HasPrefix(data, []byte(tiffBigEndianHeader)):
+		return decodeTIFF(data)
+	default:
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image: %w", err)
+		}
+		return []Page{{Image: img}}, nil
+	}
+}
@@ -0,0 +1,100 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/image/tiff"
+)
+
+const (
+	tiffLittleEndianHeader = "II\x2A\x00"
+	tiffBigEndianHeader    = "MM\x00\x2A"
+)
+
+// maxTIFFPages guards against endless loops in malformed files
+const maxTIFFPages = 10000
+
+// decodeTIFF decodes all pages of a TIFF file. Each page is stored as an Image File Directory (IFD).
+func decodeTIFF(data []byte) ([]Page, error) {
+	// Find offsets of IFDs
+	offsets, err := tiffIFDOffsets(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode pages. Decoder only supports the first IFD, so the header is patched to point to the IFD of each page.
+	pages := make([]Page, 0, len(offsets))
+	for i, offset := range offsets {
+		reader := &tiffPageReader{Reader: bytes.NewReader(data)}
+		copy(reader.header[:], data[:8])
+		tiffByteOrder(data).PutUint32(reader.header[4:], offset)
+		img, err := tiff.Decode(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode TIFF page %d: %w", i+1, err)
+		}
+		pages = append(pages, Page{Name: fmt.Sprintf("page %d", i+1), Image: img})
+	}
+	if len(pages) == 1 {
+		pages[0].Name = ""
+	}
+	return pages, nil
+}
+
+// tiffIFDOffsets returns the offset of each IFD in the linked list of IFDs
+func tiffIFDOffsets(data []byte) ([]uint32, error) {
+	if len(data) < 8 {
+		return nil, errors.New("TIFF file is too short for the header")
+	}
+	byteOrder := tiffByteOrder(data)
+	var offsets []uint32
+	seen := make(map[uint32]bool)
+	for offset := byteOrder.Uint32(data[4:8]); offset != 0; {
+		switch {
+		case seen[offset]:
+			return nil, fmt.Errorf("TIFF file contains a loop of IFDs at offset %d", offset)
+		case len(offsets) >= maxTIFFPages:
+			return nil, fmt.Errorf("TIFF file contains more than %d pages", maxTIFFPages)
+		case uint64(offset)+2 > uint64(len(data)):
+			return nil, fmt.Errorf("IFD offset %d is beyond end of TIFF file", offset)
+		}
+		seen[offset] = true
+		offsets = append(offsets, offset)
+
+		// IFD consists of the number of entries, the entries of 12 bytes and the offset of the next IFD
+		nextOffsetPosition := uint64(offset) + 2 + 12*uint64(byteOrder.Uint16(data[offset:]))
+		if nextOffsetPosition+4 > uint64(len(data)) {
+			return nil, fmt.Errorf("IFD at offset %d is truncated", offset)
+		}
+		offset = byteOrder.Uint32(data[nextOffsetPosition:])
+	}
+	if len(offsets) == 0 {
+		return nil, errors.New("TIFF file doesn't contain any pages")
+	}
+	return offsets, nil
+}
+
+func tiffByteOrder(data []byte) binary.ByteOrder {
+	if bytes.HasPrefix(data, []byte(tiffBigEndianHeader)) {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// tiffPageReader reads the TIFF file with a replaced header
+type tiffPageReader struct {
+	*bytes.Reader
+	header [8]byte
+}
+
+func (r *tiffPageReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.Reader.ReadAt(p, off)
+	for i := range p[:n] {
+		if position := off + int64(i); position < int64(len(r.header)) {
+			p[i] = r.header[position]
+		}
+	}
+	return n, err
+}
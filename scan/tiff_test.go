@@ -0,0 +1,76 @@
+package scan
+
+import (
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodePagesMultiPageTIFF(t *testing.T) {
+	pages, err := DecodePages(buildGrayTIFF(3, 2, 0x00, 0x80, 0xff))
+	require.NoError(t, err)
+	require.Len(t, pages, 3)
+	for i, expected := range []uint8{0x00, 0x80, 0xff} {
+		require.Equal(t, []string{"page 1", "page 2", "page 3"}[i], pages[i].Name)
+		require.Equal(t, image.Rect(0, 0, 3, 2), pages[i].Image.Bounds())
+		gray, ok := pages[i].Image.(*image.Gray)
+		require.True(t, ok)
+		require.Equal(t, expected, gray.GrayAt(2, 1).Y)
+	}
+}
+
+func TestDecodePagesSinglePageTIFF(t *testing.T) {
+	pages, err := DecodePages(buildGrayTIFF(3, 2, 0x80))
+	require.NoError(t, err)
+	require.Len(t, pages, 1)
+	require.Empty(t, pages[0].Name)
+}
+
+func TestDecodePagesTIFFWithLoop(t *testing.T) {
+	data := buildGrayTIFF(3, 2, 0x00, 0x80)
+	firstIFD := binary.LittleEndian.Uint32(data[4:8])
+	nextOffsetPosition := len(data) - 4 // Last IFD is written at the end
+	binary.LittleEndian.PutUint32(data[nextOffsetPosition:], firstIFD)
+	_, err := DecodePages(data)
+	require.ErrorContains(t, err, "loop")
+}
+
+// buildGrayTIFF returns an uncompressed, little-endian TIFF file with a page per value.
+// All pixels of a page have the same value.
+func buildGrayTIFF(width, height uint32, values ...uint8) []byte {
+	data := []byte(tiffLittleEndianHeader + "\x00\x00\x00\x00")
+	nextOffsetPosition := 4
+	for _, value := range values {
+		// Write pixels
+		stripOffset := uint32(len(data))
+		for range width * height {
+			data = append(data, value)
+		}
+
+		// Link IFD
+		binary.LittleEndian.PutUint32(data[nextOffsetPosition:], uint32(len(data)))
+		entries := [][2]uint32{
+			{256, width},          // ImageWidth
+			{257, height},         // ImageLength
+			{258, 8},              // BitsPerSample
+			{259, 1},              // Compression: none
+			{262, 1},              // PhotometricInterpretation: BlackIsZero
+			{273, stripOffset},    // StripOffsets
+			{277, 1},              // SamplesPerPixel
+			{278, height},         // RowsPerStrip
+			{279, width * height}, // StripByteCounts
+		}
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(entries)))
+		for _, entry := range entries {
+			data = binary.LittleEndian.AppendUint16(data, uint16(entry[0]))
+			data = binary.LittleEndian.AppendUint16(data, 4) // Type: LONG
+			data = binary.LittleEndian.AppendUint32(data, 1) // Count
+			data = binary.LittleEndian.AppendUint32(data, entry[1])
+		}
+		nextOffsetPosition = len(data)
+		data = binary.LittleEndian.AppendUint32(data, 0)
+	}
+	return data
+}